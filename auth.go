@@ -0,0 +1,534 @@
+// contains authentication of API clients and role checks
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// roles of identities. Each role includes previous ones
+const (
+	// RoleRead read status, limits, schedules and config
+	RoleRead = "read"
+
+	// RoleSync run sync jobs
+	RoleSync = "sync"
+
+	// RoleAdmin change limits and config
+	RoleAdmin = "admin"
+)
+
+// HMACScheme authorization scheme of signed requests:
+// Authorization: HMAC-SHA256 KeyId=<id>, Signature=<hex>
+const HMACScheme = "HMAC-SHA256"
+
+// HMACTimestampHeader unix time (seconds) of signed request
+const HMACTimestampHeader = "X-Fsync-Timestamp"
+
+// APIKeyHeader alternative header for static tokens
+const APIKeyHeader = "X-API-Key"
+
+// DefaultHMACMaxSkew max difference between request timestamp and now
+const DefaultHMACMaxSkew = 5 * time.Minute
+
+// hmacMaxBodySize max size of signed request body
+const hmacMaxBodySize = 1 << 20
+
+// identityKey gin context key of authenticated identity
+const identityKey = "identity"
+
+var roleRanks = map[string]int{RoleRead: 1, RoleSync: 2, RoleAdmin: 3}
+
+var Unauthenticated = fmt.Errorf("unauthenticated")
+
+var RequestTooLarge = fmt.Errorf("request body too large")
+
+var UnknownRole = fmt.Errorf("unknown role")
+
+// Identity is an authenticated API client
+type Identity struct {
+	Name  string   `yaml:"name" json:"name"`
+	Roles []string `yaml:"roles" json:"roles"`
+}
+
+// Has return true if identity has role or any higher role
+func (id *Identity) Has(role string) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, r := range id.Roles {
+		if roleRanks[r] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRoles check that all roles are known
+func validateRoles(name string, roles []string) (err error) {
+	if len(roles) == 0 {
+		return fmt.Errorf("%w: %q: no roles", UnknownRole, name)
+	}
+
+	for _, role := range roles {
+		if _, ok := roleRanks[role]; !ok {
+			return fmt.Errorf("%w: %q: %s", UnknownRole, name, role)
+		}
+	}
+	return err
+}
+
+// AuthConfig contains authentication settings
+type AuthConfig struct {
+	// Enabled if false all requests are allowed
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// Tokens inline static tokens, TokensFile yaml list of
+	// {name, token, roles}
+	Tokens     []TokenEntry `yaml:"tokens" json:"tokens"`
+	TokensFile string       `yaml:"tokens_file" json:"tokens_file"`
+
+	// HMACKeys inline signing keys, HMACKeysFile yaml list of
+	// {name, key_id, secret, roles}
+	HMACKeys     []HMACKeyEntry `yaml:"hmac_keys" json:"hmac_keys"`
+	HMACKeysFile string         `yaml:"hmac_keys_file" json:"hmac_keys_file"`
+	HMACMaxSkew  time.Duration  `yaml:"hmac_max_skew" json:"hmac_max_skew" validate:"gte=0"`
+
+	// ClientCerts map client certificate CN or SAN to roles
+	// (TLS listener with client CA required)
+	ClientCerts []ClientCertIdentity `yaml:"client_certs" json:"client_certs"`
+
+	// PeerCredentials map local user or group of Unix socket
+	// client to roles
+	PeerCredentials []PeerCredIdentity `yaml:"peer_credentials" json:"peer_credentials"`
+}
+
+// Validate check roles of client certificates and peer credentials
+func (c AuthConfig) Validate() (err error) {
+	if _, err = MakePeerAuth(c.PeerCredentials); err != nil {
+		return err
+	}
+
+	for _, cert := range c.ClientCerts {
+		if cert.Subject == "" {
+			return fmt.Errorf("client cert: empty subject")
+		}
+
+		if err = validateRoles(cert.Subject, cert.Roles); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// ClientCertIdentity roles of client certificate with subject
+// in CN or SAN (DNS, email or URI)
+type ClientCertIdentity struct {
+	Subject string   `yaml:"subject" json:"subject"`
+	Roles   []string `yaml:"roles" json:"roles"`
+}
+
+// TokenEntry is a static token of identity
+type TokenEntry struct {
+	Identity `yaml:",inline"`
+	Token    string `yaml:"token" redact:"true"`
+}
+
+// HMACKeyEntry is a signing key of identity
+type HMACKeyEntry struct {
+	Identity `yaml:",inline"`
+	KeyID    string `yaml:"key_id"`
+	Secret   string `yaml:"secret" redact:"true"`
+}
+
+// hmacKey secret of identity
+type hmacKey struct {
+	id     Identity
+	secret []byte
+}
+
+// Authenticator identify client of request. Return nil identity
+// and nil error if request has no credentials of its kind or they
+// aren't mapped to identity (next authenticator is tried). Error
+// is returned only for presented invalid credentials
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// TokenAuth check static bearer tokens and API keys
+type TokenAuth struct {
+	// identities by sha256 of token
+	tokens map[string]Identity
+}
+
+// MakeTokenAuth factory function return TokenAuth with tokens
+func MakeTokenAuth(entries []TokenEntry) (a *TokenAuth, err error) {
+	a = &TokenAuth{tokens: make(map[string]Identity, len(entries))}
+	for _, e := range entries {
+		if e.Token == "" {
+			return nil, fmt.Errorf("tokens: %q: empty token", e.Name)
+		}
+
+		if err = validateRoles(e.Name, e.Roles); err != nil {
+			return nil, err
+		}
+		a.tokens[tokenHash(e.Token)] = e.Identity
+	}
+
+	return a, err
+}
+
+// Authenticate request with Authorization: Bearer or X-API-Key header
+func (a *TokenAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := r.Header.Get(APIKeyHeader)
+	if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok &&
+		strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(value)
+	}
+
+	if token == "" {
+		return nil, nil
+	}
+
+	// tokens are compared by hashes - lookup time does not
+	// depend on matched prefix
+	if id, ok := a.tokens[tokenHash(token)]; ok {
+		return &id, nil
+	}
+	return nil, fmt.Errorf("%w: unknown token", Unauthenticated)
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HMACAuth check signed requests. Signature is a hex HMAC-SHA256 of
+// "METHOD\nREQUEST_URI\nTIMESTAMP\nHEX(SHA256(BODY))". Each signature
+// is accepted once, used signatures are kept until timestamp expires
+type HMACAuth struct {
+	keys    map[string]hmacKey
+	maxSkew time.Duration
+	now     func() time.Time
+
+	lock *sync.Mutex
+	used map[string]time.Time
+}
+
+// MakeHMACAuth factory function return HMACAuth with keys
+func MakeHMACAuth(entries []HMACKeyEntry, maxSkew time.Duration) (
+	a *HMACAuth,
+	err error,
+) {
+	if maxSkew <= 0 {
+		maxSkew = DefaultHMACMaxSkew
+	}
+
+	a = &HMACAuth{
+		keys:    make(map[string]hmacKey, len(entries)),
+		maxSkew: maxSkew,
+		now:     time.Now,
+		lock:    new(sync.Mutex),
+		used:    make(map[string]time.Time),
+	}
+
+	for _, e := range entries {
+		if e.KeyID == "" || e.Secret == "" {
+			return nil, fmt.Errorf("hmac keys: %q: empty key_id or secret", e.Name)
+		}
+
+		if err = validateRoles(e.Name, e.Roles); err != nil {
+			return nil, err
+		}
+		a.keys[e.KeyID] = hmacKey{id: e.Identity, secret: []byte(e.Secret)}
+	}
+
+	return a, err
+}
+
+// Authenticate signed request. Body is read and restored
+func (a *HMACAuth) Authenticate(r *http.Request) (id *Identity, err error) {
+	var body []byte
+
+	scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || scheme != HMACScheme {
+		return nil, nil
+	}
+
+	keyID, signature := parseHMACParams(params)
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", Unauthenticated, keyID)
+	}
+
+	ts := r.Header.Get(HMACTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", Unauthenticated)
+	}
+
+	if skew := a.now().Sub(time.Unix(sec, 0)).Abs(); skew > a.maxSkew {
+		return nil, fmt.Errorf("%w: request expired", Unauthenticated)
+	}
+
+	if r.Body != nil {
+		// extra byte tells that body was truncated
+		if body, err = io.ReadAll(io.LimitReader(r.Body, hmacMaxBodySize+1)); err != nil {
+			return nil, err
+		}
+
+		if len(body) > hmacMaxBodySize {
+			return nil, fmt.Errorf("%w: signed body over %d bytes", RequestTooLarge, hmacMaxBodySize)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	want := SignRequest(key.secret, r.Method, r.URL.RequestURI(), ts, body)
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, want) {
+		return nil, fmt.Errorf("%w: bad signature", Unauthenticated)
+	}
+
+	if !a.use(hex.EncodeToString(got), time.Unix(sec, 0).Add(a.maxSkew)) {
+		return nil, fmt.Errorf("%w: replayed signature", Unauthenticated)
+	}
+
+	return &key.id, nil
+}
+
+// use save signature until it expires. Return false if signature
+// was already used
+func (a *HMACAuth) use(signature string, expires time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := a.now()
+	for sig, exp := range a.used {
+		if now.After(exp) {
+			delete(a.used, sig)
+		}
+	}
+
+	if _, ok := a.used[signature]; ok {
+		return false
+	}
+	a.used[signature] = expires
+	return true
+}
+
+// SignRequest return HMAC-SHA256 signature of request
+func SignRequest(
+	secret []byte,
+	method string,
+	uri string,
+	timestamp string,
+	body []byte,
+) []byte {
+	sum := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s\n%x", method, uri, timestamp, sum)
+	return mac.Sum(nil)
+}
+
+// parseHMACParams parse "KeyId=<id>, Signature=<hex>"
+func parseHMACParams(params string) (keyID string, signature string) {
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "KeyId":
+			keyID = value
+		case "Signature":
+			signature = value
+		}
+	}
+	return keyID, signature
+}
+
+// CertAuth map verified client certificate to identity
+type CertAuth struct {
+	subjects map[string]Identity
+}
+
+// MakeCertAuth factory function return new CertAuth
+func MakeCertAuth(certs []ClientCertIdentity) *CertAuth {
+	a := &CertAuth{subjects: make(map[string]Identity, len(certs))}
+	for _, cert := range certs {
+		a.subjects[cert.Subject] = Identity{Name: cert.Subject, Roles: cert.Roles}
+	}
+	return a
+}
+
+// Authenticate request by verified client certificate. Certificate
+// without mapped subject is valid (verified by CA), so request can
+// be authenticated by other credentials
+func (a *CertAuth) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, subject := range certSubjects(cert) {
+		if id, ok := a.subjects[subject]; ok {
+			return &id, nil
+		}
+	}
+	return nil, nil
+}
+
+// certSubjects return CN and SANs of certificate
+func certSubjects(cert *x509.Certificate) []string {
+	subjects := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses))
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+
+	subjects = append(subjects, cert.DNSNames...)
+	subjects = append(subjects, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return subjects
+}
+
+// MakeAuthenticators return configured authenticators. Peer
+// credentials and client certificates are checked first, static
+// tokens last
+func MakeAuthenticators(cfg AuthConfig) (auth []Authenticator, err error) {
+	if len(cfg.PeerCredentials) > 0 {
+		var a *PeerAuth
+		if a, err = MakePeerAuth(cfg.PeerCredentials); err != nil {
+			return nil, err
+		}
+		auth = append(auth, a)
+	}
+
+	if len(cfg.ClientCerts) > 0 {
+		auth = append(auth, MakeCertAuth(cfg.ClientCerts))
+	}
+
+	keys := cfg.HMACKeys
+	if cfg.HMACKeysFile != "" {
+		var entries []HMACKeyEntry
+		if err = loadYAML(cfg.HMACKeysFile, &entries); err != nil {
+			return nil, err
+		}
+		keys = append(slices.Clone(keys), entries...)
+	}
+
+	if len(keys) > 0 {
+		var a *HMACAuth
+		if a, err = MakeHMACAuth(keys, cfg.HMACMaxSkew); err != nil {
+			return nil, err
+		}
+		auth = append(auth, a)
+	}
+
+	tokens := cfg.Tokens
+	if cfg.TokensFile != "" {
+		var entries []TokenEntry
+		if err = loadYAML(cfg.TokensFile, &entries); err != nil {
+			return nil, err
+		}
+		tokens = append(slices.Clone(tokens), entries...)
+	}
+
+	if len(tokens) > 0 {
+		var a *TokenAuth
+		if a, err = MakeTokenAuth(tokens); err != nil {
+			return nil, err
+		}
+		auth = append(auth, a)
+	}
+
+	if cfg.Enabled && len(auth) == 0 {
+		return nil, fmt.Errorf("auth enabled but no methods configured")
+	}
+
+	return auth, err
+}
+
+// loadYAML read yaml file into v
+func loadYAML(path string, v any) (err error) {
+	var buf []byte
+
+	if buf, err = os.ReadFile(path); err != nil {
+		return err
+	}
+	return yaml.Unmarshal(buf, v)
+}
+
+// authenticate middleware identify client by first authenticator
+// which found identity. Request with invalid credentials or without
+// identity is rejected with 401. If auth disabled every client
+// is an admin
+func (srv *Server) authenticate(c *gin.Context) {
+	if !srv.boot.Auth.Enabled {
+		c.Set(identityKey, &Identity{Name: "anonymous", Roles: []string{RoleAdmin}})
+		c.Next()
+		return
+	}
+
+	for _, a := range srv.auth {
+		id, err := a.Authenticate(c.Request)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, RequestTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+
+			srv.log.WithField("client", c.ClientIP()).Warn(err)
+			_ = c.AbortWithError(status, err)
+			return
+		}
+
+		if id != nil {
+			c.Set(identityKey, id)
+			c.Next()
+			return
+		}
+	}
+
+	c.Header("WWW-Authenticate", `Bearer realm="fsyncd"`)
+	_ = c.AbortWithError(http.StatusUnauthorized, Unauthenticated)
+}
+
+// require return middleware that reject identities without role
+func (srv *Server) require(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := identity(c)
+		if !id.Has(role) {
+			_ = c.AbortWithError(
+				http.StatusForbidden,
+				fmt.Errorf("%q: role %q required", id.Name, role),
+			)
+			return
+		}
+		c.Next()
+	}
+}
+
+// identity return authenticated identity of request
+func identity(c *gin.Context) *Identity {
+	if v, ok := c.Get(identityKey); ok {
+		return v.(*Identity)
+	}
+	return &Identity{}
+}
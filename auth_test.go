@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "auth.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestIdentity_Has(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []string
+		role  string
+		want  bool
+	}{
+		{name: "test admin can read", roles: []string{RoleAdmin}, role: RoleRead, want: true},
+		{name: "test sync can read", roles: []string{RoleSync}, role: RoleRead, want: true},
+		{name: "test read can`t sync", roles: []string{RoleRead}, role: RoleSync, want: false},
+		{name: "test sync can`t admin", roles: []string{RoleSync}, role: RoleAdmin, want: false},
+		{name: "test no roles", role: RoleRead, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				id := &Identity{Roles: tt.roles}
+				require.Equal(t, tt.want, id.Has(tt.role))
+			},
+		)
+	}
+}
+
+func TestHMACAuth_Authenticate(t *testing.T) {
+	path := writeFile(
+		t, `
+- name: agent
+  key_id: k1
+  secret: s3cret
+  roles: [sync]
+`,
+	)
+
+	auth, err := MakeAuthenticators(AuthConfig{HMACKeysFile: path, HMACMaxSkew: time.Minute})
+	require.NoError(t, err)
+	require.Len(t, auth, 1)
+
+	a := auth[0].(*HMACAuth)
+
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	sign := func(secret string, ts time.Time, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/sync/directories?x=1", strings.NewReader(body))
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		sig := SignRequest([]byte(secret), r.Method, r.URL.RequestURI(), stamp, []byte(body))
+		r.Header.Set("Authorization", HMACScheme+" KeyId=k1, Signature="+hex.EncodeToString(sig))
+		r.Header.Set(HMACTimestampHeader, stamp)
+		return r
+	}
+
+	large := strings.Repeat("x", hmacMaxBodySize+1)
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantID  bool
+		wantErr error
+	}{
+		{name: "test valid signature", req: sign("s3cret", now, `{"a":1}`), wantID: true},
+		{name: "test replayed signature", req: sign("s3cret", now, `{"a":1}`), wantErr: Unauthenticated},
+		{name: "test bad secret", req: sign("other", now, `{}`), wantErr: Unauthenticated},
+		{name: "test expired", req: sign("s3cret", now.Add(-time.Hour), `{}`), wantErr: Unauthenticated},
+		{name: "test body too large", req: sign("s3cret", now, large), wantErr: RequestTooLarge},
+		{name: "test no credentials", req: httptest.NewRequest(http.MethodGet, "/", nil)},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				id, err := a.Authenticate(tt.req)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tt.wantID, id != nil)
+			},
+		)
+	}
+}
+
+func TestServer_authenticate(t *testing.T) {
+	path := writeFile(
+		t, `
+- name: viewer
+  token: view-token
+  roles: [read]
+- name: operator
+  token: sync-token
+  roles: [sync]
+`,
+	)
+
+	cfg := AuthConfig{
+		Enabled:     true,
+		TokensFile:  path,
+		ClientCerts: []ClientCertIdentity{{Subject: "ci.example.com", Roles: []string{RoleSync}}},
+	}
+	auth, err := MakeAuthenticators(cfg)
+	require.NoError(t, err)
+
+	srv := &Server{
+		boot: &ServerConfig{Auth: cfg},
+		auth: auth,
+		log:  logrus.New(),
+	}
+
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	api := g.Group("/api", srv.authenticate)
+	api.POST("/sync", srv.require(RoleSync), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		cert   string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "test no token", want: http.StatusUnauthorized},
+		{name: "test unknown token", header: "Authorization", value: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "test role required", header: "Authorization", value: "Bearer view-token", want: http.StatusForbidden},
+		{name: "test bearer token", header: "Authorization", value: "Bearer sync-token", want: http.StatusOK},
+		{name: "test api key", header: APIKeyHeader, value: "sync-token", want: http.StatusOK},
+		{name: "test client cert", cert: "ci.example.com", want: http.StatusOK},
+		{name: "test unmapped client cert", cert: "other.example.com", want: http.StatusUnauthorized},
+		{
+			name:   "test unmapped client cert with token",
+			cert:   "other.example.com",
+			header: "Authorization",
+			value:  "Bearer sync-token",
+			want:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/api/sync", nil)
+				if tt.header != "" {
+					r.Header.Set(tt.header, tt.value)
+				}
+
+				if tt.cert != "" {
+					cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.cert}}
+					r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+				}
+
+				w := httptest.NewRecorder()
+				g.ServeHTTP(w, r)
+				require.Equal(t, tt.want, w.Code)
+			},
+		)
+	}
+}
@@ -0,0 +1,262 @@
+// contains persistent metadata cache of directory listings
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultCacheFileExt extension of cache files in cache directory
+const DefaultCacheFileExt = ".cache"
+
+// cacheRacyInterval directories modified so recently can be
+// changed again within same mtime tick and are not cached
+const cacheRacyInterval = 2 * time.Second
+
+// cacheVersion have to be changed if cachedDir layout changed
+const cacheVersion = 1
+
+// DirLister return directory entries sorted by name
+type DirLister interface {
+	List(dir string) ([]ScanEntry, error)
+}
+
+// osLister read directory entries from fs
+type osLister struct{}
+
+func (osLister) List(dir string) ([]ScanEntry, error) {
+	return readEntries(dir)
+}
+
+// cachedDir contains directory listing with directory identity
+type cachedDir struct {
+	ModTime time.Time
+	Dev     uint64
+	Ino     uint64
+	Entries []ScanEntry
+}
+
+// cacheFile is a persisted cache layout
+type cacheFile struct {
+	Version int
+	Root    string
+	Dirs    map[string]cachedDir
+}
+
+// MetaCache persist directory listings of one root between syncs.
+// Directory with same mtime and inode is reused from cache without
+// reading entries, files of cached listing are checked by lstat.
+// With TrustDirs files are not checked and changes of files content
+// that do not touch directory mtime are not visible through cache
+type MetaCache struct {
+	// TrustDirs skip lstat of cached files
+	TrustDirs bool
+
+	lock *sync.Mutex
+	path string
+	root string
+
+	// loaded from disk
+	prev map[string]cachedDir
+
+	// listed while current scan
+	next map[string]cachedDir
+
+	// directories changed by sync, will not be saved
+	dirty map[string]struct{}
+
+	hits   int
+	misses int
+}
+
+// OpenMetaCache load cache of root from cache directory. Broken or
+// missing cache file is treated as empty cache
+func OpenMetaCache(cacheDir string, root string) (c *MetaCache, err error) {
+	var file *os.File
+	var data cacheFile
+
+	if root, err = filepath.Abs(root); err != nil {
+		return c, err
+	}
+
+	sum := sha256.Sum256([]byte(root))
+	c = &MetaCache{
+		lock:  new(sync.Mutex),
+		path:  filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+DefaultCacheFileExt),
+		root:  root,
+		prev:  make(map[string]cachedDir, DefaultDirAllocSize),
+		next:  make(map[string]cachedDir, DefaultDirAllocSize),
+		dirty: make(map[string]struct{}, DefaultDirAllocSize),
+	}
+
+	if file, err = os.Open(c.path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return c, nil
+		}
+		return c, err
+	}
+	defer file.Close()
+
+	// broken cache is not an error - will be rebuilt
+	if gob.NewDecoder(file).Decode(&data) != nil {
+		return c, nil
+	}
+
+	if data.Version == cacheVersion && data.Root == root && data.Dirs != nil {
+		c.prev = data.Dirs
+	}
+
+	return c, err
+}
+
+// List return entries from cache if directory not changed or
+// read them from fs
+func (c *MetaCache) List(dir string) (entries []ScanEntry, err error) {
+	var info os.FileInfo
+
+	if info, err = os.Stat(dir); err != nil {
+		return entries, err
+	}
+
+	dev, ino := fileID(info)
+	curr := cachedDir{ModTime: info.ModTime(), Dev: dev, Ino: ino}
+
+	c.lock.Lock()
+	cached, ok := c.prev[dir]
+	c.lock.Unlock()
+
+	if ok && cached.ModTime.Equal(curr.ModTime) &&
+		cached.Dev == curr.Dev && cached.Ino == curr.Ino {
+
+		if !c.TrustDirs {
+			cached.Entries, ok = c.verify(dir, cached.Entries)
+		}
+	} else {
+		ok = false
+	}
+
+	if ok {
+		c.lock.Lock()
+		c.hits++
+		c.next[dir] = cached
+		c.lock.Unlock()
+
+		return cached.Entries, err
+	}
+
+	if entries, err = readEntries(dir); err != nil {
+		return entries, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.misses++
+	if time.Since(curr.ModTime) > cacheRacyInterval {
+		curr.Entries = entries
+		c.next[dir] = curr
+	}
+
+	return entries, err
+}
+
+// verify lstat files of cached listing and return listing with
+// actual files meta. Return false if any file was replaced or removed
+func (c *MetaCache) verify(dir string, cached []ScanEntry) (
+	entries []ScanEntry,
+	ok bool,
+) {
+	var info os.FileInfo
+	var err error
+
+	entries = slices.Clone(cached)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.IsDir {
+			// directories are checked by own listing
+			continue
+		}
+
+		if info, err = os.Lstat(filepath.Join(dir, entry.Name)); err != nil || info.IsDir() {
+			return entries, false
+		}
+
+		entry.ModTime = info.ModTime()
+		entry.Size = info.Size()
+		entry.Perm = info.Mode().Perm()
+	}
+
+	return entries, true
+}
+
+// Invalidate mark directory as changed by sync. It will
+// be read from fs next time
+func (c *MetaCache) Invalidate(dir string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dirty[dir] = struct{}{}
+}
+
+// Stats return cache hits and misses count
+func (c *MetaCache) Stats() (hits int, misses int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hits, c.misses
+}
+
+// Save write cache to disk. If scan was not complete, previous
+// entries of not visited directories are kept
+func (c *MetaCache) Save(complete bool) (err error) {
+	var file *os.File
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dirs := c.next
+	if !complete {
+		dirs = make(map[string]cachedDir, len(c.prev)+len(c.next))
+		for k, v := range c.prev {
+			dirs[k] = v
+		}
+		for k, v := range c.next {
+			dirs[k] = v
+		}
+	}
+
+	for dir := range c.dirty {
+		delete(dirs, dir)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	// write into temp file and rename to avoid broken cache
+	tmp := fmt.Sprintf("%s.%d.tmp", c.path, os.Getpid())
+	if file, err = os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err != nil {
+		return err
+	}
+
+	data := cacheFile{Version: cacheVersion, Root: c.root, Dirs: dirs}
+	if err = gob.NewEncoder(file).Encode(&data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// fileID is not supported, only mtime is used for cache validation
+func fileID(info os.FileInfo) (dev uint64, ino uint64) {
+	return dev, ino
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetaCache_List(t *testing.T) {
+	root, cacheDir := t.TempDir(), t.TempDir()
+	makeTree(t, root, map[string]string{"a.txt": "a", "b/c.txt": "c"})
+
+	// make directories old enough to be cached
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "b"), old, old))
+	require.NoError(t, os.Chtimes(root, old, old))
+
+	cache, err := OpenMetaCache(cacheDir, root)
+	require.NoError(t, err)
+
+	entries, err := cache.List(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	_, err = cache.List(filepath.Join(root, "b"))
+	require.NoError(t, err)
+	cache.Invalidate(filepath.Join(root, "b"))
+	require.NoError(t, cache.Save(true))
+
+	// reopen - root is served from cache, invalidated dir is read again
+	cache, err = OpenMetaCache(cacheDir, root)
+	require.NoError(t, err)
+
+	cached, err := cache.List(root)
+	require.NoError(t, err)
+	require.Equal(t, entries, cached)
+
+	_, err = cache.List(filepath.Join(root, "b"))
+	require.NoError(t, err)
+
+	hits, misses := cache.Stats()
+	require.Equal(t, 1, hits)
+	require.Equal(t, 1, misses)
+
+	// changed directory mtime is a cache miss
+	require.NoError(t, os.WriteFile(filepath.Join(root, "d.txt"), nil, 0644))
+	entries, err = cache.List(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
+
+func TestMetaCache_ListModifiedInPlace(t *testing.T) {
+	tests := []struct {
+		name      string
+		trustDirs bool
+		wantSize  int64
+	}{
+		{name: "test files are checked", wantSize: 7},
+		{name: "test trusted dirs", trustDirs: true, wantSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				root, cacheDir := t.TempDir(), t.TempDir()
+				makeTree(t, root, map[string]string{"a.txt": "a"})
+
+				old := time.Now().Add(-time.Hour)
+				require.NoError(t, os.Chtimes(root, old, old))
+
+				cache, err := OpenMetaCache(cacheDir, root)
+				require.NoError(t, err)
+				_, err = cache.List(root)
+				require.NoError(t, err)
+				require.NoError(t, cache.Save(true))
+
+				// rewrite file content without touching directory mtime
+				path := filepath.Join(root, "a.txt")
+				require.NoError(t, os.WriteFile(path, []byte("changed"), 0644))
+				require.NoError(t, os.Chtimes(root, old, old))
+
+				cache, err = OpenMetaCache(cacheDir, root)
+				require.NoError(t, err)
+				cache.TrustDirs = tt.trustDirs
+
+				entries, err := cache.List(root)
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+				require.Equal(t, tt.wantSize, entries[0].Size)
+
+				hits, _ := cache.Stats()
+				require.Equal(t, 1, hits)
+			},
+		)
+	}
+}
+
+func TestSynchronizer_SyncStreamCached(t *testing.T) {
+	src, dst, cacheDir := t.TempDir(), t.TempDir(), t.TempDir()
+	makeTree(t, src, map[string]string{"a.txt": "a", "x/b.txt": "b"})
+
+	old := time.Now().Add(-time.Hour)
+	for _, dir := range []string{src, filepath.Join(src, "x")} {
+		require.NoError(t, os.Chtimes(dir, old, old))
+	}
+
+	sync := func() *SyncResult {
+		srcCache, err := OpenMetaCache(cacheDir, src)
+		require.NoError(t, err)
+		dstCache, err := OpenMetaCache(cacheDir, dst)
+		require.NoError(t, err)
+
+		s := Synchronizer{}
+		sc := &Scanner{
+			SrcRoot:   src,
+			DstRoot:   dst,
+			Workers:   2,
+			SrcLister: srcCache,
+			DstLister: dstCache,
+		}
+
+		res, err := s.SyncStream(context.Background(), sc, logrus.New())
+		require.NoError(t, err)
+		require.NoError(t, srcCache.Save(true))
+		require.NoError(t, dstCache.Save(true))
+		return res
+	}
+
+	require.Equal(t, 3, sync().Succeeded)
+	require.Equal(t, 0, sync().Succeeded)
+	require.FileExists(t, filepath.Join(dst, "x/b.txt"))
+}
@@ -0,0 +1,230 @@
+// contains HTTP client of daemon API used by CLI commands
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultClientAddr address of local daemon API
+const DefaultClientAddr = "http://127.0.0.1:6767"
+
+// environment of client commands
+const (
+	EnvClientAddr  = EnvPrefix + "ADDR"
+	EnvClientToken = EnvPrefix + "TOKEN"
+)
+
+// APIError is an error response of daemon API
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Client call daemon API with bearer token
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// MakeClient factory function return Client of API on addr, http,
+// https or unix:///path/of/socket. CA file is used to verify server
+// certificate of https addr
+func MakeClient(addr string, token string, caFile string, insecure bool) (
+	cl *Client,
+	err error,
+) {
+	var u *url.URL
+	var socket string
+
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		socket, addr = path, "http://localhost"
+	}
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	if u, err = url.Parse(addr); err != nil {
+		return cl, err
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		var pem []byte
+		if pem, err = os.ReadFile(caFile); err != nil {
+			return cl, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return cl, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	if socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	return &Client{
+		base:  strings.TrimSuffix(u.String(), "/") + apiPrefix,
+		token: token,
+		http:  &http.Client{Transport: transport},
+	}, err
+}
+
+// do send request with JSON body and decode JSON response into out.
+// Response body is decoded for error statuses too (partial results)
+func (cl *Client) do(
+	ctx context.Context,
+	method string,
+	path string,
+	header http.Header,
+	body any,
+	out any,
+) (err error) {
+	var reader io.Reader
+	var req *http.Request
+	var resp *http.Response
+	var buf []byte
+
+	if body != nil {
+		if buf, err = json.Marshal(body); err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, method, cl.base+path, reader); err != nil {
+		return err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cl.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
+
+	if resp, err = cl.http.Do(req); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if buf, err = io.ReadAll(resp.Body); err != nil {
+		return err
+	}
+
+	decoded := false
+	if out != nil && json.Valid(buf) {
+		decoded = json.Unmarshal(buf, out) == nil
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if !decoded {
+			apiErr.Message = strings.TrimSpace(string(buf))
+		}
+		return apiErr
+	}
+
+	return err
+}
+
+// Sync run sync of directories. Result is returned for failed sync
+// with partial result too
+func (cl *Client) Sync(ctx context.Context, req SyncDirectoriesRequest) (
+	res *SyncResult,
+	err error,
+) {
+	res = new(SyncResult)
+	err = cl.do(ctx, http.MethodPatch, "/sync/directories", nil, req, res)
+	return res, err
+}
+
+// Plan return operations of sync without execution
+func (cl *Client) Plan(ctx context.Context, req SyncDirectoriesRequest) (
+	plan SyncPlan,
+	err error,
+) {
+	err = cl.do(ctx, http.MethodPost, "/sync/plan", nil, req, &plan)
+	return plan, err
+}
+
+// Runs return running and last finished sync runs
+func (cl *Client) Runs(ctx context.Context) (runs []SyncRun, err error) {
+	err = cl.do(ctx, http.MethodGet, "/jobs", nil, nil, &runs)
+	return runs, err
+}
+
+// Run return sync run by id
+func (cl *Client) Run(ctx context.Context, id string) (run SyncRun, err error) {
+	err = cl.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, &run)
+	return run, err
+}
+
+// CancelRun stop running sync run
+func (cl *Client) CancelRun(ctx context.Context, id string) (run SyncRun, err error) {
+	err = cl.do(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/cancel", nil, nil, &run)
+	return run, err
+}
+
+// Config return effective config of daemon
+func (cl *Client) Config(ctx context.Context) (view ConfigResponse, err error) {
+	err = cl.do(ctx, http.MethodGet, "/server/config", nil, nil, &view)
+	return view, err
+}
+
+// UpdateConfig apply partial config. Version is checked if not empty
+func (cl *Client) UpdateConfig(
+	ctx context.Context,
+	patch map[string]any,
+	version string,
+	persist bool,
+) (res ConfigUpdateResponse, err error) {
+	header := make(http.Header)
+	if version != "" {
+		header.Set("If-Match", strconv.Quote(version))
+	}
+
+	path := "/server/config/update"
+	if persist {
+		path += "?persist=true"
+	}
+
+	err = cl.do(ctx, http.MethodPatch, path, header, patch, &res)
+	return res, err
+}
+
+// Status return state of daemon
+func (cl *Client) Status(ctx context.Context) (status StatusResponse, err error) {
+	err = cl.do(ctx, http.MethodGet, "/status", nil, nil, &status)
+	return status, err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDaemon return address of test server with admin token
+func testDaemon(t *testing.T) (string, *Server) {
+	t.Helper()
+
+	cfg := loadConfig(t, strings.Replace(testConfig, "roles: [sync]", "roles: [admin]", 1))
+	srv, err := MakeServer(cfg, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, srv.setup())
+
+	ts := httptest.NewServer(srv.g)
+	t.Cleanup(ts.Close)
+	return ts.URL, srv
+}
+
+// writeSyncFiles write a.txt into src and b.txt into both directories
+func writeSyncFiles(t *testing.T, src string, dst string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("b"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "b.txt"), []byte("b"), 0600))
+}
+
+func TestClient(t *testing.T) {
+	addr, _ := testDaemon(t)
+	ctx := context.Background()
+
+	cl, err := MakeClient(addr, "secret-token", "", false)
+	require.NoError(t, err)
+
+	status, err := cl.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status.ConfigVersion, 16)
+
+	view, err := cl.Config(ctx)
+	require.NoError(t, err)
+	require.Equal(t, status.ConfigVersion, view.Version)
+
+	res, err := cl.UpdateConfig(ctx, map[string]any{"max_diff_percent": 20}, view.Version, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"max_diff_percent"}, res.Changed)
+
+	var apiErr *APIError
+	_, err = cl.UpdateConfig(ctx, map[string]any{"max_diff_percent": 30}, view.Version, false)
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusPreconditionFailed, apiErr.Status)
+
+	_, err = cl.Run(ctx, "42")
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	anonymous, err := MakeClient(addr, "", "", false)
+	require.NoError(t, err)
+	_, err = anonymous.Status(ctx)
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusUnauthorized, apiErr.Status)
+}
+
+func TestRunClientCommand(t *testing.T) {
+	addr, srv := testDaemon(t)
+	src, dst := srv.boot.SrcPath, srv.boot.DstPath
+	writeSyncFiles(t, src, dst)
+
+	common := []string{"--addr", addr, "--token", "secret-token"}
+	tests := []struct {
+		name     string
+		command  string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:    "test status",
+			command: CommandStatus,
+			want:    []string{"config version", "scheduled jobs"},
+		},
+		{
+			name:    "test config get",
+			command: CommandConfig,
+			args:    []string{"get", "auth.tokens"},
+			want:    []string{"KEY", "auth.tokens", redactedValue, SourceFile},
+		},
+		{
+			name:    "test config set",
+			command: CommandConfig,
+			args:    []string{"set", "max_diff_percent=30", "auth.enabled=true"},
+			want:    []string{"changed", "max_diff_percent"},
+		},
+		{
+			name:     "test config set bad value",
+			command:  CommandConfig,
+			args:     []string{"set", "max_diff_percent=300"},
+			wantCode: 1,
+		},
+		{
+			name:    "test sync dry run",
+			command: CommandSync,
+			args:    []string{"--src", src, "--dst", dst, "--max-diff", "100", "--dry-run"},
+			want:    []string{"sync_file", "a.txt"},
+		},
+		{
+			name:     "test sync without dst",
+			command:  CommandSync,
+			args:     []string{"--src", src},
+			wantCode: 2,
+		},
+		{
+			name:    "test jobs list",
+			command: CommandJobs,
+			args:    []string{"list", "-o", "json"},
+			want:    []string{"[]"},
+		},
+		{
+			name:     "test jobs unknown action",
+			command:  CommandJobs,
+			args:     []string{"restart", "1"},
+			wantCode: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var out, errOut bytes.Buffer
+
+				args := append(append([]string{}, tt.args...), common...)
+				code := RunClientCommand(context.Background(), &out, &errOut, tt.command, args)
+				require.Equal(t, tt.wantCode, code, errOut.String())
+				for _, want := range tt.want {
+					require.Contains(t, out.String(), want)
+				}
+			},
+		)
+	}
+}
+
+func TestRunClientCommand_sync(t *testing.T) {
+	var out, errOut bytes.Buffer
+	var res SyncResult
+
+	addr, srv := testDaemon(t)
+	src, dst := srv.boot.SrcPath, srv.boot.DstPath
+	writeSyncFiles(t, src, dst)
+
+	code := RunClientCommand(
+		context.Background(),
+		&out,
+		&errOut,
+		CommandSync,
+		[]string{"--src", src, "--dst", dst, "--max-diff", "100", "-o", "json", "--addr", addr, "--token", "secret-token"},
+	)
+	require.Equal(t, 0, code, errOut.String())
+	require.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	require.Zero(t, res.Failed)
+	require.FileExists(t, filepath.Join(dst, "a.txt"))
+
+	runs := srv.runs.List()
+	require.Len(t, runs, 1)
+	require.Equal(t, RunKindSync, runs[0].Kind)
+	require.Equal(t, JobStatusOk, runs[0].State)
+}
+
+func TestConfigPatch(t *testing.T) {
+	patch, err := configPatch([]string{"auth.enabled=false", "allowed_methods=[GET, POST]", "cache_dir=null"})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[string]any{
+			"auth":            map[string]any{"enabled": false},
+			"allowed_methods": []any{"GET", "POST"},
+			"cache_dir":       nil,
+		},
+		patch,
+	)
+
+	_, err = configPatch([]string{"auth.enabled"})
+	require.Error(t, err)
+}
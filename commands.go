@@ -0,0 +1,505 @@
+// contains command-line subcommands of fsyncd
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// subcommands, fsyncd without command run the server
+const (
+	CommandValidateConfig = "validate-config"
+	CommandSync           = "sync"
+	CommandJobs           = "jobs"
+	CommandConfig         = "config"
+	CommandStatus         = "status"
+)
+
+// ClientCommands talk to running daemon
+var ClientCommands = []string{CommandSync, CommandJobs, CommandConfig, CommandStatus}
+
+// output formats of client commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+var UnknownCommand = fmt.Errorf("unknown command")
+var MissingArgument = fmt.Errorf("missing argument")
+
+// usage print commands and flags
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(
+		out,
+		"Usage: %s [flags] [command]\n\n"+
+			"Commands:\n"+
+			"  %-16s check config file and print all problems\n"+
+			"  %-16s sync directories by daemon (--src, --dst, --dry-run)\n"+
+			"  %-16s list, show or cancel sync runs (list|show <id>|cancel <id>)\n"+
+			"  %-16s get or set daemon config (get [key]|set key=value...)\n"+
+			"  %-16s print daemon state\n"+
+			"  %-16s sync directories once without server, see %s %s -h\n\n"+
+			"Client commands accept --addr, --token, --ca-file, --insecure and\n"+
+			"--output (table or json), see %s <command> -h\n\n"+
+			"Flags:\n",
+		os.Args[0],
+		CommandValidateConfig,
+		CommandSync,
+		CommandJobs,
+		CommandConfig,
+		CommandStatus,
+		CommandRun,
+		os.Args[0],
+		CommandRun,
+		os.Args[0],
+	)
+	flag.PrintDefaults()
+}
+
+// ValidateConfig load config and print all problems. Return exit
+// code: 0 if config is valid, 1 otherwise
+func ValidateConfig(w io.Writer, path string, overrides []ConfigOverride) int {
+	var problems ConfigErrors
+
+	cfg := new(ServerConfig)
+	err := cfg.LoadFile(path, overrides...)
+	if err == nil {
+		_, _ = fmt.Fprintf(w, "%s: ok\n", path)
+		return 0
+	}
+
+	if !errors.As(err, &problems) {
+		_, _ = fmt.Fprintf(w, "%s: %s\n", path, err)
+		return 1
+	}
+
+	_, _ = fmt.Fprintf(w, "%s: %d problem(s)\n", path, len(problems))
+	for _, p := range problems {
+		_, _ = fmt.Fprintf(w, "  %s\n", p.Error())
+	}
+	return 1
+}
+
+// clientFlags are common flags of client commands
+type clientFlags struct {
+	addr     string
+	token    string
+	caFile   string
+	insecure bool
+	output   string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(
+		&f.addr,
+		"addr",
+		cmp.Or(os.Getenv(EnvClientAddr), DefaultClientAddr),
+		"daemon API address, http(s)://host:port or unix:///path (default $"+EnvClientAddr+")",
+	)
+	fs.StringVar(&f.token, "token", os.Getenv(EnvClientToken), "bearer token (default $"+EnvClientToken+")")
+	fs.StringVar(&f.caFile, "ca-file", "", "CA certificate to verify https daemon")
+	fs.BoolVar(&f.insecure, "insecure", false, "skip verification of daemon certificate")
+	fs.StringVar(&f.output, "output", OutputTable, "output format: table or json")
+	fs.StringVar(&f.output, "o", OutputTable, "shorthand for --output")
+}
+
+// parseArgs parse flags placed before and after positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) (positional []string, err error) {
+	for {
+		if err = fs.Parse(args); err != nil {
+			return positional, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, err
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+}
+
+// RunClientCommand run client command with args against daemon, write
+// output to w and errors to errW. Return exit code: 0 on success, 1 on
+// failure, 2 on bad usage
+func RunClientCommand(
+	ctx context.Context,
+	w io.Writer,
+	errW io.Writer,
+	command string,
+	args []string,
+) int {
+	var flags clientFlags
+	var req SyncDirectoriesRequest
+	var dryRun, persist bool
+	var version string
+	var cl *Client
+	var err error
+
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(errW)
+	flags.register(fs)
+
+	switch command {
+	case CommandSync:
+		fs.StringVar(&req.SrcPath, "src", "", "source directory")
+		fs.StringVar(&req.DstPath, "dst", "", "destination directory")
+		fs.IntVar(&req.MaxDiffPercent, "max-diff", 0, "max difference of directories, percent (1-100)")
+		fs.StringVar(&req.Mode, "mode", "", "sync mode: full or stream (default configured)")
+		fs.BoolVar(&dryRun, "dry-run", false, "print planned operations without sync")
+	case CommandConfig:
+		fs.BoolVar(&persist, "persist", false, "write updated values to config file (set)")
+		fs.StringVar(&version, "if-version", "", "apply update only if config version matches (set)")
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+
+	if flags.output != OutputTable && flags.output != OutputJSON {
+		_, _ = fmt.Fprintf(errW, "unknown output format %q\n", flags.output)
+		return 2
+	}
+
+	if cl, err = MakeClient(flags.addr, flags.token, flags.caFile, flags.insecure); err != nil {
+		_, _ = fmt.Fprintln(errW, err)
+		return 1
+	}
+
+	out := output{w: w, json: flags.output == OutputJSON}
+	switch command {
+	case CommandSync:
+		err = runSync(ctx, cl, out, req, dryRun)
+	case CommandJobs:
+		err = runJobs(ctx, cl, out, positional)
+	case CommandConfig:
+		err = runConfig(ctx, cl, out, positional, version, persist)
+	case CommandStatus:
+		err = runStatus(ctx, cl, out)
+	default:
+		err = fmt.Errorf("%w: %s", UnknownCommand, command)
+	}
+
+	if errors.Is(err, MissingArgument) || errors.Is(err, UnknownCommand) {
+		_, _ = fmt.Fprintln(errW, err)
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintln(errW, err)
+		return 1
+	}
+	return 0
+}
+
+// output write results as tables or JSON
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// print write v as JSON or call table to write it as table
+func (o output) print(v any, table func(tw *tabwriter.Writer)) (err error) {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func runSync(
+	ctx context.Context,
+	cl *Client,
+	out output,
+	req SyncDirectoriesRequest,
+	dryRun bool,
+) (err error) {
+	if req.SrcPath == "" || req.DstPath == "" || req.MaxDiffPercent == 0 {
+		return fmt.Errorf("%w: --src, --dst and --max-diff are required", MissingArgument)
+	}
+
+	if dryRun {
+		var plan SyncPlan
+		if plan, err = cl.Plan(ctx, req); err != nil {
+			return err
+		}
+
+		return out.print(
+			plan, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "OP\tPATH")
+				for _, row := range []struct {
+					op    string
+					paths []string
+				}{
+					{op: "create_dir", paths: plan.CreateDirs},
+					{op: "delete_dir", paths: plan.DeleteDirs},
+					{op: "delete_file", paths: plan.DeleteFiles},
+					{op: "sync_file", paths: plan.SyncFiles},
+				} {
+					for _, path := range row.paths {
+						_, _ = fmt.Fprintf(tw, "%s\t%s\n", row.op, path)
+					}
+				}
+			},
+		)
+	}
+
+	res, err := cl.Sync(ctx, req)
+	if printErr := out.print(
+		res, func(tw *tabwriter.Writer) {
+			_, _ = fmt.Fprintf(tw, "succeeded\t%d\nfailed\t%d\nretried\t%d\n", res.Succeeded, res.Failed, res.Retried)
+			if len(res.Items) == 0 {
+				return
+			}
+
+			_, _ = fmt.Fprintln(tw, "\nOP\tPATH\tATTEMPTS\tERROR")
+			for _, item := range res.Items {
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", item.Op, item.Path, item.Attempts, item.Error)
+			}
+		},
+	); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func runJobs(ctx context.Context, cl *Client, out output, args []string) (err error) {
+	var runs []SyncRun
+	var run SyncRun
+
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch {
+	case action == "list" && len(args) <= 1:
+		if runs, err = cl.Runs(ctx); err != nil {
+			return err
+		}
+
+		return out.print(
+			runs, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintln(tw, "ID\tKIND\tNAME\tSTATE\tSTARTED\tDURATION\tOK\tFAILED\tSRC\tDST")
+				for _, run = range runs {
+					_, _ = fmt.Fprintf(
+						tw,
+						"%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+						run.ID,
+						run.Kind,
+						cmp.Or(run.Name, "-"),
+						run.State,
+						run.StartedAt.Local().Format(time.DateTime),
+						runDuration(run),
+						run.Succeeded,
+						run.Failed,
+						run.SrcPath,
+						strings.Join(run.DstPaths, ","),
+					)
+				}
+			},
+		)
+	case action == "show" && len(args) == 2:
+		run, err = cl.Run(ctx, args[1])
+	case action == "cancel" && len(args) == 2:
+		run, err = cl.CancelRun(ctx, args[1])
+	default:
+		return fmt.Errorf("%w: %s %s", UnknownCommand, CommandJobs, strings.Join(args, " "))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return out.print(
+		run, func(tw *tabwriter.Writer) {
+			_, _ = fmt.Fprintf(tw, "id\t%s\nkind\t%s\n", run.ID, run.Kind)
+			if run.Name != "" {
+				_, _ = fmt.Fprintf(tw, "name\t%s\n", run.Name)
+			}
+			_, _ = fmt.Fprintf(tw, "src\t%s\n", run.SrcPath)
+			_, _ = fmt.Fprintf(tw, "dst\t%s\n", strings.Join(run.DstPaths, ", "))
+			_, _ = fmt.Fprintf(tw, "state\t%s\n", run.State)
+			_, _ = fmt.Fprintf(tw, "started\t%s\n", run.StartedAt.Local().Format(time.DateTime))
+			_, _ = fmt.Fprintf(tw, "duration\t%s\n", runDuration(run))
+			_, _ = fmt.Fprintf(tw, "succeeded\t%d\nfailed\t%d\n", run.Succeeded, run.Failed)
+			if run.Error != "" {
+				_, _ = fmt.Fprintf(tw, "error\t%s\n", run.Error)
+			}
+		},
+	)
+}
+
+// runDuration return duration of finished run or time since start
+func runDuration(run SyncRun) string {
+	end := time.Now()
+	if run.FinishedAt != nil {
+		end = *run.FinishedAt
+	}
+	return end.Sub(run.StartedAt).Round(time.Millisecond).String()
+}
+
+func runConfig(
+	ctx context.Context,
+	cl *Client,
+	out output,
+	args []string,
+	version string,
+	persist bool,
+) (err error) {
+	var view ConfigResponse
+	var res ConfigUpdateResponse
+	var patch map[string]any
+
+	switch {
+	case len(args) >= 1 && args[0] == "get" && len(args) <= 2:
+		if view, err = cl.Config(ctx); err != nil {
+			return err
+		}
+
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		return printConfigView(out, view, prefix)
+	case len(args) >= 2 && args[0] == "set":
+		if patch, err = configPatch(args[1:]); err != nil {
+			return err
+		}
+
+		if res, err = cl.UpdateConfig(ctx, patch, version, persist); err != nil {
+			return err
+		}
+
+		return out.print(
+			res, func(tw *tabwriter.Writer) {
+				_, _ = fmt.Fprintf(tw, "version\t%s\n", res.Version)
+				_, _ = fmt.Fprintf(tw, "changed\t%s\n", cmp.Or(strings.Join(res.Changed, ", "), "-"))
+				_, _ = fmt.Fprintf(tw, "restart required\t%s\n", cmp.Or(strings.Join(res.RestartRequired, ", "), "-"))
+			},
+		)
+	default:
+		return fmt.Errorf("%w: %s %s", UnknownCommand, CommandConfig, strings.Join(args, " "))
+	}
+}
+
+// printConfigView write config values with sources, only keys equal
+// to prefix or nested in it if prefix is not empty
+func printConfigView(out output, view ConfigResponse, prefix string) error {
+	values := make(map[string]any)
+	walkValues(view.Config, "", values)
+
+	keys := slices.Sorted(maps.Keys(values))
+	if prefix != "" {
+		keys = slices.DeleteFunc(
+			keys, func(key string) bool {
+				return key != prefix && !strings.HasPrefix(key, prefix+".")
+			},
+		)
+		if len(keys) == 0 {
+			return fmt.Errorf("%w: %s", UnknownConfigKey, prefix)
+		}
+
+		view.Config = make(map[string]any, len(keys))
+		for _, key := range keys {
+			view.Config[key] = values[key]
+		}
+	}
+
+	return out.print(
+		view, func(tw *tabwriter.Writer) {
+			_, _ = fmt.Fprintf(tw, "# version: %s\n", view.Version)
+			_, _ = fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+			for _, key := range keys {
+				value, _ := json.Marshal(values[key])
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", key, value, cmp.Or(view.Sources[key], "-"))
+			}
+		},
+	)
+}
+
+// walkValues collect values of config view by dotted keys, lists are
+// kept as single values
+func walkValues(node map[string]any, prefix string, values map[string]any) {
+	for key, value := range node {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+			walkValues(nested, key, values)
+			continue
+		}
+		values[key] = value
+	}
+}
+
+// configPatch build merge patch from key=value pairs with dotted yaml
+// keys, values are parsed as yaml
+func configPatch(pairs []string) (patch map[string]any, err error) {
+	patch = make(map[string]any)
+
+	for _, pair := range pairs {
+		key, text, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		var value any
+		if err = yaml.Unmarshal([]byte(text), &value); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		node := patch
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			nested, isMap := node[part].(map[string]any)
+			if !isMap {
+				nested = make(map[string]any)
+				node[part] = nested
+			}
+			node = nested
+		}
+		node[parts[len(parts)-1]] = value
+	}
+	return patch, err
+}
+
+func runStatus(ctx context.Context, cl *Client, out output) (err error) {
+	var status StatusResponse
+	if status, err = cl.Status(ctx); err != nil {
+		return err
+	}
+
+	return out.print(
+		status, func(tw *tabwriter.Writer) {
+			_, _ = fmt.Fprintf(tw, "version\t%s\n", status.Version)
+			_, _ = fmt.Fprintf(tw, "started\t%s\n", status.StartedAt.Local().Format(time.DateTime))
+			_, _ = fmt.Fprintf(tw, "uptime\t%s\n", status.Uptime)
+			_, _ = fmt.Fprintf(tw, "busy\t%t\n", status.Busy)
+			_, _ = fmt.Fprintf(tw, "running\t%d\n", len(status.Running))
+			_, _ = fmt.Fprintf(tw, "config version\t%s\n", status.ConfigVersion)
+			_, _ = fmt.Fprintf(tw, "scheduled jobs\t%d\n", status.ScheduledJobs)
+			_, _ = fmt.Fprintf(tw, "watch pairs\t%d\n", status.WatchPairs)
+		},
+	)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var out bytes.Buffer
+
+	path := writeFile(t, testConfig)
+	require.Equal(t, 0, ValidateConfig(&out, path, testPaths(t)))
+	require.Equal(t, path+": ok\n", out.String())
+
+	out.Reset()
+	bad := strings.NewReplacer("port: 6767", "port: http", "log_level: info", "log_level: loud").
+		Replace(testConfig)
+	path = writeFile(t, bad)
+	require.Equal(t, 1, ValidateConfig(&out, path, testPaths(t)))
+	require.Contains(t, out.String(), "2 problem(s)")
+	require.Contains(t, out.String(), "  port: must be numeric")
+	require.Contains(t, out.String(), "  log_level: unexpected level")
+}
@@ -2,10 +2,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
-	"github.com/stretchr/testify/assert/yaml"
+	"gopkg.in/yaml.v3"
 	"io"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,72 +22,979 @@ import (
 // DefaultConfigName for detect config file
 const DefaultConfigName = "fsync.yml"
 
+// sources of config values
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceAPI     = "api"
+)
+
+// redactedValue replace sensitive values (tagged with redact:"true")
+const redactedValue = "******"
+
+var InvalidConfig = fmt.Errorf("invalid config")
+var ConfigVersionMismatch = fmt.Errorf("config version mismatch")
+var BadConfigUpdate = fmt.Errorf("bad config update")
+
+// ConfigProblem is an invalid config value with yaml key path
+type ConfigProblem struct {
+	Key string
+	Err error
+}
+
+func (p ConfigProblem) Error() string {
+	if p.Key == "" {
+		return p.Err.Error()
+	}
+	return p.Key + ": " + p.Err.Error()
+}
+
+func (p ConfigProblem) Unwrap() error {
+	return p.Err
+}
+
+// ConfigErrors contains all problems found by validation
+type ConfigErrors []ConfigProblem
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, p := range e {
+		lines = append(lines, p.Error())
+	}
+	return fmt.Sprintf("%s: %s", InvalidConfig, strings.Join(lines, "; "))
+}
+
+func (e ConfigErrors) Unwrap() []error {
+	errs := []error{InvalidConfig}
+	for _, p := range e {
+		errs = append(errs, p)
+	}
+	return errs
+}
+
+// liveConfigKeys are applied without restart (on update or reload),
+// other keys are applied to listeners, auth and watchers on start
+var liveConfigKeys = []string{
+	"log_level",
+	"time_format",
+	"bytes_per_sec",
+	"files_per_sec",
+	"worker_bytes_per_sec",
+	"throttle_schedule",
+	"jobs",
+	"allowed_hosts",
+	"allowed_methods",
+	"allowed_headers",
+	"allow_credentials",
+	"cors_max_age",
+	"max_diff_percent",
+	"sync_mode",
+	"scan_workers",
+	"cache_dir",
+	"cache_trust_dirs",
+	"profiles",
+	"retry_max_attempts",
+	"retry_base_delay",
+	"retry_jitter",
+	"retry_errors",
+	"concurrency",
+}
+
 // ServerConfig contains all required server parameters
 type ServerConfig struct {
 	// server section
-	Host string `yaml:"host" Validate:"required,ipv4"`
-	Port string `yaml:"port" Validate:"required,numeric"`
+	Host string `yaml:"host" validate:"required,ipv4"`
+	Port string `yaml:"port" validate:"required,numeric"`
 
 	// swagger section
-	SwaggerEnabled bool   `yaml:"swagger_enabled" Validate:"required"`
-	SwaggerPort    string `yaml:"swagger_port" Validate:"numeric"`
+	SwaggerEnabled bool   `yaml:"swagger_enabled"`
+	SwaggerPort    string `yaml:"swagger_port" validate:"required_if=SwaggerEnabled true,omitempty,numeric"`
+
+	// metrics section, /metrics require read role if not public
+	MetricsEnabled bool `yaml:"metrics_enabled"`
+	MetricsPublic  bool `yaml:"metrics_public"`
 
 	// sync section
-	// 'dirpath' will fail if directory not exists
-	SrcPath        string `yaml:"src_path" Validate:"required,dirpath"`
-	DstPath        string `yaml:"dst_path" Validate:"required,dirpath"`
-	MaxDiffPercent int    `yaml:"max_diff_percent" Validate:"required,gt=0,lte=100"`
+	// 'dirpath' accept existing directory or not existing path
+	// ending with separator, roots existence is checked by sync
+	SrcPath        string `yaml:"src_path" validate:"required,dirpath"`
+	DstPath        string `yaml:"dst_path" validate:"required,dirpath"`
+	MaxDiffPercent int    `yaml:"max_diff_percent" validate:"required,gt=0,lte=100"`
+
+	// paths requested with API must be inside of allowed roots
+	// (src_path and dst_path if empty)
+	AllowedRoots []string `yaml:"allowed_roots"`
+
+	// sync mode: full or stream, scan_workers used by stream mode
+	SyncMode    string `yaml:"sync_mode" validate:"omitempty,oneof=full stream"`
+	ScanWorkers int    `yaml:"scan_workers" validate:"gte=0"`
+
+	// directory for persistent metadata cache (stream mode), files
+	// of cached directories are not checked if cache_trust_dirs
+	CacheDir       string `yaml:"cache_dir"`
+	CacheTrustDirs bool   `yaml:"cache_trust_dirs"`
+
+	// watch section
+	Watch         []WatchPair   `yaml:"watch"`
+	WatchDebounce time.Duration `yaml:"watch_debounce" validate:"gte=0"`
+
+	// scheduled sync jobs
+	Jobs []SyncJob `yaml:"jobs"`
+
+	// named sync profiles
+	Profiles map[string]SyncProfile `yaml:"profiles"`
+
+	// retry section
+	// allowed error classes: EIO, ESTALE, EAGAIN, EBUSY, EINTR, ETIMEDOUT
+	RetryMaxAttempts int           `yaml:"retry_max_attempts" validate:"gte=0"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay" validate:"gte=0"`
+	RetryJitter      float64       `yaml:"retry_jitter" validate:"gte=0,lte=1"`
+	RetryErrors      []string      `yaml:"retry_errors"`
+
+	// throttling section (0 - unlimited)
+	BytesPerSec       int64            `yaml:"bytes_per_sec" validate:"gte=0"`
+	FilesPerSec       int64            `yaml:"files_per_sec" validate:"gte=0"`
+	WorkerBytesPerSec int64            `yaml:"worker_bytes_per_sec" validate:"gte=0"`
+	ThrottleSchedule  []ThrottleWindow `yaml:"throttle_schedule"`
+
+	// workers count for each sync phase
+	Concurrency Concurrency `yaml:"concurrency"`
+
+	// authentication of API clients
+	Auth AuthConfig `yaml:"auth"`
+
+	// TLS of API listener
+	TLS TLSConfig `yaml:"tls"`
+
+	// Unix socket listener of API, alongside or instead of TCP
+	UnixSocket UnixSocketConfig `yaml:"unix_socket"`
 
 	// external data source
 	// ...
 
 	// connection settings
-	ConnReadTimeout         time.Duration `yaml:"conn_read_timeout" Validate:"required"`
-	ConnWriteTimeout        time.Duration `yaml:"conn_write_timeout" Validate:"required"`
-	GracefulShutdownTimeout time.Duration `yaml:"graceful_shutdown_timeout" Validate:"required"`
+	ConnReadTimeout         time.Duration `yaml:"conn_read_timeout" validate:"required,gt=0"`
+	ConnWriteTimeout        time.Duration `yaml:"conn_write_timeout" validate:"required,gt=0"`
+	GracefulShutdownTimeout time.Duration `yaml:"graceful_shutdown_timeout" validate:"required,gt=0"`
 
 	// CORS
-	AllowedHosts   []string `yaml:"allowed_hosts" Validate:"required"`
-	AllowedMethods []string `yaml:"allowed_methods" Validate:"required"`
-	AllowedHeaders []string `yaml:"allowed_headers" Validate:"required"`
+	AllowedHosts   []string `yaml:"allowed_hosts" validate:"required"`
+	AllowedMethods []string `yaml:"allowed_methods" validate:"required"`
+	AllowedHeaders []string `yaml:"allowed_headers" validate:"required"`
+
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	CORSMaxAge       time.Duration `yaml:"cors_max_age" validate:"gte=0"`
 
 	// logger section
-	TimeFormat string `yaml:"time_format" Validate:"required"`
-	LogLevel   string `yaml:"log_level" Validate:"required"`
+	TimeFormat string `yaml:"time_format" validate:"required"`
+	LogLevel   string `yaml:"log_level" validate:"required"`
+
+	// how often config file is checked for changes (0 - reload
+	// on SIGHUP only)
+	ConfigReloadInterval time.Duration `yaml:"config_reload_interval" validate:"gte=0"`
 
 	lock *sync.RWMutex
+
+	// source of values by dotted yaml key (auth.tokens_file)
+	sources map[string]string
+
+	// path of loaded config file, updates are persisted here
+	path string
+
+	// env and flag overrides, applied again on reload
+	overrides []ConfigOverride
 }
 
 // Load parameters from config file and setup config
 func (sc *ServerConfig) Load() (err error) {
+	return sc.LoadFile(DefaultConfigName)
+}
+
+// LoadFile load parameters from config file by path
+func (sc *ServerConfig) LoadFile(path string, overrides ...ConfigOverride) (err error) {
 	var file *os.File
 	var buf []byte
+	var raw map[string]any
 
-	if file, err = os.Open(DefaultConfigName); err != nil {
+	if file, err = os.Open(path); err != nil {
 		return err
 	}
+	defer file.Close()
 
 	if buf, err = io.ReadAll(file); err != nil {
 		return err
 	}
 
+	if err = yaml.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+
+	if raw == nil {
+		raw = make(map[string]any)
+	}
+
+	// remember which values are set in file
+	sources := make(map[string]string, len(raw))
+	walkLeaves(raw, "", func(key string) { sources[key] = SourceFile })
+
+	// precedence: flag > env > file > default
+	for _, source := range []string{SourceEnv, SourceFlag} {
+		for _, o := range overrides {
+			if o.Source != source {
+				continue
+			}
+
+			if err = o.apply(raw); err != nil {
+				return err
+			}
+
+			// override of nested struct replace its values
+			maps.DeleteFunc(
+				sources, func(key string, _ string) bool {
+					return strings.HasPrefix(key, o.Key+".")
+				},
+			)
+			sources[o.Key] = o.Source
+		}
+	}
+
+	if buf, err = yaml.Marshal(raw); err != nil {
+		return err
+	}
+
 	if err = yaml.Unmarshal(buf, sc); err != nil {
 		return err
 	}
 
-	if _, err = sc.Validate(); err != nil {
+	sc.lock = new(sync.RWMutex)
+	sc.sources = sources
+	sc.overrides = overrides
+	sc.applyDefaults()
+	sc.path = path
+
+	return sc.check()
+}
+
+// check validate config values, used on load and update
+func (sc *ServerConfig) check() (err error) {
+	_, err = sc.Validate()
+	return err
+}
+
+// applyDefaults set default values of empty fields
+func (sc *ServerConfig) applyDefaults() {
+	setDefault(sc.sources, "log_level", &sc.LogLevel, InfoLevel)
+	setDefault(sc.sources, "sync_mode", &sc.SyncMode, ModeFull)
+	setDefault(sc.sources, "watch_debounce", &sc.WatchDebounce, DefaultWatchDebounce)
+	setDefault(sc.sources, "retry_max_attempts", &sc.RetryMaxAttempts, DefaultRetryAttempts)
+	setDefault(sc.sources, "retry_base_delay", &sc.RetryBaseDelay, DefaultRetryBaseDelay)
+	setDefault(sc.sources, "auth.hmac_max_skew", &sc.Auth.HMACMaxSkew, DefaultHMACMaxSkew)
+	setDefault(sc.sources, "tls.min_version", &sc.TLS.MinVersion, "1.2")
+	setDefault(sc.sources, "tls.reload_interval", &sc.TLS.ReloadInterval, DefaultTLSReloadInterval)
+	setDefault(sc.sources, "unix_socket.mode", &sc.UnixSocket.Mode, DefaultUnixSocketMode)
+
+	if len(sc.RetryErrors) == 0 {
+		sc.RetryErrors = slices.Clone(DefaultRetryableErrors)
+		sc.sources["retry_errors"] = SourceDefault
+	}
+}
+
+// setDefault set value of empty field and mark its source
+func setDefault[T comparable](
+	sources map[string]string,
+	key string,
+	field *T,
+	value T,
+) {
+	var zero T
+	if *field == zero {
+		*field = value
+		sources[key] = SourceDefault
+	}
+}
+
+// Snapshot return copy of config. Snapshot is read only, running
+// jobs keep it and are not affected by updates
+func (sc *ServerConfig) Snapshot() *ServerConfig {
+	sc.rlock()
+	defer sc.runlock()
+
+	snapshot := *sc
+	snapshot.lock = nil
+	return &snapshot
+}
+
+// Update apply partial config (JSON merge patch by yaml keys) if
+// version is empty or equal to current one. Config is replaced
+// only if new one is valid (and saved to file if persist is true).
+// Return changed top level keys
+func (sc *ServerConfig) Update(
+	patch map[string]any,
+	version string,
+	persist bool,
+) (changed []string, err error) {
+	var current string
+	var prev, next map[string]any
+	var buf []byte
+
+	sc.wlock()
+	defer sc.wunlock()
+
+	if current, err = sc.version(); err != nil {
+		return changed, err
+	}
+
+	if version != "" && version != current {
+		return changed, fmt.Errorf(
+			"%w: expected %s, actual %s",
+			ConfigVersionMismatch,
+			version,
+			current,
+		)
+	}
+
+	if err = checkRedacted(patch, ""); err != nil {
+		return changed, err
+	}
+
+	if prev, err = sc.effective(false); err != nil {
+		return changed, err
+	}
+
+	if next, err = sc.effective(false); err != nil {
+		return changed, err
+	}
+	mergePatch(next, patch)
+
+	if buf, err = yaml.Marshal(next); err != nil {
+		return changed, fmt.Errorf("%w: %w", BadConfigUpdate, err)
+	}
+
+	updated := &ServerConfig{sources: maps.Clone(sc.sources)}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err = dec.Decode(updated); err != nil {
+		return changed, fmt.Errorf("%w: %w", BadConfigUpdate, err)
+	}
+
+	source := SourceAPI
+	if persist {
+		source = SourceFile
+	}
+	walkLeaves(patch, "", func(key string) { updated.sources[key] = source })
+	updated.applyDefaults()
+
+	if err = updated.check(); err != nil {
+		return changed, fmt.Errorf("%w: %w", BadConfigUpdate, err)
+	}
+
+	if next, err = updated.effective(false); err != nil {
+		return changed, err
+	}
+	changed = changedKeys(prev, next)
+
+	// running config stay untouched if file is not saved
+	if persist && len(changed) > 0 {
+		if err = persistPatch(sc.path, patch, next, sc.overridden); err != nil {
+			return nil, err
+		}
+	}
+
+	sc.replace(updated)
+	return changed, err
+}
+
+// Reload load config file again. Config is replaced only if file
+// is valid. Return changed top level keys
+func (sc *ServerConfig) Reload() (changed []string, err error) {
+	var prev, next map[string]any
+
+	sc.rlock()
+	path, overrides := sc.path, sc.overrides
+	sc.runlock()
+
+	updated := new(ServerConfig)
+	if err = updated.LoadFile(path, overrides...); err != nil {
+		return changed, err
+	}
+
+	if next, err = updated.effective(false); err != nil {
+		return changed, err
+	}
+
+	sc.wlock()
+	defer sc.wunlock()
+
+	if prev, err = sc.effective(false); err != nil {
+		return changed, err
+	}
+	changed = changedKeys(prev, next)
+
+	sc.replace(updated)
+	return changed, err
+}
+
+// replace values by updated config, caller have to take a lock.
+// Env and flag overrides of start are kept for next reload
+func (sc *ServerConfig) replace(updated *ServerConfig) {
+	updated.lock, updated.path, updated.overrides = sc.lock, sc.path, sc.overrides
+	*sc = *updated
+}
+
+// changedKeys return sorted top level keys with different values
+func changedKeys(prev map[string]any, next map[string]any) (changed []string) {
+	for key, value := range next {
+		if !reflect.DeepEqual(prev[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// Path return path of loaded config file
+func (sc *ServerConfig) Path() string {
+	sc.rlock()
+	defer sc.runlock()
+	return sc.path
+}
+
+// RestartRequired return keys which are applied on restart only
+func RestartRequired(keys []string) (restart []string) {
+	for _, key := range keys {
+		if !slices.Contains(liveConfigKeys, key) {
+			restart = append(restart, key)
+		}
+	}
+	return restart
+}
+
+// View return effective config with redacted secrets, sources
+// of values and config version
+func (sc *ServerConfig) View() (view ConfigResponse, err error) {
+	sc.rlock()
+	defer sc.runlock()
+
+	if view.Version, err = sc.version(); err != nil {
+		return view, err
+	}
+
+	if view.Config, err = sc.effective(true); err != nil {
+		return view, err
+	}
+
+	view.Sources = make(map[string]string)
+	walkLeaves(view.Config, "", func(key string) { view.Sources[key] = sc.source(key) })
+
+	return view, err
+}
+
+// Version return hash of effective config
+func (sc *ServerConfig) Version() (string, error) {
+	sc.rlock()
+	defer sc.runlock()
+	return sc.version()
+}
+
+func (sc *ServerConfig) version() (string, error) {
+	buf, err := yaml.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// effective return config as map by yaml keys
+func (sc *ServerConfig) effective(redact bool) (m map[string]any, err error) {
+	var buf []byte
+
+	if buf, err = yaml.Marshal(sc); err != nil {
+		return m, err
+	}
+
+	if redact {
+		// redact deep copy, shared slices and maps stay untouched
+		var clone ServerConfig
+		if err = yaml.Unmarshal(buf, &clone); err != nil {
+			return m, err
+		}
+
+		redactValue(reflect.ValueOf(&clone).Elem())
+		if buf, err = yaml.Marshal(&clone); err != nil {
+			return m, err
+		}
+	}
+
+	err = yaml.Unmarshal(buf, &m)
+	return m, err
+}
+
+// overridden return true if value or its parent is set by env
+// or flag, such values are not saved into config file
+func (sc *ServerConfig) overridden(key string) bool {
+	src := sc.source(key)
+	return src == SourceEnv || src == SourceFlag
+}
+
+// source return source of value or of its nearest parent
+func (sc *ServerConfig) source(key string) string {
+	for {
+		if src, ok := sc.sources[key]; ok {
+			return src
+		}
+
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return SourceDefault
+		}
+		key = key[:i]
+	}
+}
+
+func (sc *ServerConfig) wlock() {
+	if sc.lock != nil {
+		sc.lock.Lock()
+	}
+}
+
+func (sc *ServerConfig) wunlock() {
+	if sc.lock != nil {
+		sc.lock.Unlock()
+	}
+}
+
+func (sc *ServerConfig) rlock() {
+	if sc.lock != nil {
+		sc.lock.RLock()
+	}
+}
+
+func (sc *ServerConfig) runlock() {
+	if sc.lock != nil {
+		sc.lock.RUnlock()
+	}
+}
+
+// walkLeaves call fn with dotted key of each leaf value. Lists and
+// empty maps are leaves
+func walkLeaves(m map[string]any, prefix string, fn func(key string)) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+			walkLeaves(nested, key, fn)
+			continue
+		}
+		fn(key)
+	}
+}
+
+// mergePatch apply JSON merge patch (RFC 7386) to m: null remove
+// key, objects are merged, other values are replaced
+func mergePatch(m map[string]any, patch map[string]any) {
+	for key, value := range patch {
+		if value == nil {
+			delete(m, key)
+			continue
+		}
+
+		nested, ok := value.(map[string]any)
+		if !ok {
+			m[key] = value
+			continue
+		}
+
+		target, ok := m[key].(map[string]any)
+		if !ok {
+			target = make(map[string]any, len(nested))
+		}
+		mergePatch(target, nested)
+		m[key] = target
+	}
+}
+
+// checkRedacted reject redacted values taken from GET config,
+// secrets have to be sent as is
+func checkRedacted(value any, key string) error {
+	switch v := value.(type) {
+	case string:
+		if v == redactedValue {
+			return fmt.Errorf("%w: redacted value of %s", BadConfigUpdate, key)
+		}
+	case map[string]any:
+		for k, item := range v {
+			nested := k
+			if key != "" {
+				nested = key + "." + k
+			}
+
+			if err := checkRedacted(item, nested); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, item := range v {
+			if err := checkRedacted(item, fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// persistPatch write leaves of patch with values from m into
+// config file, null leaves are removed. Leaves which skip returns
+// true for are not written. Other keys and comments are kept, file
+// is replaced atomically
+func persistPatch(
+	path string,
+	patch map[string]any,
+	m map[string]any,
+	skip func(key string) bool,
+) (err error) {
+	var doc yaml.Node
+	var buf []byte
+	var info os.FileInfo
+
+	if path == "" {
+		return fmt.Errorf("%w: config is not loaded from file", BadConfigUpdate)
+	}
+
+	if info, err = os.Stat(path); err != nil {
+		return err
+	}
+
+	if buf, err = os.ReadFile(path); err != nil {
+		return err
+	}
+
+	if err = yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%w: %s is not a mapping", BadConfigUpdate, path)
+	}
+
+	if err = patchMapping(root, patch, m, "", skip); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
 		return err
 	}
 
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, out.Bytes(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// patchMapping set leaves of patch with values from m in mapping
+// node, nested mappings are created if missing
+func patchMapping(
+	mapping *yaml.Node,
+	patch map[string]any,
+	m map[string]any,
+	prefix string,
+	skip func(key string) bool,
+) (err error) {
+	for k, v := range patch {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+			child := mappingValue(mapping, k)
+			if child == nil || child.Kind != yaml.MappingNode {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setMappingValue(mapping, k, child)
+			}
+
+			values, _ := m[k].(map[string]any)
+			if err = patchMapping(child, nested, values, key, skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if skip(key) {
+			continue
+		}
+
+		if v == nil {
+			deleteMappingValue(mapping, k)
+			continue
+		}
+
+		value := new(yaml.Node)
+		if err = value.Encode(m[k]); err != nil {
+			return err
+		}
+		setMappingValue(mapping, k, value)
+	}
 	return err
 }
 
-// Validate config fields and return error if validation failed
+// mappingValue return value of key in mapping node, nil if missing
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// deleteMappingValue remove key and its value from mapping node
+func deleteMappingValue(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return
+		}
+	}
+}
+
+// setMappingValue replace value of key in mapping node or append
+// new pair
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+
+	mapping.Content = append(
+		mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+// redactValue replace non-empty string fields tagged with
+// redact:"true" in v and all nested values
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			redactValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			fv := v.Field(i)
+			if f.Tag.Get("redact") == "true" && fv.Kind() == reflect.String {
+				if fv.String() != "" {
+					fv.SetString(redactedValue)
+				}
+				continue
+			}
+			redactValue(fv)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			redactValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			// map values are not addressable - redact copy
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(key))
+			redactValue(item)
+			v.SetMapIndex(key, item)
+		}
+	}
+}
+
+// Roots return allowed roots for requested paths
+func (sc *ServerConfig) Roots() []string {
+	if len(sc.AllowedRoots) > 0 {
+		return sc.AllowedRoots
+	}
+	return []string{sc.SrcPath, sc.DstPath}
+}
+
+// checkPairs check that src and dst of configured pairs
+// are not nested
+func (sc *ServerConfig) checkPairs() (problems []ConfigProblem) {
+	for i, pair := range sc.Watch {
+		if err := CheckNested(pair.SrcPath, pair.DstPath); err != nil {
+			problems = append(problems, ConfigProblem{Key: fmt.Sprintf("watch[%d]", i), Err: err})
+		}
+	}
+
+	for i, job := range sc.Jobs {
+		if err := CheckNested(job.SrcPath, job.DstPath); err != nil {
+			problems = append(problems, ConfigProblem{Key: fmt.Sprintf("jobs[%d]", i), Err: err})
+		}
+	}
+
+	for name, profile := range sc.Profiles {
+		if err := CheckNested(profile.SrcPath, profile.DstPath); err != nil {
+			problems = append(problems, ConfigProblem{Key: "profiles." + name, Err: err})
+		}
+	}
+
+	return problems
+}
+
+// ThrottleLimits return base limits from throttling section
+func (sc *ServerConfig) ThrottleLimits() ThrottleLimits {
+	return ThrottleLimits{
+		BytesPerSec:       sc.BytesPerSec,
+		FilesPerSec:       sc.FilesPerSec,
+		WorkerBytesPerSec: sc.WorkerBytesPerSec,
+	}
+}
+
+// RetryPolicy build retry policy from retry section
+func (sc *ServerConfig) RetryPolicy() (p RetryPolicy, err error) {
+	return MakeRetryPolicy(
+		sc.RetryMaxAttempts,
+		sc.RetryBaseDelay,
+		sc.RetryJitter,
+		sc.RetryErrors,
+	)
+}
+
+// Validate config fields by tag rules and cross-field checks.
+// Return ConfigErrors with all problems if validation failed
 func (sc *ServerConfig) Validate() (ok bool, err error) {
+	var problems ConfigErrors
+
+	add := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, ConfigProblem{Key: key, Err: err})
+		}
+	}
+
 	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(
+		func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			return name
+		},
+	)
+
 	if err = v.Struct(sc); err != nil {
-		return ok, err
+		var fieldErrs validator.ValidationErrors
+		if !errors.As(err, &fieldErrs) {
+			return ok, err
+		}
+
+		for _, fe := range fieldErrs {
+			// namespace starts with struct name
+			_, key, _ := strings.Cut(fe.Namespace(), ".")
+			add(key, describeRule(fe))
+		}
+	}
+
+	if _, err = convertLogLevel(sc.LogLevel); err != nil {
+		add("log_level", fmt.Errorf("%w %q", err, sc.LogLevel))
+	}
+
+	// equal paths are nested too
+	add("dst_path", CheckNested(sc.SrcPath, sc.DstPath))
+
+	// any origin with credentials expose API to every site
+	if sc.AllowCredentials && slices.Contains(sc.AllowedHosts, "*") {
+		add("allow_credentials", fmt.Errorf("can't be used with '*' in allowed_hosts"))
+	}
+
+	if sc.SwaggerEnabled && sc.SwaggerPort == sc.Port {
+		add("swagger_port", fmt.Errorf("must differ from port"))
+	}
+
+	_, err = sc.RetryPolicy()
+	add("retry_errors", err)
+
+	add("jobs", ValidateJobs(sc.Jobs))
+	add("profiles", ValidateProfiles(sc.Profiles))
+
+	// own rate limits of profiles
+	for name, profile := range sc.Profiles {
+		if profile.Limits != nil {
+			_, err = MakeThrottle(*profile.Limits, nil)
+			add("profiles."+name+".limits", err)
+		}
+	}
+
+	add("auth", sc.Auth.Validate())
+	add("tls", sc.TLS.Validate())
+	add("unix_socket", sc.UnixSocket.Validate())
+
+	problems = append(problems, sc.checkPairs()...)
+
+	_, err = MakeThrottle(sc.ThrottleLimits(), sc.ThrottleSchedule)
+	add("throttle_schedule", err)
+
+	if len(problems) > 0 {
+		return false, problems
+	}
+
+	return true, nil
+}
+
+// describeRule return human-readable message of failed tag rule
+func describeRule(fe validator.FieldError) error {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Errorf("required")
+	case "required_if":
+		param := strings.Fields(fe.Param())
+		if len(param) == 2 {
+			return fmt.Errorf("required when %s is %s", configKey(param[0]), param[1])
+		}
+		return fmt.Errorf("required when %s", fe.Param())
+	case "ipv4":
+		return fmt.Errorf("must be an IPv4 address, got %q", fe.Value())
+	case "numeric":
+		return fmt.Errorf("must be numeric, got %q", fe.Value())
+	case "dirpath":
+		return fmt.Errorf("must be a directory or path ending with /, got %q", fe.Value())
+	case "min":
+		return fmt.Errorf("must have at least %s items", fe.Param())
+	case "oneof":
+		return fmt.Errorf("must be one of [%s], got %q", fe.Param(), fe.Value())
+	case "gt", "gte", "lt", "lte":
+		ops := map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+		return fmt.Errorf("must be %s %s, got %v", ops[fe.Tag()], fe.Param(), fe.Value())
 	}
+	return fmt.Errorf("failed %q rule, got %v", fe.Tag(), fe.Value())
+}
 
-	return ok, err
+// configKey return yaml key of ServerConfig field by Go name
+func configKey(field string) string {
+	if f, ok := reflect.TypeOf(ServerConfig{}).FieldByName(field); ok {
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		return name
+	}
+	return field
 }
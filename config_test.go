@@ -0,0 +1,358 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+host: 127.0.0.1
+port: 6767
+max_diff_percent: 10
+cache_dir: /var/cache/fsyncd
+conn_read_timeout: 10s
+conn_write_timeout: 10s
+graceful_shutdown_timeout: 5s
+allowed_hosts: ["https://a.example.com"]
+allowed_methods: [GET]
+allowed_headers: ["*"]
+time_format: "15:04:05"
+log_level: info
+metrics_enabled: true
+auth:
+  enabled: true
+  tokens:
+    - name: ci
+      token: secret-token
+      roles: [sync]
+  hmac_keys:
+    - name: agent
+      key_id: k1
+      secret: secret-key
+      roles: [read]
+`
+
+// testPaths return overrides of src_path and dst_path by temp
+// directories, kept on reload
+func testPaths(t *testing.T) []ConfigOverride {
+	return []ConfigOverride{
+		{Key: "src_path", Value: t.TempDir(), Source: SourceFlag},
+		{Key: "dst_path", Value: t.TempDir(), Source: SourceFlag},
+	}
+}
+
+// loadConfig write config text to temp file and load it
+func loadConfig(t *testing.T, text string) *ServerConfig {
+	t.Helper()
+
+	cfg := new(ServerConfig)
+	require.NoError(t, cfg.LoadFile(writeFile(t, text), testPaths(t)...))
+	return cfg
+}
+
+func TestServerConfig_View(t *testing.T) {
+	cfg := loadConfig(t, testConfig)
+
+	view, err := cfg.View()
+	require.NoError(t, err)
+	require.Len(t, view.Version, 16)
+
+	auth := view.Config["auth"].(map[string]any)
+	token := auth["tokens"].([]any)[0].(map[string]any)
+	require.Equal(t, redactedValue, token["token"])
+	require.Equal(t, "ci", token["name"])
+
+	key := auth["hmac_keys"].([]any)[0].(map[string]any)
+	require.Equal(t, redactedValue, key["secret"])
+	require.Equal(t, "k1", key["key_id"])
+
+	// running config is not redacted
+	require.Equal(t, "secret-token", cfg.Auth.Tokens[0].Token)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "host", want: SourceFile},
+		{key: "auth.enabled", want: SourceFile},
+		{key: "auth.tokens", want: SourceFile},
+		{key: "sync_mode", want: SourceDefault},
+		{key: "auth.hmac_max_skew", want: SourceDefault},
+		{key: "tls.min_version", want: SourceDefault},
+		{key: "graceful_shutdown_timeout", want: SourceFile},
+		{key: "scan_workers", want: SourceDefault},
+		{key: "src_path", want: SourceFlag},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.key, func(t *testing.T) {
+				require.Equal(t, tt.want, view.Sources[tt.key])
+			},
+		)
+	}
+	require.Equal(t, ModeFull, view.Config["sync_mode"])
+}
+
+func TestServerConfig_Version(t *testing.T) {
+	paths := testPaths(t)
+	cfg, other := new(ServerConfig), new(ServerConfig)
+	require.NoError(t, cfg.LoadFile(writeFile(t, testConfig), paths...))
+	require.NoError(t, other.LoadFile(writeFile(t, testConfig), paths...))
+
+	first, err := cfg.Version()
+	require.NoError(t, err)
+
+	same, err := other.Version()
+	require.NoError(t, err)
+	require.Equal(t, first, same)
+
+	// secrets change version even if redacted in output
+	cfg.Auth.Tokens[0].Token = "other-token"
+	changed, err := cfg.Version()
+	require.NoError(t, err)
+	require.NotEqual(t, first, changed)
+}
+
+func TestServerConfig_Update(t *testing.T) {
+	tests := []struct {
+		name        string
+		patch       map[string]any
+		version     string
+		wantChanged []string
+		wantErr     error
+	}{
+		{
+			name:        "test update",
+			patch:       map[string]any{"max_diff_percent": 40, "auth": map[string]any{"enabled": false}},
+			wantChanged: []string{"auth", "max_diff_percent"},
+		},
+		{
+			name:    "test version mismatch",
+			patch:   map[string]any{"max_diff_percent": 40},
+			version: "0000000000000000",
+			wantErr: ConfigVersionMismatch,
+		},
+		{
+			name:    "test unknown key",
+			patch:   map[string]any{"max_diff_prcent": 40},
+			wantErr: BadConfigUpdate,
+		},
+		{
+			name:    "test bad value",
+			patch:   map[string]any{"retry_errors": []any{"EBOGUS"}},
+			wantErr: BadConfigUpdate,
+		},
+		{
+			name: "test redacted secret",
+			patch: map[string]any{
+				"auth": map[string]any{
+					"tokens": []any{map[string]any{"name": "ci", "token": redactedValue}},
+				},
+			},
+			wantErr: BadConfigUpdate,
+		},
+		{
+			name:        "test remove value",
+			patch:       map[string]any{"cache_dir": nil},
+			wantChanged: []string{"cache_dir"},
+		},
+		{
+			name:    "test invalid value",
+			patch:   map[string]any{"max_diff_percent": 101},
+			wantErr: InvalidConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg := loadConfig(t, testConfig)
+				before := cfg.Snapshot()
+
+				changed, err := cfg.Update(tt.patch, tt.version, false)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					require.Equal(t, before, cfg.Snapshot())
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tt.wantChanged, changed)
+
+				// snapshot taken before update is not changed
+				require.Equal(t, "127.0.0.1", before.Host)
+				require.Equal(t, 10, before.MaxDiffPercent)
+			},
+		)
+	}
+}
+
+func TestServerConfig_UpdatePersist(t *testing.T) {
+	cfg := loadConfig(t, "# keep me"+testConfig)
+
+	version, err := cfg.Version()
+	require.NoError(t, err)
+
+	changed, err := cfg.Update(map[string]any{"max_diff_percent": 20}, version, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"max_diff_percent"}, changed)
+	require.Empty(t, RestartRequired(changed))
+
+	buf, err := os.ReadFile(cfg.path)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "# keep me")
+	require.Contains(t, string(buf), "max_diff_percent: 20")
+
+	view, err := cfg.View()
+	require.NoError(t, err)
+	require.Equal(t, SourceFile, view.Sources["max_diff_percent"])
+
+	// old version is rejected
+	_, err = cfg.Update(map[string]any{"max_diff_percent": 30}, version, false)
+	require.ErrorIs(t, err, ConfigVersionMismatch)
+}
+
+func TestServerConfig_UpdatePersistLeaves(t *testing.T) {
+	cfg := new(ServerConfig)
+	overrides := append(testPaths(t), ConfigOverride{Key: "log_level", Value: "warn", Source: SourceEnv})
+	require.NoError(t, cfg.LoadFile(writeFile(t, testConfig), overrides...))
+
+	patch := map[string]any{
+		"log_level": "error",
+		"cache_dir": nil,
+		"auth":      map[string]any{"enabled": false},
+	}
+	_, err := cfg.Update(patch, "", true)
+	require.NoError(t, err)
+
+	buf, err := os.ReadFile(cfg.path)
+	require.NoError(t, err)
+	text := string(buf)
+
+	// env and flag values and defaults aren't written
+	require.Contains(t, text, "log_level: info")
+	require.NotContains(t, text, "src_path")
+	require.NotContains(t, text, "hmac_max_skew")
+
+	// only patched leaves are changed
+	require.Contains(t, text, "enabled: false")
+	require.Contains(t, text, "token: secret-token")
+	require.NotContains(t, text, "cache_dir")
+}
+
+func TestServerConfig_Reload(t *testing.T) {
+	cfg := loadConfig(t, testConfig)
+	before := cfg.Snapshot()
+
+	// invalid config keep running one
+	require.NoError(t, os.WriteFile(cfg.Path(), []byte("log_level: loud\n"), 0600))
+	_, err := cfg.Reload()
+	require.ErrorIs(t, err, UnexpectedLevel)
+	require.Equal(t, before, cfg.Snapshot())
+
+	update := strings.Replace(testConfig, "max_diff_percent: 10", "max_diff_percent: 50", 1)
+	require.NoError(t, os.WriteFile(cfg.Path(), []byte(update), 0600))
+	changed, err := cfg.Reload()
+	require.NoError(t, err)
+	require.Equal(t, []string{"max_diff_percent"}, changed)
+	require.Equal(t, 50, cfg.Snapshot().MaxDiffPercent)
+}
+
+func TestServerConfig_ReloadAfterUpdate(t *testing.T) {
+	cfg := new(ServerConfig)
+	overrides := append(testPaths(t), ConfigOverride{Key: "max_diff_percent", Value: "42", Source: SourceEnv})
+	require.NoError(t, cfg.LoadFile(writeFile(t, testConfig), overrides...))
+
+	_, err := cfg.Update(map[string]any{"log_level": "debug"}, "", false)
+	require.NoError(t, err)
+
+	// env override is applied again on reload
+	_, err = cfg.Reload()
+	require.NoError(t, err)
+	require.Equal(t, 42, cfg.Snapshot().MaxDiffPercent)
+	require.Equal(t, SourceEnv, cfg.sources["max_diff_percent"])
+}
+
+func TestServerConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		replace  []string
+		wantKeys []string
+	}{
+		{name: "test valid"},
+		{
+			name:     "test tag rules",
+			replace:  []string{"host: 127.0.0.1", "host: localhost", "port: 6767", "port: http"},
+			wantKeys: []string{"host", "port"},
+		},
+		{
+			name:     "test swagger port required",
+			replace:  []string{"port: 6767", "port: 6767\nswagger_enabled: true"},
+			wantKeys: []string{"swagger_port"},
+		},
+		{
+			name:     "test durations",
+			replace:  []string{"conn_read_timeout: 10s", "conn_read_timeout: -1s", "log_level: info", "log_level: info\nwatch_debounce: -1s"},
+			wantKeys: []string{"conn_read_timeout", "watch_debounce"},
+		},
+		{
+			name:     "test log level",
+			replace:  []string{"log_level: info", "log_level: loud"},
+			wantKeys: []string{"log_level"},
+		},
+		{
+			name:     "test any origin with credentials",
+			replace:  []string{`allowed_hosts: ["https://a.example.com"]`, "allowed_hosts: [\"*\"]\nallow_credentials: true"},
+			wantKeys: []string{"allow_credentials"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				text := strings.NewReplacer(tt.replace...).Replace(testConfig)
+
+				cfg := new(ServerConfig)
+				err := cfg.LoadFile(writeFile(t, text), testPaths(t)...)
+				if len(tt.wantKeys) == 0 {
+					require.NoError(t, err)
+					return
+				}
+
+				var problems ConfigErrors
+				require.ErrorAs(t, err, &problems)
+				require.ErrorIs(t, err, InvalidConfig)
+
+				keys := make([]string, 0, len(problems))
+				for _, p := range problems {
+					keys = append(keys, p.Key)
+				}
+				require.ElementsMatch(t, tt.wantKeys, keys)
+			},
+		)
+	}
+}
+
+func TestServerConfig_ValidateNested(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := new(ServerConfig)
+	err := cfg.LoadFile(
+		writeFile(t, testConfig),
+		ConfigOverride{Key: "src_path", Value: dir, Source: SourceFlag},
+		ConfigOverride{Key: "dst_path", Value: dir, Source: SourceFlag},
+	)
+	require.ErrorIs(t, err, NestedSyncPaths)
+	require.ErrorContains(t, err, "dst_path:")
+}
+
+func TestServerConfig_ValidateDirPath(t *testing.T) {
+	file := writeFile(t, "")
+
+	cfg := new(ServerConfig)
+	err := cfg.LoadFile(
+		writeFile(t, testConfig),
+		ConfigOverride{Key: "src_path", Value: file, Source: SourceFlag},
+	)
+	require.ErrorIs(t, err, InvalidConfig)
+	require.ErrorContains(t, err, "src_path: must be a directory or path ending with /")
+}
@@ -0,0 +1,176 @@
+// contains CORS middleware driven by server config
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS answer preflight requests and set CORS headers for
+// allowed origins. Origin patterns support '*' wildcard
+// (https://*.example.com), single '*' allow any origin
+type CORS struct {
+	lock   *sync.RWMutex
+	policy *corsPolicy
+}
+
+// corsPolicy is a set of CORS rules, replaced as a whole
+type corsPolicy struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// MakeCORS factory function return new CORS
+func MakeCORS(
+	origins []string,
+	methods []string,
+	headers []string,
+	credentials bool,
+	maxAge time.Duration,
+) *CORS {
+	cors := &CORS{lock: new(sync.RWMutex)}
+	cors.Set(origins, methods, headers, credentials, maxAge)
+	return cors
+}
+
+// Set replace CORS rules, applied to next requests
+func (cors *CORS) Set(
+	origins []string,
+	methods []string,
+	headers []string,
+	credentials bool,
+	maxAge time.Duration,
+) {
+	upper := make([]string, 0, len(methods))
+	for _, m := range methods {
+		upper = append(upper, strings.ToUpper(m))
+	}
+
+	cors.lock.Lock()
+	defer cors.lock.Unlock()
+
+	cors.policy = &corsPolicy{
+		origins:     slices.Clone(origins),
+		methods:     upper,
+		headers:     slices.Clone(headers),
+		credentials: credentials,
+		maxAge:      maxAge,
+	}
+}
+
+// Handle is a gin middleware. Must be installed before auth
+// because preflight requests have no credentials
+func (cors *CORS) Handle(c *gin.Context) {
+	cors.lock.RLock()
+	p := cors.policy
+	cors.lock.RUnlock()
+
+	p.handle(c)
+}
+
+func (cors *corsPolicy) handle(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+
+	c.Writer.Header().Add("Vary", "Origin")
+	method := strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))
+	preflight := c.Request.Method == http.MethodOptions && method != ""
+
+	if !cors.allowOrigin(origin) || (preflight && !cors.allowMethod(method)) {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		// no CORS headers - browser will block response
+		c.Next()
+		return
+	}
+
+	// wildcard can`t be used with credentials (rejected by config
+	// validation), any origin is never allowed to send them
+	anyOrigin := slices.Contains(cors.origins, "*")
+	if anyOrigin {
+		c.Header("Access-Control-Allow-Origin", "*")
+	} else {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+
+	if cors.credentials && !anyOrigin {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		c.Next()
+		return
+	}
+
+	methods := strings.Join(cors.methods, ", ")
+	if slices.Contains(cors.methods, "*") {
+		methods = method
+	}
+	c.Header("Access-Control-Allow-Methods", methods)
+
+	headers := strings.Join(cors.headers, ", ")
+	if slices.Contains(cors.headers, "*") {
+		headers = c.GetHeader("Access-Control-Request-Headers")
+	}
+	if headers != "" {
+		c.Header("Access-Control-Allow-Headers", headers)
+	}
+
+	if cors.maxAge > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(cors.maxAge.Seconds())))
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (cors *corsPolicy) allowOrigin(origin string) bool {
+	for _, pattern := range cors.origins {
+		if wildcardMatch(strings.ToLower(pattern), strings.ToLower(origin)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *corsPolicy) allowMethod(method string) bool {
+	return slices.Contains(cors.methods, "*") || slices.Contains(cors.methods, method)
+}
+
+// wildcardMatch match s with pattern where '*' is any sequence
+func wildcardMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_wildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{pattern: "*", s: "https://a.example.com", want: true},
+		{pattern: "https://*.example.com", s: "https://a.example.com", want: true},
+		{pattern: "https://*.example.com", s: "https://example.com", want: false},
+		{pattern: "https://*.example.com", s: "https://a.example.com.evil", want: false},
+		{pattern: "http://localhost:*", s: "http://localhost:3000", want: true},
+		{pattern: "https://ui.example.com", s: "https://ui.example.com", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.pattern+" "+tt.s, func(t *testing.T) {
+				require.Equal(t, tt.want, wildcardMatch(tt.pattern, tt.s))
+			},
+		)
+	}
+}
+
+func TestCORS_Handle(t *testing.T) {
+	cors := MakeCORS(
+		[]string{"https://*.example.com"},
+		[]string{"get", "patch"},
+		[]string{"Authorization", "Content-Type"},
+		true,
+		10*time.Minute,
+	)
+
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.Use(cors.Handle)
+	g.PATCH("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		reqMethod   string
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+		wantMaxAge  string
+	}{
+		{
+			name:        "test preflight",
+			method:      http.MethodOptions,
+			origin:      "https://ui.example.com",
+			reqMethod:   "PATCH",
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://ui.example.com",
+			wantMethods: "GET, PATCH",
+			wantMaxAge:  "600",
+		},
+		{
+			name:      "test preflight method not allowed",
+			method:    http.MethodOptions,
+			origin:    "https://ui.example.com",
+			reqMethod: "DELETE",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "test preflight origin not allowed",
+			method:    http.MethodOptions,
+			origin:    "https://evil.com",
+			reqMethod: "PATCH",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:       "test simple request",
+			method:     http.MethodPatch,
+			origin:     "https://ui.example.com",
+			wantCode:   http.StatusOK,
+			wantOrigin: "https://ui.example.com",
+		},
+		{
+			name:     "test simple request origin not allowed",
+			method:   http.MethodPatch,
+			origin:   "https://evil.com",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, "/api", nil)
+				r.Header.Set("Origin", tt.origin)
+				if tt.reqMethod != "" {
+					r.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+				}
+
+				w := httptest.NewRecorder()
+				g.ServeHTTP(w, r)
+
+				require.Equal(t, tt.wantCode, w.Code)
+				require.Equal(t, tt.wantOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+				require.Equal(t, tt.wantMethods, w.Header().Get("Access-Control-Allow-Methods"))
+				require.Equal(t, tt.wantMaxAge, w.Header().Get("Access-Control-Max-Age"))
+			},
+		)
+	}
+}
+
+func TestCORS_Handle_anyOrigin(t *testing.T) {
+	cors := MakeCORS([]string{"*"}, []string{"*"}, []string{"*"}, true, 0)
+
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.Use(cors.Handle)
+	g.PATCH("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	r := httptest.NewRequest(http.MethodPatch, "/api", nil)
+	r.Header.Set("Origin", "https://evil.com")
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+
+	// origin isn't echoed, credentials aren't allowed
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
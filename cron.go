@@ -0,0 +1,208 @@
+// contains cron expressions parser
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit max period to search next activation time
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+var BadCronExpression = fmt.Errorf("bad cron expression")
+
+// cronDescriptors predefined schedules
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField bounds of single field
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 6},
+}
+
+// CronSchedule is a parsed five fields cron expression:
+// minute, hour, day of month, month, day of week. Each field
+// supports '*', lists (1,2), ranges (1-5) and steps (*/15, 1-30/5).
+// Day of week 7 is also Sunday. If both day fields are restricted
+// any of them matches (as classic cron does)
+type CronSchedule struct {
+	expr string
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domAny bool
+	dowAny bool
+}
+
+// ParseCron parse cron expression or descriptor (@daily, @hourly, ...)
+func ParseCron(expr string) (s *CronSchedule, err error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return s, fmt.Errorf(
+			"%w: %q: want %d fields, got %d",
+			BadCronExpression,
+			expr,
+			len(cronFields),
+			len(parts),
+		)
+	}
+
+	masks := make([]uint64, len(parts))
+	for i, part := range parts {
+		if masks[i], err = parseCronField(part, cronFields[i]); err != nil {
+			return s, fmt.Errorf("%w: %q: %w", BadCronExpression, expr, err)
+		}
+	}
+
+	// 7 is Sunday too
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+
+	return &CronSchedule{
+		expr:   expr,
+		minute: masks[0],
+		hour:   masks[1],
+		dom:    masks[2],
+		month:  masks[3],
+		dow:    masks[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, err
+}
+
+// parseCronField return bit mask of allowed values
+func parseCronField(part string, f cronField) (mask uint64, err error) {
+	maxValue := f.max
+	if f.name == "day of week" {
+		maxValue = 7
+	}
+
+	for _, item := range strings.Split(part, ",") {
+		var lo, hi int
+		step := 1
+
+		rng := item
+		if i := strings.Index(item, "/"); i >= 0 {
+			rng = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return mask, fmt.Errorf("%s: bad step %q", f.name, item)
+			}
+		}
+
+		switch {
+		case rng == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return mask, fmt.Errorf("%s: bad range %q", f.name, item)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return mask, fmt.Errorf("%s: bad range %q", f.name, item)
+			}
+		default:
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return mask, fmt.Errorf("%s: bad value %q", f.name, item)
+			}
+			hi = lo
+
+			// single value with step means range up to max (5/15)
+			if step > 1 {
+				hi = f.max
+			}
+		}
+
+		if lo < f.min || hi > maxValue || lo > hi {
+			return mask, fmt.Errorf(
+				"%s: %q out of range [%d, %d]",
+				f.name,
+				item,
+				f.min,
+				maxValue,
+			)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << v
+		}
+	}
+
+	return mask, nil
+}
+
+// String return source expression
+func (s *CronSchedule) String() string {
+	return s.expr
+}
+
+// Next return first activation time after t (with minute precision).
+// Return zero time if there is no activation in the next five years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *CronSchedule) dayMatch(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	// Monday
+	from := time.Date(2024, 1, 1, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{
+			name: "test every 15 minutes",
+			expr: "*/15 * * * *",
+			want: time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "test daily descriptor",
+			expr: "@daily",
+			want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test business days list and range",
+			expr: "0 9,18 * * 1-5",
+			want: time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test sunday as 7",
+			expr: "0 0 * * 7",
+			want: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test restricted day of month or day of week",
+			expr: "0 0 15 * 3",
+			want: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test leap day",
+			expr: "0 0 29 2 *",
+			want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				s, err := ParseCron(tt.expr)
+				require.NoError(t, err)
+				require.Equal(t, tt.want, s.Next(from))
+			},
+		)
+	}
+}
+
+func TestParseCron_Error(t *testing.T) {
+	for _, expr := range []string{"* * * *", "61 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := ParseCron(expr)
+		require.ErrorIs(t, err, BadCronExpression, expr)
+	}
+}
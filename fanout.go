@@ -0,0 +1,469 @@
+// contains fan-out sync from one source to many destinations
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+var FanOutFailed = fmt.Errorf("fan-out sync failed")
+
+var BadFanOut = fmt.Errorf("bad fan-out destinations")
+
+// DestinationResult contains sync result of single destination
+type DestinationResult struct {
+	DstPath string      `json:"dst_path"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Result  *SyncResult `json:"result"`
+}
+
+// FanOutResult contains results of all destinations
+type FanOutResult struct {
+	SrcPath      string              `json:"src_path"`
+	Succeeded    int                 `json:"succeeded"`
+	Failed       int                 `json:"failed"`
+	Destinations []DestinationResult `json:"destinations"`
+}
+
+// FanOut replicate one source tree into many destinations. Source is
+// scanned once, each destination get own SyncCommand. Files needed by
+// several destinations are read once and written to all of them.
+// Source is a master: newer destination files are overwritten
+type FanOut struct {
+	SrcPath  string
+	DstPaths []string
+
+	// Filter select synced entries (nil - all)
+	Filter *PathFilter
+
+	// NoDelete keep entries which not exist in source
+	NoDelete bool
+
+	// Synchronizer settings shared by all destinations
+	Synchronizer Synchronizer
+}
+
+// Validate check that destinations are set, unique and differ
+// from source
+func (f *FanOut) Validate() (err error) {
+	if len(f.DstPaths) == 0 {
+		return fmt.Errorf("%w: no destinations", BadFanOut)
+	}
+
+	seen := make(map[string]struct{}, len(f.DstPaths))
+	for _, path := range f.DstPaths {
+		path = filepath.Clean(path)
+		if path == filepath.Clean(f.SrcPath) {
+			return fmt.Errorf("%w: destination %q is a source", BadFanOut, path)
+		}
+
+		if _, ok := seen[path]; ok {
+			return fmt.Errorf("%w: duplicated destination %q", BadFanOut, path)
+		}
+		seen[path] = struct{}{}
+	}
+
+	return err
+}
+
+// fanOutDestination sync state of single destination
+type fanOutDestination struct {
+	path string
+	s    Synchronizer
+	cmd  SyncCommand
+
+	lock *sync.Mutex
+	err  error
+}
+
+// fail save first destination error
+func (d *fanOutDestination) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *fanOutDestination) failed() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.err != nil
+}
+
+// fanOutTarget destination of shared source file
+type fanOutTarget struct {
+	dst  *fanOutDestination
+	pair SyncPair
+}
+
+// Sync run fan-out sync. Failure of one destination does not stop
+// others, FanOutFailed is returned if any destination failed
+func (f *FanOut) Sync(ctx context.Context, log *logrus.Logger) (
+	res *FanOutResult,
+	err error,
+) {
+	var g errgroup.Group
+
+	srcMeta := MakeSyncMeta()
+	dstMetas := make([]SyncMeta, len(f.DstPaths))
+	dests := make([]*fanOutDestination, len(f.DstPaths))
+
+	// scan source once and destinations concurrently
+	g.Go(
+		func() error {
+			return srcMeta.MakeMeta(f.SrcPath)
+		},
+	)
+
+	for i, path := range f.DstPaths {
+		dests[i] = f.makeDestination(path)
+		dstMetas[i] = MakeSyncMeta()
+
+		g.Go(
+			func() error {
+				if sErr := dstMetas[i].MakeMeta(path); sErr != nil {
+					dests[i].fail(fmt.Errorf("%w: %w", ScanFailed, sErr))
+				}
+				return nil
+			},
+		)
+	}
+
+	if err = g.Wait(); err != nil {
+		return res, fmt.Errorf("%w: %w", ScanFailed, err)
+	}
+
+	options := &DestinationOptions{NoDelete: f.NoDelete, OneWay: true}
+	for i, d := range dests {
+		if d.failed() {
+			continue
+		}
+
+		d.cmd = MakeSyncCommand(f.Synchronizer.SrcDiffPercent)
+		if pErr := d.cmd.Prepare(srcMeta, dstMetas[i]); pErr != nil {
+			d.fail(pErr)
+			continue
+		}
+		applyOptions(&d.cmd, f.SrcPath, d.path, f.Filter, options)
+	}
+
+	// prepare destination trees concurrently
+	for _, d := range dests {
+		if d.failed() {
+			continue
+		}
+
+		g.Go(
+			func() error {
+				d.fail(d.prepareTree(ctx))
+				return nil
+			},
+		)
+	}
+	_ = g.Wait()
+
+	if err = f.syncFiles(ctx, log, dests); err != nil {
+		return res, err
+	}
+
+	return f.result(dests)
+}
+
+// makeDestination return destination with own synchronizer
+func (f *FanOut) makeDestination(path string) *fanOutDestination {
+	s := f.Synchronizer
+	s.SrcPath, s.DstPath = f.SrcPath, path
+	s.result = MakeSyncResult()
+
+	return &fanOutDestination{path: path, s: s, lock: new(sync.Mutex)}
+}
+
+// prepareTree delete and create directories and delete files
+func (d *fanOutDestination) prepareTree(ctx context.Context) (err error) {
+	conc := d.s.Concurrency
+
+	err = d.s.DeleteDirectories(ctx, d.cmd, d.s.poolSize(conc.DeleteDirs))
+	if err != nil {
+		return err
+	}
+
+	err = d.s.DeleteFiles(ctx, d.cmd, d.s.poolSize(conc.DeleteFiles))
+	if err != nil {
+		return err
+	}
+
+	return d.s.CreateDirectories(ctx, d.cmd, d.s.poolSize(conc.CreateDirs))
+}
+
+// syncFiles group sync pairs of all destinations by source file
+// and copy each source file once
+func (f *FanOut) syncFiles(
+	ctx context.Context,
+	log *logrus.Logger,
+	dests []*fanOutDestination,
+) (err error) {
+	var g errgroup.Group
+
+	groups := make(map[string][]fanOutTarget, DefaultSyncObjectsSize)
+	order := make([]string, 0, DefaultSyncObjectsSize)
+
+	for _, d := range dests {
+		if d.failed() {
+			continue
+		}
+
+		for _, pair := range d.cmd.SyncPairs {
+			if _, ok := groups[pair.Src]; !ok {
+				order = append(order, pair.Src)
+			}
+			groups[pair.Src] = append(groups[pair.Src], fanOutTarget{dst: d, pair: pair})
+		}
+	}
+
+	pool, tuner := f.Synchronizer.makePool(
+		f.Synchronizer.poolSize(f.Synchronizer.Concurrency.SyncFiles),
+	)
+	defer f.Synchronizer.Metrics.TrackPool(OpSyncFile, pool)()
+
+	for _, src := range order {
+		if err = pool.Acquire(ctx); err != nil {
+			break
+		}
+
+		g.Go(
+			func() error {
+				defer pool.Release()
+				worker := pool.TakeLimiter()
+				defer pool.PutLimiter(worker)
+
+				start := time.Now()
+				written := f.syncSource(ctx, log, src, groups[src], worker)
+				tuner.Observe(written, time.Since(start))
+				return nil
+			},
+		)
+	}
+
+	_ = g.Wait()
+	return err
+}
+
+// syncSource copy source file into all targets under rate limiter
+// of worker. Failed targets are retried one by one with retry policy
+func (f *FanOut) syncSource(
+	ctx context.Context,
+	log *logrus.Logger,
+	src string,
+	targets []fanOutTarget,
+	worker *RateLimiter,
+) (written int64) {
+	if err := f.Synchronizer.waitFile(ctx); err != nil {
+		for _, t := range targets {
+			t.dst.s.addResult(OpSyncFile, t.pair.Dst, 0, err)
+			t.dst.fail(err)
+		}
+		return written
+	}
+
+	written, errs := f.copyShared(ctx, log, src, targets, worker)
+	f.Synchronizer.Metrics.AddBytes(OpSyncFile, written)
+
+	for i, t := range targets {
+		s := &t.dst.s
+		if errs[i] == nil || !s.Retry.IsRetryable(errs[i]) {
+			s.addResult(OpSyncFile, t.pair.Dst, 1, errs[i])
+			t.dst.fail(errs[i])
+			continue
+		}
+
+		attempts, err := s.Retry.Do(
+			ctx, func() (err error) {
+				_, err = s.syncPair(ctx, log, t.pair, worker)
+				return err
+			},
+		)
+		s.addResult(OpSyncFile, t.pair.Dst, attempts+1, err)
+		t.dst.fail(err)
+	}
+
+	return written
+}
+
+// copyShared read source file once and write it into all targets.
+// Failed target is dropped, others continue. Return error per target
+func (f *FanOut) copyShared(
+	ctx context.Context,
+	log *logrus.Logger,
+	src string,
+	targets []fanOutTarget,
+	worker *RateLimiter,
+) (written int64, errs []error) {
+	var srcFile *os.File
+	var info os.FileInfo
+	var err error
+
+	errs = make([]error, len(targets))
+	failAll := func(err error) {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+
+	if srcFile, err = os.Open(src); err != nil {
+		failAll(err)
+		return written, errs
+	}
+	defer f.Synchronizer.fclose(log, srcFile)
+
+	if info, err = srcFile.Stat(); err != nil {
+		failAll(err)
+		return written, errs
+	}
+
+	// partially copied files of canceled copy are removed (after
+	// close) to be synced again by next run
+	files := make([]*os.File, len(targets))
+	defer func() {
+		if ctx.Err() == nil {
+			return
+		}
+
+		for i, t := range targets {
+			if files[i] != nil && errs[i] != nil {
+				_ = os.Remove(t.pair.Dst)
+			}
+		}
+	}()
+
+	for i, t := range targets {
+		files[i], errs[i] = os.OpenFile(
+			t.pair.Dst,
+			os.O_CREATE|os.O_RDWR|os.O_TRUNC,
+			t.pair.Perm,
+		)
+		if errs[i] == nil {
+			defer f.Synchronizer.fclose(log, files[i])
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		failAll(err)
+		return written, errs
+	}
+
+	buf := make([]byte, MaxThrottleSlice)
+	throttle, jobThrottle := f.Synchronizer.Throttle, f.Synchronizer.JobThrottle
+
+	for {
+		active := 0
+		for i := range files {
+			if errs[i] == nil {
+				active++
+			}
+		}
+
+		if active == 0 {
+			return written, errs
+		}
+
+		// limits are applied to written bytes, so slice is shared
+		// by active targets
+		slice, rate := throttleSlice(throttle, jobThrottle)
+		n, rErr := srcFile.Read(buf[:max(slice/active, DefaultBufferSize)])
+		if n > 0 {
+			size := int64(n * active)
+			if err = throttle.WaitBytes(ctx, size); err == nil {
+				err = jobThrottle.WaitBytes(ctx, size)
+			}
+			if err == nil {
+				worker.SetRate(rate)
+				err = worker.WaitN(ctx, int64(n))
+			}
+			if err != nil {
+				failAll(err)
+				return written, errs
+			}
+
+			for i, file := range files {
+				if errs[i] == nil {
+					_, errs[i] = file.Write(buf[:n])
+				}
+			}
+			written += size
+		}
+
+		if errors.Is(rErr, io.EOF) {
+			break
+		}
+
+		if rErr != nil {
+			failAll(rErr)
+			return written, errs
+		}
+	}
+
+	// dest inherit modification time to skip unchanged files later
+	for i, t := range targets {
+		if errs[i] == nil {
+			errs[i] = os.Chtimes(t.pair.Dst, time.Time{}, info.ModTime())
+		}
+	}
+
+	return written, errs
+}
+
+// result collect destinations results
+func (f *FanOut) result(dests []*fanOutDestination) (
+	res *FanOutResult,
+	err error,
+) {
+	res = &FanOutResult{
+		SrcPath:      f.SrcPath,
+		Destinations: make([]DestinationResult, 0, len(dests)),
+	}
+
+	for _, d := range dests {
+		dr := DestinationResult{
+			DstPath: d.path,
+			Status:  JobStatusOk,
+			Result:  d.s.result,
+		}
+
+		if d.err != nil {
+			dr.Status, dr.Error = JobStatusFailed, d.err.Error()
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+		res.Destinations = append(res.Destinations, dr)
+	}
+
+	if res.Failed > 0 {
+		err = fmt.Errorf(
+			"%w: %d of %d destinations",
+			FanOutFailed,
+			res.Failed,
+			len(dests),
+		)
+	}
+
+	return res, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFanOut_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsts    []string
+		wantErr bool
+	}{
+		{name: "test valid destinations", dsts: []string{"/a", "/b"}},
+		{name: "test no destinations", wantErr: true},
+		{name: "test destination is source", dsts: []string{"/src/"}, wantErr: true},
+		{name: "test duplicated destination", dsts: []string{"/a", "/a/"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				f := &FanOut{SrcPath: "/src", DstPaths: tt.dsts}
+				err := f.Validate()
+				if tt.wantErr {
+					require.ErrorIs(t, err, BadFanOut)
+					return
+				}
+				require.NoError(t, err)
+			},
+		)
+	}
+}
+
+func TestFanOut_Sync(t *testing.T) {
+	src, dst1, dst2 := t.TempDir(), t.TempDir(), t.TempDir()
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	makeTree(
+		t, src, map[string]string{
+			"a.txt":   "a",
+			"d/b.txt": "b",
+		},
+	)
+	makeTree(t, dst1, map[string]string{"stale.txt": "stale"})
+	makeTree(t, dst2, map[string]string{"a.txt": "old"})
+
+	f := &FanOut{
+		SrcPath:      src,
+		DstPaths:     []string{dst1, dst2, missing},
+		Synchronizer: Synchronizer{SrcDiffPercent: 100},
+	}
+
+	res, err := f.Sync(context.Background(), logrus.New())
+	require.ErrorIs(t, err, FanOutFailed)
+	require.Equal(t, 2, res.Succeeded)
+	require.Equal(t, 1, res.Failed)
+	require.Equal(t, JobStatusFailed, res.Destinations[2].Status)
+
+	for _, dst := range []string{dst1, dst2} {
+		data, rErr := os.ReadFile(filepath.Join(dst, "a.txt"))
+		require.NoError(t, rErr)
+		require.Equal(t, "a", string(data))
+
+		data, rErr = os.ReadFile(filepath.Join(dst, "d/b.txt"))
+		require.NoError(t, rErr)
+		require.Equal(t, "b", string(data))
+	}
+
+	_, err = os.Stat(filepath.Join(dst1, "stale.txt"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -363,7 +364,7 @@ func (sm *SyncMeta) MakeMeta(root string) (err error) {
 	dir := Directory{
 		Mask:       DefaultRootDirMask,
 		Name:       info.Name(), // set real name to Name
-		NestedPath: root,
+		NestedPath: DefaultRootDirMask,
 		Files:      files,
 		Perm:       info.Mode(),
 	}
@@ -393,6 +394,11 @@ func (sm *SyncMeta) makeMeta(root string, dirName string) (err error) {
 	currDir := sm.Dirs[dirName]
 
 	for _, file := range files {
+		// lock file of root is not synced
+		if dirName == DefaultRootDirMask && file.Name() == DirLockName && !file.IsDir() {
+			continue
+		}
+
 		buf.WriteString(root)
 		buf.WriteString("/")
 		buf.WriteString(file.Name())
@@ -416,23 +422,28 @@ func (sm *SyncMeta) makeMeta(root string, dirName string) (err error) {
 			continue
 		}
 
-		// create new nested directory
+		if info, err = file.Info(); err != nil {
+			return err
+		}
+
+		// create new nested directory, nested path starts with
+		// root mask to be same between synced directories
 		fCollection := make(map[string]FileMeta, DefaultSyncObjectsSize)
 		dir := Directory{
 			Mask:       "",
 			Name:       file.Name(), // set real name to Name
-			NestedPath: fPath,
+			NestedPath: currDir.NestedPath + "/" + file.Name(),
 			Files:      fCollection,
-			Perm:       info.Mode(),
+			Perm:       info.Mode().Perm(),
 		}
 
-		// save nested directories by real name because
+		// save nested directories by masked nested path because
 		// they have to be same between synced directories
 		// (but root paths are different)
-		sm.Dirs[dir.Name] = dir
+		sm.Dirs[dir.NestedPath] = dir
 
 		// is another directory - dive
-		if err = sm.makeMeta(fPath, dir.Name); err != nil {
+		if err = sm.makeMeta(fPath, dir.NestedPath); err != nil {
 			return err
 		}
 
@@ -441,3 +452,44 @@ func (sm *SyncMeta) makeMeta(root string, dirName string) (err error) {
 
 	return err
 }
+
+// SyncPlan contains operations of prepared sync (dry run)
+type SyncPlan struct {
+	SrcPath     string   `json:"src_path"`
+	DstPath     string   `json:"dst_path"`
+	CreateDirs  []string `json:"create_dirs"`
+	DeleteDirs  []string `json:"delete_dirs"`
+	DeleteFiles []string `json:"delete_files"`
+	SyncFiles   []string `json:"sync_files"`
+}
+
+// Plan return sorted operations of prepared command
+func (cmd SyncCommand) Plan() SyncPlan {
+	plan := SyncPlan{
+		CreateDirs:  make([]string, 0, len(cmd.DirsToCreate)),
+		DeleteDirs:  slices.Clone(cmd.DirsToDelete),
+		DeleteFiles: make([]string, 0, len(cmd.FilesToDelete)),
+		SyncFiles:   make([]string, 0, len(cmd.SyncPairs)),
+	}
+
+	for _, dir := range cmd.DirsToCreate {
+		plan.CreateDirs = append(plan.CreateDirs, dir.DirPath)
+	}
+
+	for _, files := range cmd.FilesToDelete {
+		plan.DeleteFiles = append(plan.DeleteFiles, files...)
+	}
+
+	for _, pair := range cmd.SyncPairs {
+		plan.SyncFiles = append(plan.SyncFiles, pair.Dst)
+	}
+
+	if plan.DeleteDirs == nil {
+		plan.DeleteDirs = []string{}
+	}
+
+	for _, ops := range [][]string{plan.CreateDirs, plan.DeleteDirs, plan.DeleteFiles, plan.SyncFiles} {
+		slices.Sort(ops)
+	}
+	return plan
+}
@@ -0,0 +1,252 @@
+// contains locks of synced directories: in-process locks of path sets
+// and advisory lock files shared between processes
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DirLockName name of lock file in root of synced directory, lock
+// files are not synced
+const DirLockName = ".fsyncd.lock"
+
+// dirLockAttempts attempts to lock file replaced by other process
+const dirLockAttempts = 3
+
+var DirLocked = fmt.Errorf("directory locked by other process")
+
+// LockInfo is an owner of lock file
+type LockInfo struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", i.PID, i.Host, i.Since.Format(time.RFC3339))
+}
+
+// PathLocks lock sets of paths within process. Paths conflict if
+// equal or nested into each other
+type PathLocks struct {
+	lock *sync.Mutex
+	held map[string]struct{}
+}
+
+// MakePathLocks factory function return new PathLocks
+func MakePathLocks() *PathLocks {
+	return &PathLocks{
+		lock: new(sync.Mutex),
+		held: make(map[string]struct{}),
+	}
+}
+
+// TryLock lock all paths if none of them conflict with locked ones.
+// Return release function and false if paths are busy
+func (l *PathLocks) TryLock(paths ...string) (release func(), ok bool) {
+	cleaned := make([]string, 0, len(paths))
+	for _, path := range paths {
+		cleaned = append(cleaned, filepath.Clean(path))
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for held := range l.held {
+		for _, path := range cleaned {
+			if underRoot(held, path) || underRoot(path, held) {
+				return nil, false
+			}
+		}
+	}
+
+	for _, path := range cleaned {
+		l.held[path] = struct{}{}
+	}
+
+	return func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		for _, path := range cleaned {
+			delete(l.held, path)
+		}
+	}, true
+}
+
+// Busy return true if any path is locked
+func (l *PathLocks) Busy() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.held) > 0
+}
+
+// DirLock is an exclusive advisory lock of directory by lock file
+// in its root
+type DirLock struct {
+	path string
+	file *os.File
+
+	// Stale owner of lock file which was not released (nil if none)
+	Stale *LockInfo
+}
+
+// LockDir take lock file of root. Return DirLocked with owner if
+// lock is held by other process, lock file left by dead process
+// is taken over
+func LockDir(root string) (l *DirLock, err error) {
+	var f *os.File
+	var locked, same bool
+
+	path := filepath.Join(root, DirLockName)
+	for range dirLockAttempts {
+		if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return l, err
+		}
+
+		if locked, err = tryLockFile(f); err != nil || !locked {
+			info, _ := readLockInfo(f)
+			_ = f.Close()
+
+			if err != nil {
+				return l, err
+			}
+			return l, fmt.Errorf("%w: %s: %s", DirLocked, root, info)
+		}
+
+		// file removed or replaced by previous owner after open
+		if same, err = sameFile(f, path); err != nil || !same {
+			_ = f.Close()
+			continue
+		}
+
+		l = &DirLock{path: path, file: f}
+		if info, iErr := readLockInfo(f); iErr == nil {
+			l.Stale = &info
+		}
+
+		if err = writeLockInfo(f); err != nil {
+			_ = l.Unlock()
+			return nil, err
+		}
+		return l, err
+	}
+
+	if err == nil {
+		err = fmt.Errorf("%w: %s: lock file replaced while locking", DirLocked, root)
+	}
+	return nil, err
+}
+
+// Unlock remove lock file and release lock
+func (l *DirLock) Unlock() error {
+	return releaseFile(l.file, l.path)
+}
+
+// LockRoots take lock files of roots. Roots without write access
+// (read-only sources) are not locked
+func LockRoots(roots []string, log *logrus.Logger) (unlock func(), err error) {
+	locks := make([]*DirLock, 0, len(roots))
+	unlock = func() {
+		for _, l := range locks {
+			if uErr := l.Unlock(); uErr != nil {
+				log.WithFields(logrus.Fields{"path": l.path, "error": uErr.Error()}).Warn("unlock failed")
+			}
+		}
+	}
+
+	for _, root := range roots {
+		var l *DirLock
+
+		if l, err = LockDir(root); err != nil {
+			if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+				log.WithFields(logrus.Fields{"root": root, "error": err.Error()}).Warn("root is not locked")
+				continue
+			}
+
+			unlock()
+			return nil, err
+		}
+
+		if l.Stale != nil {
+			log.WithFields(logrus.Fields{"root": root, "owner": l.Stale.String()}).Warn("stale lock taken over")
+		}
+		locks = append(locks, l)
+	}
+
+	return unlock, nil
+}
+
+// readLockInfo read owner of lock file
+func readLockInfo(f *os.File) (info LockInfo, err error) {
+	var buf []byte
+
+	if buf, err = io.ReadAll(io.NewSectionReader(f, 0, 1<<16)); err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(buf, &info)
+	return info, err
+}
+
+// writeLockInfo replace content of lock file by current process
+func writeLockInfo(f *os.File) (err error) {
+	var buf []byte
+
+	host, _ := os.Hostname()
+	if buf, err = json.Marshal(LockInfo{PID: os.Getpid(), Host: host, Since: time.Now()}); err != nil {
+		return err
+	}
+
+	if err = f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err = f.WriteAt(append(buf, '\n'), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// sameFile return true if path still refers to opened file
+func sameFile(f *os.File, path string) (bool, error) {
+	opened, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	current, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(opened, current), nil
+}
+
+// withoutLockFile return entries of dir without lock file if dir is
+// a synced root. Entries are not modified
+func withoutLockFile(entries []ScanEntry, dir string, root string) []ScanEntry {
+	if filepath.Clean(dir) != filepath.Clean(root) {
+		return entries
+	}
+
+	for i, e := range entries {
+		if e.Name == DirLockName && !e.IsDir {
+			return append(entries[:i:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,30 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// tryLockFile take lock if lock file has no owner or its process
+// is not running (no flock on this platform)
+func tryLockFile(f *os.File) (bool, error) {
+	info, err := readLockInfo(f)
+	if err != nil || info.PID == os.Getpid() {
+		return true, nil
+	}
+
+	if _, err = os.FindProcess(info.PID); err != nil {
+		return true, nil
+	}
+	return false, nil
+}
+
+// releaseFile close and remove lock file
+func releaseFile(f *os.File, path string) (err error) {
+	err = f.Close()
+	if rErr := os.Remove(path); err == nil {
+		err = rErr
+	}
+	return err
+}
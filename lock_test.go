@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathLocks(t *testing.T) {
+	locks := MakePathLocks()
+	require.False(t, locks.Busy())
+
+	release, ok := locks.TryLock("/srv/a", "/mnt/b")
+	require.True(t, ok)
+	require.True(t, locks.Busy())
+
+	tests := []struct {
+		name   string
+		paths  []string
+		wantOk bool
+	}{
+		{name: "test same path", paths: []string{"/srv/a", "/mnt/c"}},
+		{name: "test nested path", paths: []string{"/srv/a/docs", "/mnt/c"}},
+		{name: "test parent path", paths: []string{"/srv", "/mnt/c"}},
+		{name: "test unclean path", paths: []string{"/mnt/b/", "/mnt/c"}},
+		{name: "test other paths", paths: []string{"/srv/ab", "/mnt/c"}, wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				other, ok := locks.TryLock(tt.paths...)
+				require.Equal(t, tt.wantOk, ok)
+				if ok {
+					other()
+				}
+			},
+		)
+	}
+
+	release()
+	require.False(t, locks.Busy())
+
+	release, ok = locks.TryLock("/srv/a/docs")
+	require.True(t, ok)
+	release()
+}
+
+func TestLockDir(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, DirLockName)
+
+	l, err := LockDir(root)
+	require.NoError(t, err)
+	require.Nil(t, l.Stale)
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var info LockInfo
+	require.NoError(t, json.Unmarshal(buf, &info))
+	require.Equal(t, os.Getpid(), info.PID)
+
+	// lock is held by other open file
+	_, err = LockDir(root)
+	require.ErrorIs(t, err, DirLocked)
+	require.ErrorContains(t, err, "pid")
+
+	require.NoError(t, l.Unlock())
+	require.NoFileExists(t, path)
+
+	// lock file of dead process is taken over
+	stale, err := json.Marshal(LockInfo{PID: 999999, Host: "old", Since: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, stale, 0644))
+
+	l, err = LockDir(root)
+	require.NoError(t, err)
+	require.NotNil(t, l.Stale)
+	require.Equal(t, 999999, l.Stale.PID)
+	require.NoError(t, l.Unlock())
+}
+
+func TestLockRoots(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	held, err := LockDir(dst)
+	require.NoError(t, err)
+
+	// taken locks are released on failure
+	_, err = LockRoots([]string{src, dst}, logrus.New())
+	require.ErrorIs(t, err, DirLocked)
+	require.NoFileExists(t, filepath.Join(src, DirLockName))
+	require.NoError(t, held.Unlock())
+
+	unlock, err := LockRoots([]string{src, dst}, logrus.New())
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(src, DirLockName))
+	unlock()
+	require.NoFileExists(t, filepath.Join(dst, DirLockName))
+}
+
+func TestLockFileNotSynced(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeSyncFiles(t, src, dst)
+
+	unlock, err := LockRoots([]string{src, dst}, logrus.New())
+	require.NoError(t, err)
+	defer unlock()
+
+	srcMeta, dstMeta, err := HandlePaths(src, dst)
+	require.NoError(t, err)
+	require.Equal(t, 2, srcMeta.FilesCount())
+	require.Equal(t, 1, dstMeta.FilesCount())
+
+	entries := []ScanEntry{{Name: DirLockName}, {Name: "a.txt"}}
+	require.Equal(t, []ScanEntry{{Name: "a.txt"}}, withoutLockFile(entries, src, src))
+	require.Len(t, withoutLockFile(entries, filepath.Join(src, "docs"), src), 2)
+	require.Equal(t, DirLockName, entries[0].Name)
+}
+
+func TestRunOnce_locked(t *testing.T) {
+	var out, errOut bytes.Buffer
+
+	src, dst := t.TempDir(), t.TempDir()
+	writeSyncFiles(t, src, dst)
+
+	l, err := LockDir(dst)
+	require.NoError(t, err)
+	defer l.Unlock()
+
+	code := RunOnce(context.Background(), &out, &errOut, []string{"--src", src, "--dst", dst, "--max-diff", "100"})
+	require.Equal(t, ExitLocked, code)
+	require.NoFileExists(t, filepath.Join(dst, "a.txt"))
+}
+
+func TestServer_lockSync(t *testing.T) {
+	cfg := loadConfig(t, testConfig)
+	srv, err := MakeServer(cfg, logrus.New())
+	require.NoError(t, err)
+
+	src, dst := srv.boot.SrcPath, srv.boot.DstPath
+	unlock, err := srv.lockSync(src, dst)
+	require.NoError(t, err)
+
+	_, err = srv.lockSync(src, filepath.Join(dst, "docs"))
+	require.ErrorIs(t, err, SyncBusy)
+	unlock()
+
+	// directory locked by other process
+	l, err := LockDir(dst)
+	require.NoError(t, err)
+	_, err = srv.lockSync(src, dst)
+	require.ErrorIs(t, err, DirLocked)
+	require.False(t, srv.locks.Busy())
+	require.NoError(t, l.Unlock())
+}
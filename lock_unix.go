@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile take exclusive flock without waiting. Lock is released
+// by kernel if process dies
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseFile remove lock file while lock is held, so other process
+// never removes lock of new owner
+func releaseFile(f *os.File, path string) (err error) {
+	err = os.Remove(path)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
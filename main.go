@@ -1,19 +1,86 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 )
 
+// Version of application, set on build:
+// -ldflags "-X main.Version=<version>"
+var Version = "dev"
+
 func main() {
 	var cfg = new(ServerConfig)
 	var logger *logrus.Logger
 	var err error
 	var server *Server
 	var g errgroup.Group
+	var overrides []ConfigOverride
+
+	var configPath string
+	var printConfig bool
+	var flags OverrideFlags
+
+	flag.StringVar(
+		&configPath,
+		"config",
+		"",
+		"path of config file (default $"+EnvConfigPath+" or "+DefaultConfigName+")",
+	)
+	flag.BoolVar(&printConfig, "print-config", false, "print effective config and exit")
+	flag.Var(&flags, "set", "override config value, key=value by dotted yaml key (repeatable)")
+	flag.Usage = usage
+	flag.Parse()
+
+	command := flag.Arg(0)
 
-	if err = cfg.Load(); err != nil {
+	// client commands and one-shot sync parse own flags
+	if slices.Contains(ClientCommands, command) || command == CommandRun {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+		var code int
+		if command == CommandRun {
+			code = RunOnce(ctx, os.Stdout, os.Stderr, flag.Args()[1:])
+		} else {
+			code = RunClientCommand(ctx, os.Stdout, os.Stderr, command, flag.Args()[1:])
+		}
+
+		stop()
+		os.Exit(code)
+	}
+
+	// flags are allowed after command too
+	if command != "" {
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if configPath == "" {
+		configPath = cmp.Or(os.Getenv(EnvConfigPath), DefaultConfigName)
+	}
+
+	if overrides, err = EnvOverrides(os.Environ()); err == nil {
+		overrides = append(overrides, flags...)
+	}
+
+	switch {
+	case err != nil:
+	case command == CommandValidateConfig:
+		os.Exit(ValidateConfig(os.Stdout, configPath, overrides))
+	case command != "":
+		flag.Usage()
+		os.Exit(2)
+	default:
+		err = cfg.LoadFile(configPath, overrides...)
+	}
+
+	if err != nil {
 		logrus.WithFields(
 			logrus.Fields{
 				"stage": "load_config",
@@ -23,6 +90,13 @@ func main() {
 		).Fatal(err)
 	}
 
+	if printConfig {
+		if err = PrintConfig(os.Stdout, cfg); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
 	if logger, err = SetupLogger(cfg.LogLevel, cfg.TimeFormat); err != nil {
 		logrus.WithFields(
 			logrus.Fields{
@@ -33,9 +107,7 @@ func main() {
 		).Fatal(err)
 	}
 
-	// create sync operation lock (block)
-	block := new(Block)
-	if server, err = MakeServer(cfg, logger, block); err != nil {
+	if server, err = MakeServer(cfg, logger); err != nil {
 		logrus.WithFields(
 			logrus.Fields{
 				"stage": "setup_server",
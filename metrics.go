@@ -0,0 +1,309 @@
+// contains Prometheus metrics of sync jobs, worker pools and API
+// requests
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsPath of metrics on root router
+const metricsPath = "/metrics"
+
+// metricsNamespace prefix of all metric names
+const metricsNamespace = "fsyncd"
+
+// routeUnmatched route label of requests without registered route
+const routeUnmatched = "unmatched"
+
+// LatencyBuckets upper bounds (seconds) of item operations and API
+// requests latency
+var LatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// ScanBuckets upper bounds (seconds) of trees scan duration
+var ScanBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900}
+
+// metricPhases phases of worker pools, gauges of all phases are
+// collected even if no pool is running
+var metricPhases = []string{OpDeleteDir, OpDeleteFile, OpCreateDir, OpSyncFile}
+
+// Metrics collect counters of sync jobs, items, scans, worker pools
+// and API requests. Nil Metrics ignores observations
+type Metrics struct {
+	registry *prometheus.Registry
+
+	jobs        *prometheus.CounterVec
+	jobsRunning prometheus.Gauge
+	items       *prometheus.CounterVec
+	bytes       *prometheus.CounterVec
+	opDuration  *prometheus.HistogramVec
+	scans       prometheus.Histogram
+	scanFiles   *prometheus.GaugeVec
+	requests    *prometheus.CounterVec
+	reqDuration *prometheus.HistogramVec
+
+	pools *poolCollector
+
+	// handler write registry in exposition format
+	handler http.Handler
+}
+
+// MakeMetrics factory function return new Metrics
+func MakeMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		jobs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "jobs_total",
+				Help:      "Finished sync runs by kind and outcome.",
+			},
+			[]string{"kind", "outcome"},
+		),
+		jobsRunning: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "jobs_running",
+				Help:      "Running sync runs.",
+			},
+		),
+		items: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "items_total",
+				Help:      "Handled items by operation and result.",
+			},
+			[]string{"op", "result"},
+		),
+		bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "bytes_total",
+				Help:      "Bytes of copied and deleted files by operation.",
+			},
+			[]string{"op"},
+		),
+		opDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "operation_duration_seconds",
+				Help:      "Latency of single file copy or delete attempt.",
+				Buckets:   LatencyBuckets,
+			},
+			[]string{"op"},
+		),
+		scans: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "scan_duration_seconds",
+				Help:      "Duration of full scan of source and destination trees.",
+				Buckets:   ScanBuckets,
+			},
+		),
+		scanFiles: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "scan_files",
+				Help:      "Files count of tree found by last full scan.",
+			},
+			[]string{"tree"},
+		),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "http_requests_total",
+				Help:      "API requests by method, route and status code.",
+			},
+			[]string{"method", "route", "code"},
+		),
+		reqDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "http_request_duration_seconds",
+				Help:      "Latency of API requests by method and route.",
+				Buckets:   LatencyBuckets,
+			},
+			[]string{"method", "route"},
+		),
+		pools: makePoolCollector(),
+	}
+
+	m.registry.MustRegister(
+		m.jobs,
+		m.jobsRunning,
+		m.items,
+		m.bytes,
+		m.opDuration,
+		m.scans,
+		m.scanFiles,
+		m.requests,
+		m.reqDuration,
+		m.pools,
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// ObserveRun count finished sync run by its final state
+func (m *Metrics) ObserveRun(run SyncRun) {
+	if m == nil {
+		return
+	}
+
+	m.jobs.WithLabelValues(run.Kind, run.State).Inc()
+}
+
+// SetRunning save count of running sync runs
+func (m *Metrics) SetRunning(count int) {
+	if m == nil {
+		return
+	}
+
+	m.jobsRunning.Set(float64(count))
+}
+
+// ObserveItem count item operation outcome
+func (m *Metrics) ObserveItem(op string, err error) {
+	if m == nil {
+		return
+	}
+
+	result := JobStatusOk
+	if err != nil {
+		result = JobStatusFailed
+	}
+	m.items.WithLabelValues(op, result).Inc()
+}
+
+// AddBytes count bytes of copied or deleted files
+func (m *Metrics) AddBytes(op string, n int64) {
+	if m != nil && n > 0 {
+		m.bytes.WithLabelValues(op).Add(float64(n))
+	}
+}
+
+// ObserveOperation save latency of single copy or delete attempt
+func (m *Metrics) ObserveOperation(op string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.opDuration.WithLabelValues(op).Observe(latency.Seconds())
+}
+
+// ObserveScan save duration and size of full trees scan
+func (m *Metrics) ObserveScan(duration time.Duration, srcFiles int, dstFiles int) {
+	if m == nil {
+		return
+	}
+
+	m.scans.Observe(duration.Seconds())
+	m.scanFiles.WithLabelValues("src").Set(float64(srcFiles))
+	m.scanFiles.WithLabelValues("dst").Set(float64(dstFiles))
+}
+
+// TrackPool add pool into utilisation gauges of phase until
+// returned function is called
+func (m *Metrics) TrackPool(phase string, pool *WorkerPool) (untrack func()) {
+	if m == nil {
+		return func() {}
+	}
+
+	return m.pools.track(phase, pool)
+}
+
+// Handle is a gin middleware which count API requests
+func (m *Metrics) Handle(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	if m == nil {
+		return
+	}
+
+	route := c.FullPath()
+	if route == "" {
+		route = routeUnmatched
+	}
+
+	method := c.Request.Method
+	m.requests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	m.reqDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
+
+// Handler return http handler of all metrics in exposition format
+// negotiated with scraper
+func (m *Metrics) Handler() http.Handler {
+	return m.handler
+}
+
+// poolCollector collect utilisation of running worker pools by phase
+type poolCollector struct {
+	lock  *sync.Mutex
+	pools map[*WorkerPool]string
+
+	busy  *prometheus.Desc
+	limit *prometheus.Desc
+}
+
+func makePoolCollector() *poolCollector {
+	return &poolCollector{
+		lock:  new(sync.Mutex),
+		pools: make(map[*WorkerPool]string),
+		busy: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "workers_busy"),
+			"Running workers of sync phase.",
+			[]string{"phase"},
+			nil,
+		),
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "workers_limit"),
+			"Workers limit of sync phase.",
+			[]string{"phase"},
+			nil,
+		),
+	}
+}
+
+// track add pool of phase until returned function is called
+func (p *poolCollector) track(phase string, pool *WorkerPool) (untrack func()) {
+	p.lock.Lock()
+	p.pools[pool] = phase
+	p.lock.Unlock()
+
+	return func() {
+		p.lock.Lock()
+		delete(p.pools, pool)
+		p.lock.Unlock()
+	}
+}
+
+// Describe implements prometheus.Collector
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.busy
+	ch <- p.limit
+}
+
+// Collect implements prometheus.Collector
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	busy := make(map[string]int, len(metricPhases))
+	limit := make(map[string]int, len(metricPhases))
+
+	p.lock.Lock()
+	for pool, phase := range p.pools {
+		busy[phase] += pool.Running()
+		limit[phase] += pool.Limit()
+	}
+	p.lock.Unlock()
+
+	for _, phase := range metricPhases {
+		ch <- prometheus.MustNewConstMetric(p.busy, prometheus.GaugeValue, float64(busy[phase]), phase)
+		ch <- prometheus.MustNewConstMetric(p.limit, prometheus.GaugeValue, float64(limit[phase]), phase)
+	}
+}
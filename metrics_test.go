@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Handler(t *testing.T) {
+	m := MakeMetrics()
+	m.ObserveRun(SyncRun{Kind: RunKindSync, State: JobStatusOk})
+	m.ObserveRun(SyncRun{Kind: RunKindSync, State: JobStatusOk})
+	m.ObserveItem(OpSyncFile, nil)
+	m.ObserveItem(OpSyncFile, errors.New("broken"))
+	m.AddBytes(OpSyncFile, 1024)
+	m.AddBytes(OpDeleteFile, 0)
+	m.ObserveOperation(OpSyncFile, 3*time.Millisecond)
+	m.ObserveOperation(OpSyncFile, time.Minute)
+	m.ObserveScan(2*time.Second, 10, 7)
+
+	out := metricsText(t, m)
+
+	for _, line := range []string{
+		"# TYPE fsyncd_jobs_total counter",
+		`fsyncd_jobs_total{kind="sync",outcome="ok"} 2`,
+		`fsyncd_items_total{op="sync_file",result="failed"} 1`,
+		`fsyncd_items_total{op="sync_file",result="ok"} 1`,
+		`fsyncd_bytes_total{op="sync_file"} 1024`,
+		"# TYPE fsyncd_operation_duration_seconds histogram",
+		`fsyncd_operation_duration_seconds_bucket{op="sync_file",le="0.001"} 0`,
+		`fsyncd_operation_duration_seconds_bucket{op="sync_file",le="0.005"} 1`,
+		`fsyncd_operation_duration_seconds_bucket{op="sync_file",le="30"} 1`,
+		`fsyncd_operation_duration_seconds_bucket{op="sync_file",le="+Inf"} 2`,
+		`fsyncd_operation_duration_seconds_sum{op="sync_file"} 60.003`,
+		`fsyncd_operation_duration_seconds_count{op="sync_file"} 2`,
+		`fsyncd_scan_duration_seconds_bucket{le="5"} 1`,
+		`fsyncd_scan_files{tree="src"} 10`,
+		`fsyncd_workers_busy{phase="sync_file"} 0`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	require.NotContains(t, out, `op="delete_file"`)
+}
+
+func TestMetrics_TrackPool(t *testing.T) {
+	m := MakeMetrics()
+	pool := MakeWorkerPool(3)
+	require.NoError(t, pool.Acquire(context.Background()))
+
+	untrack := m.TrackPool(OpCreateDir, pool)
+	out := metricsText(t, m)
+	require.Contains(t, out, `fsyncd_workers_busy{phase="create_dir"} 1`+"\n")
+	require.Contains(t, out, `fsyncd_workers_limit{phase="create_dir"} 3`+"\n")
+
+	untrack()
+	out = metricsText(t, m)
+	require.Contains(t, out, `fsyncd_workers_busy{phase="create_dir"} 0`+"\n")
+	require.Contains(t, out, `fsyncd_workers_limit{phase="create_dir"} 0`+"\n")
+
+	// nil metrics ignore observations
+	var nilMetrics *Metrics
+	nilMetrics.ObserveItem(OpSyncFile, nil)
+	nilMetrics.TrackPool(OpSyncFile, pool)()
+}
+
+func TestServer_GetMetrics(t *testing.T) {
+	addr, srv := testDaemon(t)
+	ctx := context.Background()
+
+	src, dst := srv.boot.SrcPath, srv.boot.DstPath
+	writeSyncFiles(t, src, dst)
+
+	cl, err := MakeClient(addr, "secret-token", "", false)
+	require.NoError(t, err)
+
+	_, err = cl.Sync(ctx, SyncDirectoriesRequest{SrcPath: src, DstPath: dst, MaxDiffPercent: 100, Mode: ModeFull})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, addr+metricsPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret-token")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	buf, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	out := string(buf)
+
+	for _, line := range []string{
+		`fsyncd_jobs_total{kind="sync",outcome="ok"} 1`,
+		`fsyncd_jobs_running 0`,
+		`fsyncd_bytes_total{op="sync_file"}`,
+		`fsyncd_scan_files{tree="src"}`,
+		`fsyncd_scan_duration_seconds_count 1`,
+		`fsyncd_http_requests_total{code="200",method="PATCH",route="/api/v1/sync/directories"} 1`,
+	} {
+		require.Contains(t, out, line)
+	}
+}
+
+// metricsText return metrics in text format
+func metricsText(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func TestServer_metricsGate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		token  string
+		want   int
+	}{
+		{name: "test disabled", config: "metrics_enabled: false", token: "secret-token", want: http.StatusNotFound},
+		{name: "test no credentials", config: "metrics_enabled: true", want: http.StatusUnauthorized},
+		{name: "test read role", config: "metrics_enabled: true", token: "secret-token", want: http.StatusOK},
+		{name: "test public", config: "metrics_enabled: true\nmetrics_public: true", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg := loadConfig(t, strings.Replace(testConfig, "metrics_enabled: true", tt.config, 1))
+				srv, err := MakeServer(cfg, logrus.New())
+				require.NoError(t, err)
+				require.NoError(t, srv.setup())
+
+				r := httptest.NewRequest(http.MethodGet, metricsPath, nil)
+				if tt.token != "" {
+					r.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+
+				w := httptest.NewRecorder()
+				srv.g.ServeHTTP(w, r)
+				require.Equal(t, tt.want, w.Code)
+
+				// metrics are not served under API prefix
+				w = httptest.NewRecorder()
+				srv.g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+metricsPath, nil))
+				require.NotEqual(t, http.StatusOK, w.Code)
+			},
+		)
+	}
+}
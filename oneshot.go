@@ -0,0 +1,270 @@
+// contains one-shot local sync without HTTP server
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CommandRun sync directories once in current process
+const CommandRun = "run"
+
+// exit codes of one-shot sync
+const (
+	ExitOk                 = 0
+	ExitFatal              = 1
+	ExitUsage              = 2
+	ExitTooLargeDifference = 3
+	ExitPartialFailure     = 4
+	ExitLocked             = 5
+)
+
+// RunSummary is an outcome of one-shot sync
+type RunSummary struct {
+	SrcPath string `json:"src_path"`
+	DstPath string `json:"dst_path"`
+
+	// planned operations
+	CreateDirs  int `json:"create_dirs"`
+	DeleteDirs  int `json:"delete_dirs"`
+	DeleteFiles int `json:"delete_files"`
+	SyncFiles   int `json:"sync_files"`
+
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Retried   int          `json:"retried"`
+	Items     []ItemResult `json:"items,omitempty"`
+
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// patternFlags collect repeated glob pattern flags
+type patternFlags []string
+
+func (f *patternFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *patternFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// runOptions are flags of one-shot sync
+type runOptions struct {
+	src, dst    string
+	maxDiff     int
+	filter      PathFilter
+	dest        DestinationOptions
+	workers     int
+	attempts    int
+	bytesPerSec int64
+	dryRun      bool
+	logLevel    string
+	output      string
+}
+
+// RunOnce sync directories by args without HTTP server. Write summary
+// to w and logs with errors to errW. Return exit code: ExitOk,
+// ExitTooLargeDifference, ExitPartialFailure, ExitLocked, ExitFatal
+// or ExitUsage
+func RunOnce(ctx context.Context, w io.Writer, errW io.Writer, args []string) int {
+	var opts runOptions
+	var summary RunSummary
+	var err error
+
+	fs := flag.NewFlagSet(CommandRun, flag.ContinueOnError)
+	fs.SetOutput(errW)
+	fs.StringVar(&opts.src, "src", "", "source directory")
+	fs.StringVar(&opts.dst, "dst", "", "destination directory")
+	fs.IntVar(&opts.maxDiff, "max-diff", 0, "max difference of directories, percent (1-100)")
+	fs.Var((*patternFlags)(&opts.filter.Include), "include", "glob pattern of synced entries (repeatable)")
+	fs.Var((*patternFlags)(&opts.filter.Exclude), "exclude", "glob pattern of skipped entries (repeatable)")
+	fs.BoolVar(&opts.dest.NoDelete, "no-delete", false, "keep entries which not exist in source")
+	fs.BoolVar(&opts.dest.OneWay, "one-way", false, "source always wins")
+	fs.IntVar(&opts.workers, "workers", 0, "workers count of each phase (default by CPU count)")
+	fs.IntVar(&opts.attempts, "retry-attempts", DefaultRetryAttempts, "attempts of each item")
+	fs.Int64Var(&opts.bytesPerSec, "bytes-per-sec", 0, "copy rate limit (0 - unlimited)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print planned operations without sync")
+	fs.StringVar(&opts.logLevel, "log-level", WarnLevel, "log level of sync")
+	fs.StringVar(&opts.output, "output", OutputTable, "output format: table or json")
+	fs.StringVar(&opts.output, "o", OutputTable, "shorthand for --output")
+
+	if _, err = parseArgs(fs, args); err != nil {
+		return ExitUsage
+	}
+
+	switch {
+	case opts.src == "" || opts.dst == "":
+		err = fmt.Errorf("%w: --src and --dst are required", MissingArgument)
+	case opts.maxDiff < 1 || opts.maxDiff > 100:
+		err = fmt.Errorf("%w: --max-diff must be in range 1-100", MissingArgument)
+	case opts.output != OutputTable && opts.output != OutputJSON:
+		err = fmt.Errorf("unknown output format %q", opts.output)
+	}
+
+	if err == nil {
+		err = opts.filter.Validate()
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintln(errW, err)
+		fs.Usage()
+		return ExitUsage
+	}
+
+	summary = runOnce(ctx, errW, opts)
+	if summary.Error != "" {
+		_, _ = fmt.Fprintln(errW, summary.Error)
+	}
+
+	out := output{w: w, json: opts.output == OutputJSON}
+	if err = out.print(summary, summary.table); err != nil {
+		_, _ = fmt.Fprintln(errW, err)
+		return ExitFatal
+	}
+	return summary.ExitCode
+}
+
+// runOnce scan, prepare and sync directories
+func runOnce(ctx context.Context, errW io.Writer, opts runOptions) (summary RunSummary) {
+	var log *logrus.Logger
+	var srcMeta, dstMeta SyncMeta
+	var policy RetryPolicy
+	var throttle *Throttle
+	var res *SyncResult
+	var err error
+
+	started := time.Now()
+	defer func() {
+		summary.Duration = time.Since(started).Round(time.Millisecond).String()
+		summary.ExitCode = exitCode(res, err)
+		if err != nil {
+			summary.Error = err.Error()
+		}
+	}()
+
+	if summary.SrcPath, err = canonicalPath(opts.src); err != nil {
+		return summary
+	}
+
+	if summary.DstPath, err = canonicalPath(opts.dst); err != nil {
+		return summary
+	}
+
+	if err = CheckNested(summary.SrcPath, summary.DstPath); err != nil {
+		return summary
+	}
+
+	if log, err = SetupLogger(opts.logLevel, time.RFC3339); err != nil {
+		return summary
+	}
+	log.SetOutput(errW)
+
+	// other fsyncd instances must not sync same directories
+	if !opts.dryRun {
+		var unlock func()
+		if unlock, err = LockRoots([]string{summary.SrcPath, summary.DstPath}, log); err != nil {
+			return summary
+		}
+		defer unlock()
+	}
+
+	if srcMeta, dstMeta, err = HandlePaths(summary.SrcPath, summary.DstPath); err != nil {
+		err = fmt.Errorf("%w: %w", ScanFailed, err)
+		return summary
+	}
+
+	cmd := MakeSyncCommand(opts.maxDiff)
+	if err = cmd.Prepare(srcMeta, dstMeta); err != nil {
+		return summary
+	}
+	applyOptions(&cmd, summary.SrcPath, summary.DstPath, &opts.filter, &opts.dest)
+
+	plan := cmd.Plan()
+	summary.CreateDirs, summary.DeleteDirs = len(plan.CreateDirs), len(plan.DeleteDirs)
+	summary.DeleteFiles, summary.SyncFiles = len(plan.DeleteFiles), len(plan.SyncFiles)
+	if opts.dryRun {
+		return summary
+	}
+
+	if policy, err = MakeRetryPolicy(opts.attempts, 0, 0, nil); err != nil {
+		return summary
+	}
+
+	if throttle, err = MakeThrottle(ThrottleLimits{BytesPerSec: opts.bytesPerSec}, nil); err != nil {
+		return summary
+	}
+
+	synchronizer := Synchronizer{
+		SrcDiffPercent: opts.maxDiff,
+		SrcPath:        summary.SrcPath,
+		DstPath:        summary.DstPath,
+		Retry:          policy,
+		Throttle:       throttle,
+		Concurrency: Concurrency{
+			DeleteDirs:  opts.workers,
+			DeleteFiles: opts.workers,
+			CreateDirs:  opts.workers,
+			SyncFiles:   opts.workers,
+		},
+	}
+
+	res, err = synchronizer.Sync(ctx, cmd, log)
+	if res != nil {
+		summary.Succeeded, summary.Failed, summary.Retried = res.Succeeded, res.Failed, res.Retried
+		summary.Items = res.Items
+	}
+	return summary
+}
+
+// exitCode return exit code of sync outcome. Sync with failed items
+// is a partial failure, other errors are fatal
+func exitCode(res *SyncResult, err error) int {
+	switch {
+	case err == nil:
+		return ExitOk
+	case errors.Is(err, TooLargeDifferenceErr):
+		return ExitTooLargeDifference
+	case errors.Is(err, DirLocked):
+		return ExitLocked
+	case res != nil && res.Failed > 0:
+		return ExitPartialFailure
+	default:
+		return ExitFatal
+	}
+}
+
+// table write summary as table
+func (s RunSummary) table(tw *tabwriter.Writer) {
+	_, _ = fmt.Fprintf(tw, "src\t%s\ndst\t%s\n", s.SrcPath, s.DstPath)
+	_, _ = fmt.Fprintf(
+		tw,
+		"planned\tcreate_dirs=%d delete_dirs=%d delete_files=%d sync_files=%d\n",
+		s.CreateDirs,
+		s.DeleteDirs,
+		s.DeleteFiles,
+		s.SyncFiles,
+	)
+	_, _ = fmt.Fprintf(tw, "succeeded\t%d\nfailed\t%d\nretried\t%d\n", s.Succeeded, s.Failed, s.Retried)
+	_, _ = fmt.Fprintf(tw, "duration\t%s\nexit code\t%d\n", s.Duration, s.ExitCode)
+
+	if len(s.Items) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintln(tw, "\nOP\tPATH\tATTEMPTS\tERROR")
+	for _, item := range s.Items {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", item.Op, item.Path, item.Attempts, item.Error)
+	}
+}
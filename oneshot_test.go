@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantFile bool
+	}{
+		{name: "test sync", args: []string{"--max-diff", "100"}, wantFile: true},
+		{name: "test dry run", args: []string{"--max-diff", "100", "--dry-run"}},
+		{name: "test too large difference", args: []string{"--max-diff", "10"}, wantCode: ExitTooLargeDifference},
+		{name: "test missing max diff", wantCode: ExitUsage},
+		{name: "test bad pattern", args: []string{"--max-diff", "100", "--include", "["}, wantCode: ExitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var out, errOut bytes.Buffer
+				var summary RunSummary
+
+				src, dst := t.TempDir(), t.TempDir()
+				writeSyncFiles(t, src, dst)
+
+				args := append([]string{"--src", src, "--dst", dst, "-o", "json"}, tt.args...)
+				code := RunOnce(context.Background(), &out, &errOut, args)
+				require.Equal(t, tt.wantCode, code, errOut.String())
+
+				if tt.wantFile {
+					require.FileExists(t, filepath.Join(dst, "a.txt"))
+				} else {
+					require.NoFileExists(t, filepath.Join(dst, "a.txt"))
+				}
+
+				if code == ExitUsage {
+					return
+				}
+				require.NoError(t, json.Unmarshal(out.Bytes(), &summary))
+				require.Equal(t, code, summary.ExitCode)
+				if code == ExitOk {
+					require.NotZero(t, summary.SyncFiles)
+				}
+			},
+		)
+	}
+}
+
+func TestRunOnce_missingSource(t *testing.T) {
+	var out, errOut bytes.Buffer
+
+	src := filepath.Join(t.TempDir(), "missing")
+	code := RunOnce(context.Background(), &out, &errOut, []string{"--src", src, "--dst", t.TempDir(), "--max-diff", "50"})
+	require.Equal(t, ExitFatal, code)
+	require.Contains(t, errOut.String(), "missing")
+	require.Contains(t, out.String(), "exit code")
+
+	_, err := os.Stat(src)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExitCode(t *testing.T) {
+	failed := errors.New("copy failed")
+	tests := []struct {
+		name string
+		res  *SyncResult
+		err  error
+		want int
+	}{
+		{name: "test ok", res: &SyncResult{Succeeded: 1}, want: ExitOk},
+		{name: "test too large difference", err: TooLargeDifferenceErr, want: ExitTooLargeDifference},
+		{name: "test partial failure", res: &SyncResult{Succeeded: 1, Failed: 1}, err: failed, want: ExitPartialFailure},
+		{name: "test fatal", res: &SyncResult{}, err: failed, want: ExitFatal},
+		{name: "test fatal without result", err: failed, want: ExitFatal},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, exitCode(tt.res, tt.err))
+			},
+		)
+	}
+}
@@ -0,0 +1,487 @@
+// contains OpenAPI document of registered routes and Swagger UI
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// OpenAPIVersion of generated document
+const OpenAPIVersion = "3.1.0"
+
+// apiPrefix of all API routes
+const apiPrefix = "/api/v1"
+
+// apiRoute is a registered API route with its documentation
+type apiRoute struct {
+	Method  string
+	Path    string
+	Role    string
+	Tag     string
+	Summary string
+	Handler gin.HandlerFunc
+
+	// Request and Response are examples of body types (nil - no body)
+	Request  any
+	Response any
+
+	// Statuses other than 200
+	Statuses []int
+}
+
+// routes return all API routes (paths are relative to apiPrefix)
+func (srv *Server) routes() []apiRoute {
+	return []apiRoute{
+		{
+			Method:   http.MethodPatch,
+			Path:     "/sync/directories",
+			Role:     RoleSync,
+			Tag:      "sync",
+			Summary:  "Sync source directory into destination",
+			Handler:  srv.HandleSyncCommand,
+			Request:  SyncDirectoriesRequest{},
+			Response: SyncResult{},
+			Statuses: []int{400, 401, 403, 409, 422, 500},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/sync/plan",
+			Role:     RoleSync,
+			Tag:      "sync",
+			Summary:  "Return sync operations without execution (dry run)",
+			Handler:  srv.HandleSyncPlan,
+			Request:  SyncDirectoriesRequest{},
+			Response: SyncPlan{},
+			Statuses: []int{400, 401, 403, 422, 500},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/jobs",
+			Role:     RoleRead,
+			Tag:      "jobs",
+			Summary:  "Get running and last finished sync runs",
+			Handler:  srv.GetRuns,
+			Response: []SyncRun{},
+			Statuses: []int{401, 403},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/jobs/:id",
+			Role:     RoleRead,
+			Tag:      "jobs",
+			Summary:  "Get sync run",
+			Handler:  srv.GetRun,
+			Response: SyncRun{},
+			Statuses: []int{401, 403, 404},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/jobs/:id/cancel",
+			Role:     RoleSync,
+			Tag:      "jobs",
+			Summary:  "Cancel running sync run",
+			Handler:  srv.CancelRun,
+			Response: SyncRun{},
+			Statuses: []int{401, 403, 404, 409},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/status",
+			Role:     RoleRead,
+			Tag:      "server",
+			Summary:  "Get state of daemon",
+			Handler:  srv.GetStatus,
+			Response: StatusResponse{},
+			Statuses: []int{401, 403, 500},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/sync/fanout",
+			Role:     RoleSync,
+			Tag:      "sync",
+			Summary:  "Sync one source into many destinations",
+			Handler:  srv.HandleFanOutSync,
+			Request:  SyncFanOutRequest{},
+			Response: FanOutResult{},
+			Statuses: []int{207, 400, 401, 403, 409, 500},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/sync/limits",
+			Role:     RoleRead,
+			Tag:      "limits",
+			Summary:  "Get configured and applied rate limits",
+			Handler:  srv.GetSyncLimits,
+			Response: SyncLimitsResponse{},
+			Statuses: []int{401, 403},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/sync/limits",
+			Role:     RoleAdmin,
+			Tag:      "limits",
+			Summary:  "Update rate limits at runtime",
+			Handler:  srv.UpdateSyncLimits,
+			Request:  UpdateSyncLimitsRequest{},
+			Response: SyncLimitsResponse{},
+			Statuses: []int{400, 401, 403},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/profiles",
+			Role:     RoleRead,
+			Tag:      "profiles",
+			Summary:  "List configured sync profiles",
+			Handler:  srv.GetProfiles,
+			Response: map[string]SyncProfile{},
+			Statuses: []int{401, 403},
+		},
+		{
+			Method:   http.MethodPost,
+			Path:     "/profiles/:name/sync",
+			Role:     RoleSync,
+			Tag:      "profiles",
+			Summary:  "Run sync of named profile",
+			Handler:  srv.HandleProfileSync,
+			Response: SyncResult{},
+			Statuses: []int{400, 401, 403, 404, 409, 422, 500},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/schedules",
+			Role:     RoleRead,
+			Tag:      "schedules",
+			Summary:  "Get state of scheduled sync jobs",
+			Handler:  srv.GetSchedules,
+			Response: []JobSchedule{},
+			Statuses: []int{401, 403},
+		},
+		{
+			Method:   http.MethodPatch,
+			Path:     "/server/config/update",
+			Role:     RoleAdmin,
+			Tag:      "config",
+			Summary:  "Update server configuration",
+			Handler:  srv.UpdateConfiguration,
+			Request:  map[string]any{},
+			Response: ConfigUpdateResponse{},
+			Statuses: []int{400, 401, 403, 412, 500},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/server/config",
+			Role:     RoleRead,
+			Tag:      "config",
+			Summary:  "Get actual server configuration",
+			Handler:  srv.GetCurrentConfig,
+			Response: ConfigResponse{},
+			Statuses: []int{401, 403, 500},
+		},
+		{
+			Method:   http.MethodGet,
+			Path:     "/openapi.json",
+			Role:     RoleRead,
+			Tag:      "docs",
+			Summary:  "Get OpenAPI document",
+			Handler:  srv.GetOpenAPI,
+			Response: map[string]any{},
+			Statuses: []int{401, 403},
+		},
+	}
+}
+
+// BuildOpenAPI return OpenAPI document of routes. Schemas are built
+// from Go types of request and response bodies
+func BuildOpenAPI(routes []apiRoute, serverURL string) map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+
+	for _, r := range routes {
+		path, params := openAPIPath(apiPrefix + r.Path)
+
+		op := map[string]any{
+			"summary":     r.Summary,
+			"operationId": operationID(r.Method, r.Path),
+			"tags":        []string{r.Tag},
+			"description": fmt.Sprintf("Required role: %s", r.Role),
+			"responses":   openAPIResponses(r, schemas),
+		}
+
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if r.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": schemaOf(reflect.TypeOf(r.Request), schemas),
+					},
+				},
+			}
+		}
+
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+
+	doc := map[string]any{
+		"openapi": OpenAPIVersion,
+		"info": map[string]any{
+			"title":       "fsyncd API",
+			"description": "Directories synchronization daemon",
+			"version":     Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": APIKeyHeader,
+				},
+				"hmac": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "Authorization",
+					"description": fmt.Sprintf(
+						"%s KeyId=<id>, Signature=<hex> with %s header",
+						HMACScheme,
+						HMACTimestampHeader,
+					),
+				},
+				"mutualTLS": map[string]any{"type": "mutualTLS"},
+			},
+		},
+		"security": []map[string][]string{
+			{"bearerAuth": {}},
+			{"apiKey": {}},
+			{"hmac": {}},
+			{"mutualTLS": {}},
+		},
+	}
+
+	if serverURL != "" {
+		doc["servers"] = []map[string]string{{"url": serverURL}}
+	}
+
+	return doc
+}
+
+// openAPIPath convert gin path params (:name) into OpenAPI ({name})
+func openAPIPath(path string) (string, []map[string]any) {
+	var params []map[string]any
+
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") {
+			continue
+		}
+
+		name := part[1:]
+		parts[i] = "{" + name + "}"
+		params = append(
+			params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			},
+		)
+	}
+
+	return strings.Join(parts, "/"), params
+}
+
+// operationID return camel case id: patchSyncDirectories
+func operationID(method string, path string) string {
+	var b strings.Builder
+
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '.' || r == ':' || r == '_'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func openAPIResponses(r apiRoute, schemas map[string]any) map[string]any {
+	ok := map[string]any{"description": http.StatusText(http.StatusOK)}
+	if r.Response != nil {
+		ok["content"] = map[string]any{
+			"application/json": map[string]any{
+				"schema": schemaOf(reflect.TypeOf(r.Response), schemas),
+			},
+		}
+	}
+
+	responses := map[string]any{"200": ok}
+	for _, status := range r.Statuses {
+		resp := map[string]any{"description": http.StatusText(status)}
+
+		// partial results have same body
+		if status == http.StatusMultiStatus || (status == 500 && r.Response != nil) {
+			resp["content"] = ok["content"]
+		}
+		responses[fmt.Sprint(status)] = resp
+	}
+	return responses
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf return JSON schema of type. Structs are added into
+// schemas and referenced by name
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), schemas),
+		}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// reserve name for recursive types
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]any{}
+}
+
+// structSchema return object schema of exported fields with json names
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := make(map[string]any)
+
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+			// embedded structs are flattened by encoding/json
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				collect(f.Type)
+				continue
+			}
+
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type, schemas)
+		}
+	}
+	collect(t)
+
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// GetOpenAPI return OpenAPI document of API
+func (srv *Server) GetOpenAPI(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, BuildOpenAPI(srv.routes(), ""))
+}
+
+// swaggerUI page load Swagger UI assets embedded into binary
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>fsyncd API</title>
+  <link rel="stylesheet" href="assets/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="assets/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// swaggerHandler serve Swagger UI and OpenAPI document. Server url
+// of document point to API port on the same host as UI
+func (srv *Server) swaggerHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(
+		"GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+			doc, err := json.MarshalIndent(BuildOpenAPI(srv.routes(), srv.apiURL(r)), "", "    ")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write(doc)
+		},
+	)
+
+	// Swagger UI assets of pinned swagger-ui-dist version
+	mux.Handle("GET /assets/", http.StripPrefix("/assets/", http.FileServerFS(swaggerFiles.FS)))
+
+	mux.HandleFunc(
+		"GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(swaggerUI))
+		},
+	)
+
+	return mux
+}
+
+// apiURL return API url on host of request
+func (srv *Server) apiURL(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+
+	scheme := "http"
+	if srv.boot.TLS.Enabled {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, srv.boot.Port))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildOpenAPI(t *testing.T) {
+	srv, err := MakeServer(&ServerConfig{Port: "6767"}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, srv.setup())
+
+	doc := BuildOpenAPI(srv.routes(), "")
+	paths := doc["paths"].(map[string]any)
+
+	// every registered route is documented
+	for _, r := range srv.g.Routes() {
+		path, _ := openAPIPath(r.Path)
+		item, ok := paths[path].(map[string]any)
+		require.True(t, ok, r.Path)
+		require.Contains(t, item, strings.ToLower(r.Method), r.Path)
+	}
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	req := schemas["SyncDirectoriesRequest"].(map[string]any)["properties"].(map[string]any)
+	require.Contains(t, req, "src_path")
+	require.Contains(t, req, "filter")
+	require.Contains(t, schemas, "PathFilter")
+
+	// embedded limits are flattened
+	window := schemas["ThrottleWindow"].(map[string]any)["properties"].(map[string]any)
+	require.Contains(t, window, "bytes_per_sec")
+
+	params := paths["/api/v1/profiles/{name}/sync"].(map[string]any)["post"].(map[string]any)["parameters"]
+	require.Len(t, params, 1)
+}
+
+func TestServer_swaggerHandler(t *testing.T) {
+	srv, err := MakeServer(&ServerConfig{Port: "6767"}, logrus.New())
+	require.NoError(t, err)
+
+	h := srv.swaggerHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://docs.local:6768/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "swagger-ui")
+	require.NotContains(t, w.Body.String(), "https://")
+
+	// assets are served from binary
+	for _, asset := range []string{"swagger-ui.css", "swagger-ui-bundle.js"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://docs.local:6768/assets/"+asset, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotZero(t, w.Body.Len())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://docs.local:6768/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc struct {
+		OpenAPI string              `json:"openapi"`
+		Servers []map[string]string `json:"servers"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, OpenAPIVersion, doc.OpenAPI)
+	require.Equal(t, "http://docs.local:6767", doc.Servers[0]["url"])
+}
@@ -0,0 +1,217 @@
+// contains overrides of config values by environment and flags
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix of environment variables which override config values,
+// FSYNCD_<KEY> where key is upper-cased yaml key with '_' instead
+// of '.' (FSYNCD_AUTH_ENABLED)
+const EnvPrefix = "FSYNCD_"
+
+// EnvConfigPath environment variable with path of config file
+const EnvConfigPath = EnvPrefix + "CONFIG"
+
+// nonConfigEnv are FSYNCD_* variables which are not config values
+var nonConfigEnv = []string{EnvConfigPath, EnvClientAddr, EnvClientToken}
+
+var UnknownConfigKey = fmt.Errorf("unknown config key")
+
+// ConfigOverride replace config value by dotted yaml key. Value is
+// parsed as yaml (lists, maps, durations), except string fields
+type ConfigOverride struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// EnvOverrides return overrides from FSYNCD_* variables of environ
+// (KEY=value list)
+func EnvOverrides(environ []string) (overrides []ConfigOverride, err error) {
+	names := make(map[string]string)
+	for key := range configKeys() {
+		names[EnvPrefix+strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = key
+	}
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || slices.Contains(nonConfigEnv, name) {
+			continue
+		}
+
+		key, ok := names[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", UnknownConfigKey, name)
+		}
+		overrides = append(overrides, ConfigOverride{Key: key, Value: value, Source: SourceEnv})
+	}
+
+	// stable order for same environment
+	slices.SortFunc(
+		overrides, func(a, b ConfigOverride) int {
+			return strings.Compare(a.Key, b.Key)
+		},
+	)
+	return overrides, err
+}
+
+// ParseOverride parse key=value flag override
+func ParseOverride(s string) (o ConfigOverride, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return o, fmt.Errorf("expected key=value, got %q", s)
+	}
+
+	if _, known := configKeys()[key]; !known {
+		return o, fmt.Errorf("%w: %s", UnknownConfigKey, key)
+	}
+
+	return ConfigOverride{Key: key, Value: value, Source: SourceFlag}, err
+}
+
+// OverrideFlags collect repeated --set flags
+type OverrideFlags []ConfigOverride
+
+func (f *OverrideFlags) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, o := range *f {
+		parts = append(parts, o.Key+"="+o.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *OverrideFlags) Set(s string) error {
+	o, err := ParseOverride(s)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, o)
+	return nil
+}
+
+// apply set override value in raw config map
+func (o ConfigOverride) apply(raw map[string]any) (err error) {
+	var value any = o.Value
+
+	t, ok := configKeys()[o.Key]
+	if !ok {
+		return fmt.Errorf("%w: %s", UnknownConfigKey, o.Key)
+	}
+
+	if t.Kind() != reflect.String {
+		if err = yaml.Unmarshal([]byte(o.Value), &value); err != nil {
+			return fmt.Errorf("%s (%s): %w", o.Key, o.Source, err)
+		}
+	}
+
+	parts := strings.Split(o.Key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, isMap := raw[part].(map[string]any)
+		if !isMap {
+			nested = make(map[string]any)
+			raw[part] = nested
+		}
+		raw = nested
+	}
+	raw[parts[len(parts)-1]] = value
+
+	return err
+}
+
+// configKeys return dotted yaml keys of ServerConfig fields and
+// nested structs with their types
+func configKeys() map[string]reflect.Type {
+	keys := make(map[string]reflect.Type)
+	collectKeys(reflect.TypeOf(ServerConfig{}), "", keys)
+	return keys
+}
+
+func collectKeys(t reflect.Type, prefix string, keys map[string]reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			collectKeys(f.Type, prefix, keys)
+			continue
+		}
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		keys[key] = f.Type
+
+		if f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			collectKeys(f.Type, key, keys)
+		}
+	}
+}
+
+// PrintConfig write effective config as yaml with redacted secrets,
+// source of each value is written as line comment
+func PrintConfig(w io.Writer, sc *ServerConfig) (err error) {
+	var view ConfigResponse
+	var doc yaml.Node
+
+	if view, err = sc.View(); err != nil {
+		return err
+	}
+
+	if err = doc.Encode(view.Config); err != nil {
+		return err
+	}
+	commentSources(&doc, "", view.Sources)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "# version: %s\n%s", view.Version, out.String())
+	return err
+}
+
+// commentSources set sources of leaf values as line comments
+func commentSources(node *yaml.Node, prefix string, sources map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		value := node.Content[i+1]
+		if src, ok := sources[key]; ok {
+			// comments of flow values are kept on value node only
+			if value.Kind == yaml.ScalarNode || len(value.Content) == 0 {
+				value.LineComment = src
+			} else {
+				node.Content[i].LineComment = src
+			}
+			continue
+		}
+		commentSources(value, key, sources)
+	}
+}
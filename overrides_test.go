@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		want    []ConfigOverride
+		wantErr error
+	}{
+		{
+			name:    "test nested keys",
+			environ: []string{"HOME=/root", "FSYNCD_TLS_CERT_FILE=/etc/cert.pem", "FSYNCD_PORT=7000"},
+			want: []ConfigOverride{
+				{Key: "port", Value: "7000", Source: SourceEnv},
+				{Key: "tls.cert_file", Value: "/etc/cert.pem", Source: SourceEnv},
+			},
+		},
+		{
+			name:    "test config path skipped",
+			environ: []string{"FSYNCD_CONFIG=/etc/fsync.yml"},
+		},
+		{
+			name:    "test unknown key",
+			environ: []string{"FSYNCD_PROT=7000"},
+			wantErr: UnknownConfigKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := EnvOverrides(tt.environ)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestServerConfig_LoadFileOverrides(t *testing.T) {
+	flagPort, err := ParseOverride("port=8000")
+	require.NoError(t, err)
+
+	_, err = ParseOverride("port")
+	require.Error(t, err)
+
+	overrides := []ConfigOverride{
+		flagPort,
+		{Key: "port", Value: "7000", Source: SourceEnv},
+		{Key: "log_level", Value: "debug", Source: SourceEnv},
+		{Key: "retry_errors", Value: "[EIO, EBUSY]", Source: SourceEnv},
+		{Key: "time_format", Value: "15:04: 05", Source: SourceEnv},
+	}
+
+	cfg := new(ServerConfig)
+	require.NoError(t, cfg.LoadFile(writeFile(t, testConfig), append(overrides, testPaths(t)...)...))
+
+	require.Equal(t, "8000", cfg.Port)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, []string{"EIO", "EBUSY"}, cfg.RetryErrors)
+	require.Equal(t, "15:04: 05", cfg.TimeFormat)
+
+	view, err := cfg.View()
+	require.NoError(t, err)
+	require.Equal(t, SourceFlag, view.Sources["port"])
+	require.Equal(t, SourceEnv, view.Sources["log_level"])
+	require.Equal(t, SourceFile, view.Sources["host"])
+
+	// overrides are applied again on reload
+	_, err = cfg.Reload()
+	require.NoError(t, err)
+	require.Equal(t, "8000", cfg.Snapshot().Port)
+
+	var out bytes.Buffer
+	require.NoError(t, PrintConfig(&out, cfg))
+	require.Contains(t, out.String(), `port: "8000" # flag`)
+	require.Contains(t, out.String(), "host: 127.0.0.1 # file")
+	require.Contains(t, out.String(), "token: '"+redactedValue+"'")
+}
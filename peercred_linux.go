@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials return credentials of process connected with Unix
+// socket (SO_PEERCRED)
+func peerCredentials(c net.Conn) (cred *PeerCred, err error) {
+	var raw syscall.RawConn
+	var ucred *syscall.Ucred
+	var sErr error
+
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return cred, NoPeerCredentials
+	}
+
+	if raw, err = uc.SyscallConn(); err != nil {
+		return cred, err
+	}
+
+	err = raw.Control(
+		func(fd uintptr) {
+			ucred, sErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		},
+	)
+	if err == nil {
+		err = sErr
+	}
+
+	if err != nil {
+		return cred, err
+	}
+	return &PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, err
+}
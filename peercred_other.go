@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCredentials is not supported, requests of Unix socket are
+// authenticated by other methods
+func peerCredentials(net.Conn) (*PeerCred, error) {
+	return nil, fmt.Errorf("%w: unsupported on %s", NoPeerCredentials, runtime.GOOS)
+}
@@ -0,0 +1,274 @@
+// contains worker pool with resizable limit and adaptive tuner
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultTunerWindow items count observed before pool limit change
+const DefaultTunerWindow = 32
+
+// tunerTolerance is a throughput change that is treated as noise
+const tunerTolerance = 0.05
+
+// Concurrency contains workers count for each sync phase. Zero
+// value means default pool size (see CalculatePoolSize)
+type Concurrency struct {
+	DeleteDirs  int `yaml:"delete_dirs" json:"delete_dirs" validate:"gte=0"`
+	DeleteFiles int `yaml:"delete_files" json:"delete_files" validate:"gte=0"`
+	CreateDirs  int `yaml:"create_dirs" json:"create_dirs" validate:"gte=0"`
+	SyncFiles   int `yaml:"sync_files" json:"sync_files" validate:"gte=0"`
+
+	// Adaptive ramp workers count up or down by observed
+	// throughput and latency (phase value used as start point)
+	Adaptive bool `yaml:"adaptive" json:"adaptive"`
+
+	// MaxWorkers upper bound for adaptive mode
+	MaxWorkers int `yaml:"max_workers" json:"max_workers" validate:"gte=0"`
+}
+
+// Merge return copy of c with non-zero values from o. Adaptive
+// mode can be only turned on
+func (c Concurrency) Merge(o Concurrency) Concurrency {
+	if o.DeleteDirs > 0 {
+		c.DeleteDirs = o.DeleteDirs
+	}
+
+	if o.DeleteFiles > 0 {
+		c.DeleteFiles = o.DeleteFiles
+	}
+
+	if o.CreateDirs > 0 {
+		c.CreateDirs = o.CreateDirs
+	}
+
+	if o.SyncFiles > 0 {
+		c.SyncFiles = o.SyncFiles
+	}
+
+	if o.MaxWorkers > 0 {
+		c.MaxWorkers = o.MaxWorkers
+	}
+
+	c.Adaptive = c.Adaptive || o.Adaptive
+	return c
+}
+
+// WorkerPool limits running workers count. Limit can be
+// changed while workers are running. Acquire have to be
+// called from single goroutine
+type WorkerPool struct {
+	lock    *sync.Mutex
+	limit   int
+	running int
+
+	// signal about released worker or changed limit
+	free chan struct{}
+
+	// rate limiters of idle workers
+	limiters []*RateLimiter
+}
+
+// MakeWorkerPool factory function return new WorkerPool
+func MakeWorkerPool(limit int) *WorkerPool {
+	return &WorkerPool{
+		lock:  new(sync.Mutex),
+		limit: max(limit, 1),
+		free:  make(chan struct{}, 1),
+	}
+}
+
+// Acquire block until worker is available or ctx is done
+func (p *WorkerPool) Acquire(ctx context.Context) error {
+	for {
+		p.lock.Lock()
+		if p.running < p.limit {
+			p.running++
+			p.lock.Unlock()
+			return nil
+		}
+		p.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.free:
+		}
+	}
+}
+
+// Release return worker into pool
+func (p *WorkerPool) Release() {
+	p.lock.Lock()
+	p.running--
+	p.lock.Unlock()
+
+	p.notify()
+}
+
+// Limit return current workers limit
+func (p *WorkerPool) Limit() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.limit
+}
+
+// Running return count of acquired workers
+func (p *WorkerPool) Running() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.running
+}
+
+// TakeLimiter return rate limiter of worker. Limiters are reused by
+// workers of pool, so worker limit is kept between items
+func (p *WorkerPool) TakeLimiter() (l *RateLimiter) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if n := len(p.limiters); n > 0 {
+		l, p.limiters = p.limiters[n-1], p.limiters[:n-1]
+		return l
+	}
+	return MakeRateLimiter(0)
+}
+
+// PutLimiter return limiter of finished worker
+func (p *WorkerPool) PutLimiter(l *RateLimiter) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.limiters = append(p.limiters, l)
+}
+
+// SetLimit change workers limit (at least one worker)
+func (p *WorkerPool) SetLimit(limit int) {
+	p.lock.Lock()
+	p.limit = max(limit, 1)
+	p.lock.Unlock()
+
+	p.notify()
+}
+
+func (p *WorkerPool) notify() {
+	select {
+	case p.free <- struct{}{}:
+	default:
+	}
+}
+
+// AdaptiveTuner change pool limit by observed throughput and latency.
+// After each window it compares throughput with previous window and
+// keep moving in the same direction while throughput grows. If
+// throughput falls or latency grows without throughput gain direction
+// will be reversed (hill climbing)
+type AdaptiveTuner struct {
+	lock *sync.Mutex
+	pool *WorkerPool
+
+	minWorkers int
+	maxWorkers int
+	window     int
+
+	// current window
+	items   int
+	bytes   int64
+	latency time.Duration
+	started time.Time
+
+	// previous window
+	prevThroughput float64
+	prevLatency    time.Duration
+	direction      int
+}
+
+// MakeAdaptiveTuner factory function return new AdaptiveTuner
+func MakeAdaptiveTuner(pool *WorkerPool, maxWorkers int) *AdaptiveTuner {
+	if maxWorkers < 1 {
+		maxWorkers = runtime.NumCPU() * 4
+	}
+
+	return &AdaptiveTuner{
+		lock:       new(sync.Mutex),
+		pool:       pool,
+		minWorkers: 1,
+		maxWorkers: max(maxWorkers, pool.Limit()),
+		window:     DefaultTunerWindow,
+		started:    time.Now(),
+		direction:  1,
+	}
+}
+
+// Observe save single item outcome. Bytes can be zero for
+// operations without data transfer (throughput counted in items)
+func (a *AdaptiveTuner) Observe(bytes int64, latency time.Duration) {
+	if a == nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.items++
+	a.bytes += bytes
+	a.latency += latency
+
+	if a.items < a.window {
+		return
+	}
+
+	elapsed := time.Since(a.started).Seconds()
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond.Seconds()
+	}
+
+	amount := float64(a.bytes)
+	if a.bytes == 0 {
+		amount = float64(a.items)
+	}
+
+	throughput := amount / elapsed
+	avgLatency := a.latency / time.Duration(a.items)
+
+	a.tune(throughput, avgLatency)
+
+	a.items, a.bytes, a.latency = 0, 0, 0
+	a.started = time.Now()
+}
+
+// tune change pool limit by one step
+func (a *AdaptiveTuner) tune(throughput float64, latency time.Duration) {
+	prev := a.prevThroughput
+	prevLatency := a.prevLatency
+	a.prevThroughput, a.prevLatency = throughput, latency
+
+	// first window - just move
+	if prev == 0 {
+		a.step()
+		return
+	}
+
+	switch {
+	case throughput > prev*(1+tunerTolerance):
+		// gain - keep direction
+	case throughput < prev*(1-tunerTolerance):
+		// loss - reverse direction
+		a.direction = -a.direction
+	case latency > prevLatency*2:
+		// no gain but latency grows - disk is saturated
+		a.direction = -1
+	default:
+		// plateau - hold
+		return
+	}
+
+	a.step()
+}
+
+func (a *AdaptiveTuner) step() {
+	limit := a.pool.Limit() + a.direction
+	limit = min(max(limit, a.minWorkers), a.maxWorkers)
+	a.pool.SetLimit(limit)
+}
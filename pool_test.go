@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestConcurrency_Merge(t *testing.T) {
+	cfg := Concurrency{DeleteDirs: 2, SyncFiles: 4}
+	req := Concurrency{SyncFiles: 32, Adaptive: true}
+
+	require.Equal(
+		t,
+		Concurrency{DeleteDirs: 2, SyncFiles: 32, Adaptive: true},
+		cfg.Merge(req),
+	)
+}
+
+func TestWorkerPool_SetLimit(t *testing.T) {
+	pool := MakeWorkerPool(1)
+	require.NoError(t, pool.Acquire(context.Background()))
+
+	// pool is full - acquire blocks until limit grows
+	done := make(chan error)
+	go func() {
+		done <- pool.Acquire(context.Background())
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("acquire not blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pool.SetLimit(2)
+	require.NoError(t, <-done)
+
+	// cancelled context interrupt acquire
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, pool.Acquire(ctx), context.Canceled)
+}
+
+func TestWorkerPool_TakeLimiter(t *testing.T) {
+	pool := MakeWorkerPool(2)
+
+	first := pool.TakeLimiter()
+	second := pool.TakeLimiter()
+	require.NotSame(t, first, second)
+
+	// limiter of idle worker is reused by next one
+	pool.PutLimiter(first)
+	require.Same(t, first, pool.TakeLimiter())
+}
+
+func TestAdaptiveTuner_tune(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev       float64
+		throughput float64
+		latency    time.Duration
+		wantLimit  int
+	}{
+		{
+			name:       "test first window ramp up",
+			prev:       0,
+			throughput: 100,
+			wantLimit:  5,
+		},
+		{
+			name:       "test throughput gain keep ramp up",
+			prev:       100,
+			throughput: 150,
+			wantLimit:  5,
+		},
+		{
+			name:       "test throughput loss ramp down",
+			prev:       100,
+			throughput: 50,
+			wantLimit:  3,
+		},
+		{
+			name:       "test plateau hold limit",
+			prev:       100,
+			throughput: 101,
+			latency:    time.Millisecond,
+			wantLimit:  4,
+		},
+		{
+			name:       "test latency grows without gain ramp down",
+			prev:       100,
+			throughput: 100,
+			latency:    time.Second,
+			wantLimit:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				pool := MakeWorkerPool(4)
+				tuner := MakeAdaptiveTuner(pool, 8)
+				tuner.prevThroughput = tt.prev
+				tuner.prevLatency = time.Millisecond
+
+				tuner.tune(tt.throughput, tt.latency)
+
+				require.Equal(t, tt.wantLimit, pool.Limit())
+			},
+		)
+	}
+}
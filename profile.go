@@ -0,0 +1,225 @@
+// contains named sync profiles with own filters and options
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var UnknownProfile = fmt.Errorf("unknown profile")
+
+var BadSyncProfile = fmt.Errorf("bad sync profile")
+
+// PathFilter select entries by glob patterns (filepath.Match syntax).
+// Patterns are matched against path relative to root and against base
+// name. Excluded directory exclude all nested entries. If Include is
+// not empty only matched files are synced (directories are walked)
+type PathFilter struct {
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+}
+
+// Validate check patterns syntax
+func (f *PathFilter) Validate() (err error) {
+	if f == nil {
+		return err
+	}
+
+	for _, pattern := range append(f.Include, f.Exclude...) {
+		if _, err = filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return err
+}
+
+// Match return true if entry have to be synced. Nil filter match all
+func (f *PathFilter) Match(rel string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+
+	rel = filepath.ToSlash(filepath.Clean(rel))
+
+	// check each parent directory and entry itself
+	for i := 0; i <= len(rel); i++ {
+		if i < len(rel) && rel[i] != '/' {
+			continue
+		}
+
+		if matchAny(f.Exclude, rel[:i]) {
+			return false
+		}
+	}
+
+	if isDir || len(f.Include) == 0 {
+		return true
+	}
+
+	return matchAny(f.Include, rel)
+}
+
+// matchAny match path or its base name with any pattern
+func matchAny(patterns []string, path string) bool {
+	base := path[strings.LastIndex(path, "/")+1:]
+
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DestinationOptions change how destination is updated
+type DestinationOptions struct {
+	// NoDelete keep entries which not exist in source
+	NoDelete bool `yaml:"no_delete" json:"no_delete"`
+
+	// OneWay source always wins, newer files from
+	// destination are not copied back
+	OneWay bool `yaml:"one_way" json:"one_way"`
+}
+
+// SyncProfile is a named src/dst pair with own sync settings.
+// Empty values are taken from server config
+type SyncProfile struct {
+	SrcPath        string `yaml:"src_path" json:"src_path"`
+	DstPath        string `yaml:"dst_path" json:"dst_path"`
+	MaxDiffPercent int    `yaml:"max_diff_percent" json:"max_diff_percent"`
+	Mode           string `yaml:"mode" json:"mode"`
+
+	Filter      *PathFilter         `yaml:"filter" json:"filter"`
+	Destination *DestinationOptions `yaml:"destination" json:"destination"`
+	Concurrency *Concurrency        `yaml:"concurrency" json:"concurrency"`
+
+	// Limits applied in addition to server limits
+	Limits *ThrottleLimits `yaml:"limits" json:"limits"`
+}
+
+// Request return sync request of profile
+func (p SyncProfile) Request() SyncDirectoriesRequest {
+	return SyncDirectoriesRequest{
+		SrcPath:        p.SrcPath,
+		DstPath:        p.DstPath,
+		MaxDiffPercent: p.MaxDiffPercent,
+		Mode:           p.Mode,
+		Concurrency:    p.Concurrency,
+		Filter:         p.Filter,
+		Destination:    p.Destination,
+	}
+}
+
+// ValidateProfiles check required paths, modes and filters of profiles
+func ValidateProfiles(profiles map[string]SyncProfile) (err error) {
+	for name, p := range profiles {
+		if p.SrcPath == "" || p.DstPath == "" {
+			return fmt.Errorf("%w: %q: src_path and dst_path required", BadSyncProfile, name)
+		}
+
+		switch p.Mode {
+		case "", ModeFull, ModeStream:
+		default:
+			return fmt.Errorf("%w: %q: %w: %s", BadSyncProfile, name, UnknownSyncMode, p.Mode)
+		}
+
+		if err = p.Filter.Validate(); err != nil {
+			return fmt.Errorf("%w: %q: %w", BadSyncProfile, name, err)
+		}
+	}
+	return err
+}
+
+// applyOptions drop filtered entries from prepared SyncCommand and
+// apply destination options
+func applyOptions(
+	cmd *SyncCommand,
+	srcRoot string,
+	dstRoot string,
+	filter *PathFilter,
+	dest *DestinationOptions,
+) {
+	if filter == nil && dest == nil {
+		return
+	}
+
+	if dest == nil {
+		dest = new(DestinationOptions)
+	}
+
+	// rel return path relative to one of roots
+	rel := func(path string) string {
+		root := dstRoot
+		if !underRoot(dstRoot, path) {
+			root = srcRoot
+		}
+
+		r, _ := filepath.Rel(root, path)
+		return r
+	}
+
+	pairs := cmd.SyncPairs[:0]
+	for _, pair := range cmd.SyncPairs {
+		if !filter.Match(rel(pair.Dst), false) {
+			continue
+		}
+
+		// rotated pair - dst file was newer
+		if dest.OneWay && !underRoot(dstRoot, pair.Dst) {
+			pair.Src, pair.Dst = pair.Dst, pair.Src
+		}
+		pairs = append(pairs, pair)
+	}
+	cmd.SyncPairs = pairs
+
+	dirs := cmd.DirsToCreate[:0]
+	for _, dir := range cmd.DirsToCreate {
+		if filter.Match(rel(dir.DirPath), true) {
+			dirs = append(dirs, dir)
+		}
+	}
+	cmd.DirsToCreate = dirs
+
+	if dest.NoDelete {
+		cmd.DirsToDelete = cmd.DirsToDelete[:0]
+		clear(cmd.FilesToDelete)
+		return
+	}
+
+	dirsToDel := cmd.DirsToDelete[:0]
+	for _, dir := range cmd.DirsToDelete {
+		if filter.Match(rel(dir), true) {
+			dirsToDel = append(dirsToDel, dir)
+		}
+	}
+	cmd.DirsToDelete = dirsToDel
+
+	for key, files := range cmd.FilesToDelete {
+		kept := files[:0]
+		for _, file := range files {
+			if filter.Match(rel(file), false) {
+				kept = append(kept, file)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(cmd.FilesToDelete, key)
+			continue
+		}
+		cmd.FilesToDelete[key] = kept
+	}
+}
+
+// underRoot check that path is inside root (or equal to root)
+func underRoot(root string, path string) bool {
+	r, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return r != ".." && !strings.HasPrefix(r, "../")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPathFilter_Match(t *testing.T) {
+	filter := &PathFilter{
+		Include: []string{"*.jpg", "docs/*.md"},
+		Exclude: []string{".cache", "*.tmp"},
+	}
+
+	tests := []struct {
+		name   string
+		filter *PathFilter
+		rel    string
+		isDir  bool
+		want   bool
+	}{
+		{name: "test nil filter match all", rel: "a/b.txt", want: true},
+		{name: "test include by base name", filter: filter, rel: "a/b.jpg", want: true},
+		{name: "test include by path", filter: filter, rel: "docs/readme.md", want: true},
+		{name: "test not included file", filter: filter, rel: "a/b.txt", want: false},
+		{name: "test directory walked", filter: filter, rel: "a", isDir: true, want: true},
+		{name: "test excluded file", filter: filter, rel: "x.tmp", want: false},
+		{name: "test excluded directory", filter: filter, rel: ".cache", isDir: true, want: false},
+		{name: "test file in excluded directory", filter: filter, rel: "a/.cache/b.jpg", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, tt.filter.Match(tt.rel, tt.isDir))
+			},
+		)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles map[string]SyncProfile
+		wantErr  bool
+	}{
+		{
+			name:     "test valid profile",
+			profiles: map[string]SyncProfile{"p": {SrcPath: "/a", DstPath: "/b", Mode: ModeStream}},
+		},
+		{
+			name:     "test missing paths",
+			profiles: map[string]SyncProfile{"p": {SrcPath: "/a"}},
+			wantErr:  true,
+		},
+		{
+			name:     "test unknown mode",
+			profiles: map[string]SyncProfile{"p": {SrcPath: "/a", DstPath: "/b", Mode: "fast"}},
+			wantErr:  true,
+		},
+		{
+			name: "test bad pattern",
+			profiles: map[string]SyncProfile{
+				"p": {SrcPath: "/a", DstPath: "/b", Filter: &PathFilter{Exclude: []string{"["}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := ValidateProfiles(tt.profiles)
+				if tt.wantErr {
+					require.ErrorIs(t, err, BadSyncProfile)
+					return
+				}
+				require.NoError(t, err)
+			},
+		)
+	}
+}
+
+func Test_applyOptions(t *testing.T) {
+	cmd := SyncCommand{
+		SyncPairs: []SyncPair{
+			{Src: "/src/a.jpg", Dst: "/dst/a.jpg"},
+			{Src: "/src/b.tmp", Dst: "/dst/b.tmp"},
+			// rotated - dst was newer
+			{Src: "/dst/c.jpg", Dst: "/src/c.jpg"},
+		},
+		DirsToCreate:  []NewDirectory{{DirPath: "/dst/new"}, {DirPath: "/dst/.cache"}},
+		DirsToDelete:  []string{"/dst/old"},
+		FilesToDelete: map[string][]string{"/dst": {"/dst/x.jpg"}},
+	}
+
+	applyOptions(
+		&cmd,
+		"/src",
+		"/dst",
+		&PathFilter{Exclude: []string{".cache", "*.tmp"}},
+		&DestinationOptions{NoDelete: true, OneWay: true},
+	)
+
+	require.Equal(
+		t,
+		[]SyncPair{
+			{Src: "/src/a.jpg", Dst: "/dst/a.jpg"},
+			{Src: "/src/c.jpg", Dst: "/dst/c.jpg"},
+		},
+		cmd.SyncPairs,
+	)
+	require.Equal(t, []NewDirectory{{DirPath: "/dst/new"}}, cmd.DirsToCreate)
+	require.Empty(t, cmd.DirsToDelete)
+	require.Empty(t, cmd.FilesToDelete)
+}
+
+func TestScanner_PlanOptions(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	makeTree(
+		t, src, map[string]string{
+			"a.jpg":      "a",
+			"b.tmp":      "b",
+			".cache/c":   "c",
+			"older.jpg":  "older",
+			"nested/d.j": "d",
+		},
+	)
+	makeTree(
+		t, dst, map[string]string{
+			"stale.jpg": "stale",
+		},
+	)
+
+	// destination file is newer, must be overwritten in one way mode
+	makeTree(t, dst, map[string]string{"older.jpg": "newer"})
+	now := time.Now()
+	require.NoError(t, os.Chtimes(filepath.Join(dst, "older.jpg"), now, now))
+
+	sc := &Scanner{
+		SrcRoot:     src,
+		DstRoot:     dst,
+		Workers:     2,
+		Filter:      &PathFilter{Exclude: []string{".cache", "*.tmp"}},
+		Destination: &DestinationOptions{NoDelete: true, OneWay: true},
+	}
+	out := make(chan PlanEntry, DefaultPlanBufferSize)
+	require.NoError(t, sc.Plan(context.Background(), out))
+
+	got := make([]string, 0)
+	for e := range out {
+		path := e.Path
+		if e.Op == OpSyncFile {
+			path = e.Pair.Dst
+		}
+		rel, err := filepath.Rel(dst, path)
+		require.NoError(t, err)
+		got = append(got, e.Op+":"+rel)
+	}
+	sort.Strings(got)
+
+	require.Equal(
+		t,
+		[]string{
+			"create_dir:nested",
+			"sync_file:a.jpg",
+			"sync_file:nested/d.j",
+			"sync_file:older.jpg",
+		},
+		got,
+	)
+}
@@ -1,9 +1,129 @@
 // request contain request schemas
 package main
 
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var InvalidRequest = fmt.Errorf("invalid request")
+
 // SyncDirectoriesRequest query for start directories sync
 type SyncDirectoriesRequest struct {
-	SrcPath        string `json:"src_path" Validate:"required,dirpath"`
-	DstPath        string `json:"dst_path" Validate:"required,dirpath"`
-	MaxDiffPercent int    `json:"max_diff_percent" Validate:"required,gt=0,lte=100"`
+	SrcPath        string `json:"src_path" validate:"required,dirpath"`
+	DstPath        string `json:"dst_path" validate:"required,dirpath"`
+	MaxDiffPercent int    `json:"max_diff_percent" validate:"required,gt=0,lte=100"`
+
+	// Mode override configured sync mode: full or stream (optional)
+	Mode string `json:"mode"`
+
+	// Concurrency override configured workers count (optional)
+	Concurrency *Concurrency `json:"concurrency"`
+
+	// Filter select synced entries by glob patterns (optional)
+	Filter *PathFilter `json:"filter"`
+
+	// Destination options (optional)
+	Destination *DestinationOptions `json:"destination"`
+}
+
+// SyncFanOutRequest query for sync one source into many destinations
+type SyncFanOutRequest struct {
+	SrcPath        string   `json:"src_path" validate:"required,dirpath"`
+	DstPaths       []string `json:"dst_paths" validate:"required,min=1,dive,dirpath"`
+	MaxDiffPercent int      `json:"max_diff_percent" validate:"required,gt=0,lte=100"`
+
+	// Concurrency override configured workers count (optional)
+	Concurrency *Concurrency `json:"concurrency"`
+
+	// Filter select synced entries by glob patterns (optional)
+	Filter *PathFilter `json:"filter"`
+
+	// NoDelete keep entries which not exist in source (optional)
+	NoDelete bool `json:"no_delete"`
+}
+
+// ValidateRequest check bound request by validate tag rules, problems
+// are reported by json keys
+func ValidateRequest(req any) (err error) {
+	var fieldErrs validator.ValidationErrors
+
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(
+		func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			return name
+		},
+	)
+
+	if err = v.Struct(req); err == nil || !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	lines := make([]string, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		// namespace starts with struct name
+		_, key, _ := strings.Cut(fe.Namespace(), ".")
+		lines = append(lines, fmt.Sprintf("%s: %s", key, describeRule(fe)))
+	}
+	return fmt.Errorf("%w: %s", InvalidRequest, strings.Join(lines, "; "))
+}
+
+// UpdateSyncLimitsRequest query for update sync rate limits at runtime.
+// Only passed fields will be updated
+type UpdateSyncLimitsRequest struct {
+	BytesPerSec       *int64            `json:"bytes_per_sec"`
+	FilesPerSec       *int64            `json:"files_per_sec"`
+	WorkerBytesPerSec *int64            `json:"worker_bytes_per_sec"`
+	Schedule          *[]ThrottleWindow `json:"schedule"`
+}
+
+// SyncLimitsResponse contains configured and applied rate limits
+type SyncLimitsResponse struct {
+	Base     ThrottleLimits   `json:"base"`
+	Schedule []ThrottleWindow `json:"schedule"`
+	Current  ThrottleLimits   `json:"current"`
+}
+
+// ConfigResponse contains effective server config
+type ConfigResponse struct {
+	// Version is a hash of config, used for optimistic updates
+	Version string `json:"version"`
+
+	// Config values by yaml keys, secrets are redacted
+	Config map[string]any `json:"config"`
+
+	// Sources of values (default, file, env, flag) by dotted keys
+	Sources map[string]string `json:"sources"`
+}
+
+// ConfigUpdateResponse contains updated server config
+type ConfigUpdateResponse struct {
+	ConfigResponse
+
+	// Changed top level keys
+	Changed []string `json:"changed"`
+
+	// RestartRequired keys are applied after restart only
+	RestartRequired []string `json:"restart_required"`
+}
+
+// StatusResponse contains state of daemon
+type StatusResponse struct {
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+
+	// Busy is true if any sync holds locks of its paths
+	Busy    bool      `json:"busy"`
+	Running []SyncRun `json:"running"`
+
+	ConfigVersion string `json:"config_version"`
+	ScheduledJobs int    `json:"scheduled_jobs"`
+	WatchPairs    int    `json:"watch_pairs"`
 }
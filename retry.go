@@ -0,0 +1,143 @@
+// contains retry policy for transient I/O errors
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryAttempts used if attempts count not set (single try)
+const DefaultRetryAttempts = 1
+
+// DefaultRetryBaseDelay used if base delay not set
+const DefaultRetryBaseDelay = 100 * time.Millisecond
+
+// DefaultRetryableErrors used if no error classes configured
+var DefaultRetryableErrors = []string{"EIO", "ESTALE", "EAGAIN"}
+
+var UnknownErrorClass = fmt.Errorf("unknown retryable error class")
+
+// retryableClasses contain all error classes that can be retried
+var retryableClasses = map[string]syscall.Errno{
+	"EIO":       syscall.EIO,
+	"ESTALE":    syscall.ESTALE,
+	"EAGAIN":    syscall.EAGAIN,
+	"EBUSY":     syscall.EBUSY,
+	"EINTR":     syscall.EINTR,
+	"ETIMEDOUT": syscall.ETIMEDOUT,
+}
+
+// RetryPolicy describe how failed item operations will be repeated
+type RetryPolicy struct {
+	// MaxAttempts total attempts count (first try included)
+	MaxAttempts int
+
+	// BaseDelay delay before second attempt, doubled on each next one
+	BaseDelay time.Duration
+
+	// Jitter part of delay (0..1) that will be randomized
+	Jitter float64
+
+	// Retryable allowlist of errors which will be retried
+	Retryable []syscall.Errno
+}
+
+// MakeRetryPolicy factory function return new RetryPolicy. Return
+// error if unknown error class passed
+func MakeRetryPolicy(
+	attempts int,
+	delay time.Duration,
+	jitter float64,
+	classes []string,
+) (p RetryPolicy, err error) {
+	if attempts < 1 {
+		attempts = DefaultRetryAttempts
+	}
+
+	if delay <= 0 {
+		delay = DefaultRetryBaseDelay
+	}
+
+	if jitter < 0 || jitter > 1 {
+		return p, fmt.Errorf("retry jitter out of range [0, 1]: %v", jitter)
+	}
+
+	if len(classes) == 0 {
+		classes = DefaultRetryableErrors
+	}
+
+	errs := make([]syscall.Errno, 0, len(classes))
+	for _, class := range classes {
+		errno, ok := retryableClasses[strings.ToUpper(class)]
+		if !ok {
+			return p, fmt.Errorf("%w: %s", UnknownErrorClass, class)
+		}
+		errs = append(errs, errno)
+	}
+
+	return RetryPolicy{
+		MaxAttempts: attempts,
+		BaseDelay:   delay,
+		Jitter:      jitter,
+		Retryable:   errs,
+	}, err
+}
+
+// IsRetryable return true if error belongs to allowed error classes
+func (p RetryPolicy) IsRetryable(err error) bool {
+	for _, errno := range p.Retryable {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do call op until success, not retryable error or attempts limit.
+// Return attempts count and last error
+func (p RetryPolicy) Do(ctx context.Context, op func() error) (
+	attempts int,
+	err error,
+) {
+	limit := max(p.MaxAttempts, 1)
+
+	for attempts = 1; ; attempts++ {
+		if err = op(); err == nil {
+			return attempts, err
+		}
+
+		if attempts >= limit || !p.IsRetryable(err) {
+			return attempts, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff return delay after failed attempt (exponential with jitter)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 {
+		// overflow - use max possible delay
+		delay = time.Duration(1<<63 - 1)
+	}
+
+	if p.Jitter == 0 {
+		return delay
+	}
+
+	// randomize delay in range [delay * (1 - jitter), delay]
+	spread := float64(delay) * p.Jitter
+	return delay - time.Duration(rand.Float64()*spread)
+}
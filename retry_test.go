@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	tests := []struct {
+		name         string
+		attempts     int
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "test success after transient errors",
+			attempts:     3,
+			errs:         []error{syscall.EIO, syscall.ESTALE, nil},
+			wantAttempts: 3,
+			wantErr:      nil,
+		},
+		{
+			name:     "test wrapped transient error will be retried",
+			attempts: 3,
+			errs: []error{
+				&fs.PathError{Op: "open", Path: "/a", Err: syscall.EAGAIN},
+				nil,
+			},
+			wantAttempts: 2,
+			wantErr:      nil,
+		},
+		{
+			name:         "test not retryable error stop retries",
+			attempts:     3,
+			errs:         []error{syscall.EACCES, nil},
+			wantAttempts: 1,
+			wantErr:      syscall.EACCES,
+		},
+		{
+			name:         "test attempts limit",
+			attempts:     2,
+			errs:         []error{syscall.EIO, syscall.EIO, nil},
+			wantAttempts: 2,
+			wantErr:      syscall.EIO,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				p, err := MakeRetryPolicy(
+					tt.attempts,
+					time.Millisecond,
+					0.5,
+					[]string{"EIO", "ESTALE", "EAGAIN"},
+				)
+				require.NoError(t, err)
+
+				call := 0
+				attempts, err := p.Do(
+					context.Background(), func() error {
+						e := tt.errs[call]
+						call++
+						return e
+					},
+				)
+
+				require.Equal(t, tt.wantAttempts, attempts)
+				require.ErrorIs(t, err, tt.wantErr)
+			},
+		)
+	}
+}
+
+func TestMakeRetryPolicy_UnknownClass(t *testing.T) {
+	_, err := MakeRetryPolicy(3, time.Second, 0, []string{"eio", "ENOPE"})
+	require.ErrorIs(t, err, UnknownErrorClass)
+}
+
+func TestSyncResult_Add(t *testing.T) {
+	res := MakeSyncResult()
+
+	res.Add(OpSyncFile, "/a", 1, nil)
+	res.Add(OpSyncFile, "/b", 2, nil)
+	res.Add(OpDeleteFile, "/c", 3, fmt.Errorf("broken"))
+
+	require.Equal(t, 2, res.Succeeded)
+	require.Equal(t, 1, res.Failed)
+	require.Equal(t, 2, res.Retried)
+	require.Equal(
+		t,
+		[]ItemResult{
+			{Op: OpSyncFile, Path: "/b", Attempts: 2},
+			{Op: OpDeleteFile, Path: "/c", Attempts: 3, Error: "broken"},
+		},
+		res.Items,
+	)
+}
@@ -0,0 +1,101 @@
+// contains restriction of requested paths to allowed roots
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+var PathNotAllowed = fmt.Errorf("path not allowed")
+
+var NestedSyncPaths = fmt.Errorf("nested sync paths")
+
+// PathGuard check that requested paths are inside allowed roots.
+// Paths are canonicalised (absolute, symlinks resolved) before check
+type PathGuard struct {
+	roots []string
+}
+
+// MakePathGuard factory function return new PathGuard. Roots which
+// can`t be resolved (not mounted yet) are used as is
+func MakePathGuard(roots []string) *PathGuard {
+	g := &PathGuard{roots: make([]string, 0, len(roots))}
+
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+
+		canonical, err := canonicalPath(root)
+		if err != nil {
+			canonical = filepath.Clean(root)
+		}
+		g.roots = append(g.roots, canonical)
+	}
+
+	return g
+}
+
+// Roots return canonical allowed roots
+func (g *PathGuard) Roots() []string {
+	return g.roots
+}
+
+// Check return canonical path if it is inside any allowed root
+func (g *PathGuard) Check(path string) (canonical string, err error) {
+	if canonical, err = canonicalPath(path); err != nil {
+		return canonical, fmt.Errorf("%w: %w", ScanFailed, err)
+	}
+
+	for _, root := range g.roots {
+		if underRoot(root, canonical) {
+			return canonical, err
+		}
+	}
+
+	return canonical, fmt.Errorf("%w: %s", PathNotAllowed, path)
+}
+
+// CheckPaths check all paths and return them canonicalised. Paths
+// must not be equal or nested into each other
+func (g *PathGuard) CheckPaths(paths ...string) (res []string, err error) {
+	res = make([]string, len(paths))
+
+	for i, path := range paths {
+		if res[i], err = g.Check(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = CheckNested(res...); err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
+// CheckNested return error if any two paths are equal or nested.
+// Empty paths are skipped
+func CheckNested(paths ...string) (err error) {
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if paths[i] == "" || paths[j] == "" {
+				continue
+			}
+
+			a, b := filepath.Clean(paths[i]), filepath.Clean(paths[j])
+			if underRoot(a, b) || underRoot(b, a) {
+				return fmt.Errorf("%w: %s and %s", NestedSyncPaths, a, b)
+			}
+		}
+	}
+	return err
+}
+
+// canonicalPath return absolute path with resolved symlinks
+func canonicalPath(path string) (canonical string, err error) {
+	if canonical, err = filepath.Abs(path); err != nil {
+		return canonical, err
+	}
+	return filepath.EvalSymlinks(canonical)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathGuard_CheckPaths(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	makeTree(t, root, map[string]string{"src/": "", "dst/": "", "src/inner/": ""})
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+
+	root, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+
+	guard := MakePathGuard([]string{root})
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "test paths inside root",
+			paths: []string{root + "/src/", root + "/dst/../dst"},
+			want:  []string{root + "/src", root + "/dst"},
+		},
+		{
+			name:    "test path outside root",
+			paths:   []string{root + "/src", outside},
+			wantErr: PathNotAllowed,
+		},
+		{
+			name:    "test symlink outside root",
+			paths:   []string{root + "/src", root + "/escape"},
+			wantErr: PathNotAllowed,
+		},
+		{
+			name:    "test nested paths",
+			paths:   []string{root + "/src", root + "/src/inner"},
+			wantErr: NestedSyncPaths,
+		},
+		{
+			name:    "test equal paths",
+			paths:   []string{root + "/dst", root + "/dst/"},
+			wantErr: NestedSyncPaths,
+		},
+		{
+			name:    "test missing path",
+			paths:   []string{root + "/src", root + "/missing"},
+			wantErr: ScanFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := guard.CheckPaths(tt.paths...)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
@@ -0,0 +1,248 @@
+// contains registry of sync runs started by API, scheduler and watchers
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRunHistory count of finished runs kept in registry
+const DefaultRunHistory = 100
+
+// kinds of sync runs
+const (
+	RunKindSync     = "sync"
+	RunKindFanOut   = "fanout"
+	RunKindProfile  = "profile"
+	RunKindSchedule = "schedule"
+	RunKindWatch    = "watch"
+)
+
+// states of sync runs, finished runs use job statuses
+const (
+	RunStateRunning  = "running"
+	RunStateCanceled = "canceled"
+)
+
+var UnknownRun = fmt.Errorf("unknown sync run")
+var RunFinished = fmt.Errorf("sync run already finished")
+
+// SyncRun is a state of single sync run
+type SyncRun struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+
+	// Name of profile or scheduled job
+	Name string `json:"name,omitempty"`
+
+	SrcPath  string   `json:"src_path"`
+	DstPaths []string `json:"dst_paths"`
+
+	// State running, ok, failed or canceled
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// RunRegistry keep running and last finished sync runs
+type RunRegistry struct {
+	lock *sync.Mutex
+	runs map[string]*SyncRun
+
+	// finished runs, oldest first
+	history []string
+	limit   int
+	seq     uint64
+
+	// onChange is called after run is started or finished
+	onChange func()
+
+	// onFinish is called with copy of finished run
+	onFinish func(SyncRun)
+
+	now func() time.Time
+}
+
+// MakeRunRegistry factory function return RunRegistry which keep
+// limit finished runs
+func MakeRunRegistry(limit int) *RunRegistry {
+	if limit <= 0 {
+		limit = DefaultRunHistory
+	}
+
+	return &RunRegistry{
+		lock:  new(sync.Mutex),
+		runs:  make(map[string]*SyncRun),
+		limit: limit,
+		now:   time.Now,
+	}
+}
+
+// OnChange set function called after run is started or finished.
+// Set it before runs are started
+func (r *RunRegistry) OnChange(fn func()) {
+	r.onChange = fn
+}
+
+// OnFinish set function called with copy of each finished run.
+// Set it before runs are started
+func (r *RunRegistry) OnFinish(fn func(SyncRun)) {
+	r.onFinish = fn
+}
+
+// changed call change hook if set
+func (r *RunRegistry) changed() {
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
+
+// Start register run. Return context canceled by Cancel and run id
+func (r *RunRegistry) Start(ctx context.Context, run SyncRun) (
+	context.Context,
+	string,
+) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// hook is called after unlock
+	defer r.changed()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	run.ID = strconv.FormatUint(r.seq, 10)
+	run.State = RunStateRunning
+	run.StartedAt = r.now()
+	run.cancel = cancel
+	r.runs[run.ID] = &run
+
+	return ctx, run.ID
+}
+
+// Finish save outcome of run
+func (r *RunRegistry) Finish(id string, res *SyncResult, err error) {
+	var done *SyncRun
+
+	// hooks are called after unlock
+	defer func() {
+		if done != nil && r.onFinish != nil {
+			r.onFinish(*done)
+		}
+	}()
+	defer r.changed()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	run, ok := r.runs[id]
+	if !ok || run.State != RunStateRunning {
+		return
+	}
+	run.cancel()
+
+	finished := r.now()
+	run.FinishedAt = &finished
+	if res != nil {
+		run.Succeeded, run.Failed = res.Succeeded, res.Failed
+	}
+
+	switch {
+	case run.canceled && errors.Is(err, context.Canceled):
+		run.State = RunStateCanceled
+	case err != nil:
+		run.State = JobStatusFailed
+	default:
+		run.State = JobStatusOk
+	}
+
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	copied := *run
+	done = &copied
+
+	r.history = append(r.history, id)
+	for len(r.history) > r.limit {
+		delete(r.runs, r.history[0])
+		r.history = r.history[1:]
+	}
+}
+
+// Cancel stop running sync run
+func (r *RunRegistry) Cancel(id string) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	run, ok := r.runs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", UnknownRun, id)
+	}
+
+	if run.State != RunStateRunning {
+		return fmt.Errorf("%w: %s", RunFinished, id)
+	}
+
+	run.canceled = true
+	run.cancel()
+	return err
+}
+
+// Get return copy of run by id
+func (r *RunRegistry) Get(id string) (run SyncRun, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	found, ok := r.runs[id]
+	if !ok {
+		return run, fmt.Errorf("%w: %s", UnknownRun, id)
+	}
+	return *found, err
+}
+
+// List return copies of runs, newest first
+func (r *RunRegistry) List() []SyncRun {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	runs := make([]SyncRun, 0, len(r.runs))
+	for _, run := range r.runs {
+		runs = append(runs, *run)
+	}
+
+	slices.SortFunc(
+		runs, func(a, b SyncRun) int {
+			ai, _ := strconv.ParseUint(a.ID, 10, 64)
+			bi, _ := strconv.ParseUint(b.ID, 10, 64)
+			return cmp.Compare(bi, ai)
+		},
+	)
+	return runs
+}
+
+// Running return count of running runs
+func (r *RunRegistry) Running() (count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, run := range r.runs {
+		if run.State == RunStateRunning {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRunRegistry(t *testing.T) {
+	runs := MakeRunRegistry(2)
+
+	_, first := runs.Start(context.Background(), SyncRun{Kind: RunKindSync, SrcPath: "/a"})
+	ctx, second := runs.Start(context.Background(), SyncRun{Kind: RunKindSchedule, Name: "nightly"})
+	require.Equal(t, 2, runs.Running())
+
+	runs.Finish(first, &SyncResult{Succeeded: 2, Failed: 1}, errors.New("copy failed"))
+
+	require.NoError(t, runs.Cancel(second))
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	runs.Finish(second, &SyncResult{Succeeded: 1}, ctx.Err())
+	require.Zero(t, runs.Running())
+
+	run, err := runs.Get(first)
+	require.NoError(t, err)
+	require.Equal(t, JobStatusFailed, run.State)
+	require.Equal(t, 1, run.Failed)
+	require.Equal(t, "copy failed", run.Error)
+	require.NotNil(t, run.FinishedAt)
+
+	run, err = runs.Get(second)
+	require.NoError(t, err)
+	require.Equal(t, RunStateCanceled, run.State)
+
+	require.ErrorIs(t, runs.Cancel(first), RunFinished)
+	require.ErrorIs(t, runs.Cancel("42"), UnknownRun)
+
+	// oldest finished run is dropped over limit
+	_, third := runs.Start(context.Background(), SyncRun{Kind: RunKindWatch})
+	runs.Finish(third, nil, nil)
+
+	_, err = runs.Get(first)
+	require.ErrorIs(t, err, UnknownRun)
+
+	list := runs.List()
+	require.Len(t, list, 2)
+	require.Equal(t, []string{third, second}, []string{list[0].ID, list[1].ID})
+	require.Equal(t, JobStatusOk, list[0].State)
+}
@@ -0,0 +1,598 @@
+// contains streaming tree scanner that emit sync plan incrementally
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sync modes
+const (
+	// ModeFull collect both trees by HandlePaths and prepare SyncCommand
+	ModeFull = "full"
+
+	// ModeStream walk both trees by Scanner and execute plan entries
+	// while scan is running. Deletes are held until scan is done and
+	// checked against max diff percent
+	ModeStream = "stream"
+)
+
+// DefaultPlanBufferSize for plan entries channel
+const DefaultPlanBufferSize = 256
+
+var UnknownSyncMode = fmt.Errorf("unknown sync mode")
+
+// PlanEntry single action emitted by Scanner
+type PlanEntry struct {
+	// Op one of OpDeleteDir, OpDeleteFile, OpCreateDir, OpSyncFile
+	Op string
+
+	// Path full path for delete and create operations
+	Path string
+
+	// Perm for created directory
+	Perm fs.FileMode
+
+	// Pair for sync operation
+	Pair SyncPair
+}
+
+// ScanEntry contains directory entry meta information
+type ScanEntry struct {
+	Name    string
+	IsDir   bool
+	ModTime time.Time
+	Size    int64
+	Perm    fs.FileMode
+}
+
+// Scanner is a parallel directory walker with bounded workers count.
+// Both trees are walked together directory by directory: listings
+// (sorted by name) of same nested directory are merge-joined and plan
+// entries are emitted without holding whole tree in memory
+type Scanner struct {
+	// SrcRoot root path to source directory
+	SrcRoot string
+
+	// DstRoot root path to dest directory
+	DstRoot string
+
+	// Workers max count of directories read concurrently
+	Workers int
+
+	// SrcLister and DstLister read directories (nil - read from fs)
+	SrcLister DirLister
+	DstLister DirLister
+
+	// Root nested directory (relative to roots) to start from
+	Root string
+
+	// Shallow do not dive into existing nested directories,
+	// new directories are handled entirely
+	Shallow bool
+
+	// Filter select synced entries (nil - all)
+	Filter *PathFilter
+
+	// Destination options (nil - defaults)
+	Destination *DestinationOptions
+
+	// MaxDeletePercent of scanned dest entries allowed to be deleted
+	// (0 - not checked). Deletes are emitted after scan when limit
+	// is not reached, otherwise plan fails with TooLargeDifferenceErr.
+	// Entries replacing dest entries of other type follow deletes
+	MaxDeletePercent int
+}
+
+// heldDeletes collect deletes of plan until scan is done
+type heldDeletes struct {
+	lock    *sync.Mutex
+	entries []PlanEntry
+	scanned int
+
+	// replaced source entries of dest entries with other type,
+	// planned after held deletes
+	replaced []heldReplace
+}
+
+// heldReplace source entry replacing deleted dest entry
+type heldReplace struct {
+	rel string
+	src ScanEntry
+}
+
+// add hold delete entry
+func (h *heldDeletes) add(e PlanEntry) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+// replace hold delete entry and source entry planned after it
+func (h *heldDeletes) replace(e PlanEntry, rel string, src ScanEntry) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.entries = append(h.entries, e)
+	h.replaced = append(h.replaced, heldReplace{rel: rel, src: src})
+	return nil
+}
+
+// count scanned dest entries
+func (h *heldDeletes) count(n int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.scanned += n
+}
+
+// percent of scanned dest entries to be deleted
+func (h *heldDeletes) percent() int {
+	if h.scanned == 0 {
+		return 0
+	}
+	return int(float64(len(h.entries)) / float64(h.scanned) * 100)
+}
+
+// invalidator is implemented by listers which cache directories
+type invalidator interface {
+	Invalidate(dir string)
+}
+
+// scanTask is a nested directory (relative to roots) to merge
+type scanTask struct {
+	rel       string
+	dstExists bool
+}
+
+// scanQueue is an unbounded LIFO queue of directories. Queue is
+// closed when all pushed tasks are done
+type scanQueue struct {
+	lock    *sync.Mutex
+	cond    *sync.Cond
+	tasks   []scanTask
+	pending int
+	closed  bool
+}
+
+func makeScanQueue() *scanQueue {
+	lock := new(sync.Mutex)
+	return &scanQueue{
+		lock:  lock,
+		cond:  sync.NewCond(lock),
+		tasks: make([]scanTask, 0, DefaultDirAllocSize),
+	}
+}
+
+func (q *scanQueue) push(t scanTask) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.tasks = append(q.tasks, t)
+	q.pending++
+	q.cond.Signal()
+}
+
+func (q *scanQueue) pop() (t scanTask, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.tasks) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.closed {
+		return t, ok
+	}
+
+	t = q.tasks[len(q.tasks)-1]
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return t, true
+}
+
+func (q *scanQueue) done() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.pending--
+	if q.pending == 0 {
+		q.close()
+	}
+}
+
+// close must be called under lock
+func (q *scanQueue) close() {
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *scanQueue) abort() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.close()
+}
+
+// Plan walk both trees and send plan entries into out. Out will be
+// closed on return. Directories creation is emitted before any entry
+// inside created directory
+func (sc *Scanner) Plan(ctx context.Context, out chan<- PlanEntry) (
+	err error,
+) {
+	var deletes *heldDeletes
+
+	if sc.MaxDeletePercent > 0 {
+		deletes = &heldDeletes{lock: new(sync.Mutex)}
+	}
+
+	defer close(out)
+
+	if _, err = os.Stat(sc.SrcRoot); err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(sc.DstRoot); err != nil {
+		// dst root dir not created, we can`t continue
+		return fmt.Errorf("no root destination directory: %w", err)
+	}
+
+	task := scanTask{rel: sc.Root, dstExists: true}
+	if sc.Root != "" {
+		var info os.FileInfo
+
+		// directory removed - will be handled with parent directory
+		if info, err = os.Stat(filepath.Join(sc.SrcRoot, sc.Root)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		dstDir := filepath.Join(sc.DstRoot, sc.Root)
+		if _, err = os.Stat(dstDir); os.IsNotExist(err) {
+			task.dstExists = false
+			entry := PlanEntry{Op: OpCreateDir, Path: dstDir, Perm: info.Mode().Perm()}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- entry:
+			}
+		}
+	}
+
+	queue := makeScanQueue()
+	queue.push(task)
+
+	if err = sc.scan(ctx, queue, out, deletes); err != nil || deletes == nil {
+		return err
+	}
+
+	if deletes.percent() >= sc.MaxDeletePercent {
+		return fmt.Errorf(
+			"%w: %d of %d dest entries to delete",
+			TooLargeDifferenceErr,
+			len(deletes.entries),
+			deletes.scanned,
+		)
+	}
+
+	for _, entry := range deletes.entries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- entry:
+		}
+	}
+
+	return sc.planReplaced(ctx, deletes.replaced, out)
+}
+
+// scan merge directories of queue by workers until queue is done
+func (sc *Scanner) scan(
+	ctx context.Context,
+	queue *scanQueue,
+	out chan<- PlanEntry,
+	deletes *heldDeletes,
+) (err error) {
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for range max(sc.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				task, ok := queue.pop()
+				if !ok {
+					return
+				}
+
+				if wErr := sc.merge(ctx, task, queue, out, deletes); wErr != nil {
+					once.Do(func() { err = wErr })
+					queue.abort()
+					return
+				}
+				queue.done()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return err
+}
+
+// planReplaced plan source entries which replace held deletes of
+// dest entries with other type. Replaced directories are new, so
+// they are scanned without deletes
+func (sc *Scanner) planReplaced(
+	ctx context.Context,
+	replaced []heldReplace,
+	out chan<- PlanEntry,
+) (err error) {
+	queue := makeScanQueue()
+	dirs := 0
+
+	for _, r := range replaced {
+		dstPath := filepath.Join(sc.DstRoot, r.rel)
+
+		entry := PlanEntry{Op: OpCreateDir, Path: dstPath, Perm: r.src.Perm}
+		if !r.src.IsDir {
+			entry = PlanEntry{
+				Op:   OpSyncFile,
+				Pair: SyncPair{Src: filepath.Join(sc.SrcRoot, r.rel), Dst: dstPath, Perm: r.src.Perm},
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- entry:
+		}
+
+		if r.src.IsDir {
+			queue.push(scanTask{rel: r.rel})
+			dirs++
+		}
+	}
+
+	// empty queue is never closed
+	if dirs == 0 {
+		return err
+	}
+	return sc.scan(ctx, queue, out, nil)
+}
+
+// merge join src and dst listings of nested directory. Deletes
+// of missing in source entries are held if deletes is not nil
+func (sc *Scanner) merge(
+	ctx context.Context,
+	task scanTask,
+	queue *scanQueue,
+	out chan<- PlanEntry,
+	deletes *heldDeletes,
+) (err error) {
+	var srcEntries, dstEntries []ScanEntry
+
+	srcDir := filepath.Join(sc.SrcRoot, task.rel)
+	dstDir := filepath.Join(sc.DstRoot, task.rel)
+
+	if srcEntries, err = sc.lister(sc.SrcLister).List(srcDir); err != nil {
+		return err
+	}
+	srcEntries = withoutLockFile(srcEntries, srcDir, sc.SrcRoot)
+
+	if task.dstExists {
+		dstEntries, err = sc.lister(sc.DstLister).List(dstDir)
+		if err != nil {
+			return err
+		}
+		dstEntries = withoutLockFile(dstEntries, dstDir, sc.DstRoot)
+	}
+
+	// empty (e.g. unmounted) source root would wipe whole dest
+	if task.rel == "" && len(srcEntries) == 0 && len(dstEntries) > 0 &&
+		(sc.Destination == nil || !sc.Destination.NoDelete) {
+		return fmt.Errorf("%w: source root is empty", TooLargeDifferenceErr)
+	}
+
+	emit := func(e PlanEntry) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- e:
+			return nil
+		}
+	}
+
+	if deletes != nil {
+		deletes.count(len(dstEntries))
+	}
+
+	i, j := 0, 0
+	for i < len(srcEntries) || j < len(dstEntries) {
+		var src, dst *ScanEntry
+
+		switch {
+		case j >= len(dstEntries):
+			src = &srcEntries[i]
+			i++
+		case i >= len(srcEntries):
+			dst = &dstEntries[j]
+			j++
+		case srcEntries[i].Name < dstEntries[j].Name:
+			src = &srcEntries[i]
+			i++
+		case srcEntries[i].Name > dstEntries[j].Name:
+			dst = &dstEntries[j]
+			j++
+		default:
+			src, dst = &srcEntries[i], &dstEntries[j]
+			i++
+			j++
+		}
+
+		if err = sc.join(task, src, dst, queue, emit, deletes); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// join make plan entries for single name. One of src or dst can be
+// nil. Deletes of dest entries are held if deletes is not nil
+func (sc *Scanner) join(
+	task scanTask,
+	src *ScanEntry,
+	dst *ScanEntry,
+	queue *scanQueue,
+	emit func(PlanEntry) error,
+	deletes *heldDeletes,
+) (err error) {
+	var name string
+	var isDir bool
+
+	if src != nil {
+		name, isDir = src.Name, src.IsDir
+	} else {
+		name, isDir = dst.Name, dst.IsDir
+	}
+
+	rel := filepath.Join(task.rel, name)
+	if !sc.Filter.Match(rel, isDir) {
+		return err
+	}
+
+	dest := sc.Destination
+	if dest == nil {
+		dest = new(DestinationOptions)
+	}
+	srcPath := filepath.Join(sc.SrcRoot, rel)
+	dstPath := filepath.Join(sc.DstRoot, rel)
+
+	// any entry will change dst directory, except rotated sync pair
+	// that will change src directory
+	changed, changedDir := sc.DstLister, filepath.Dir(dstPath)
+	defer func() { sc.invalidate(changed, changedDir) }()
+
+	// not exists in source - delete (RemoveAll work for files too)
+	if src == nil {
+		if dest.NoDelete {
+			changed = nil
+			return err
+		}
+
+		op := OpDeleteFile
+		if dst.IsDir {
+			op = OpDeleteDir
+		}
+
+		if deletes != nil {
+			return deletes.add(PlanEntry{Op: op, Path: dstPath})
+		}
+		return emit(PlanEntry{Op: op, Path: dstPath})
+	}
+
+	// types mismatch - delete dst entry before create, held delete
+	// postpone source entry until deletes are emitted
+	if dst != nil && src.IsDir != dst.IsDir {
+		if deletes != nil {
+			return deletes.replace(PlanEntry{Op: OpDeleteDir, Path: dstPath}, rel, *src)
+		}
+
+		if err = emit(PlanEntry{Op: OpDeleteDir, Path: dstPath}); err != nil {
+			return err
+		}
+		dst = nil
+	}
+
+	if src.IsDir {
+		if dst == nil {
+			err = emit(PlanEntry{Op: OpCreateDir, Path: dstPath, Perm: src.Perm})
+			if err != nil {
+				return err
+			}
+		}
+
+		if dst != nil {
+			changed = nil
+
+			// existing directories have own changes
+			if sc.Shallow {
+				return err
+			}
+		}
+
+		queue.push(scanTask{rel: rel, dstExists: dst != nil})
+		return err
+	}
+
+	pair := SyncPair{Src: srcPath, Dst: dstPath, Perm: src.Perm}
+	if dst != nil {
+		// skip unchanged files
+		if src.ModTime.Equal(dst.ModTime) && src.Size == dst.Size {
+			changed = nil
+			return err
+		}
+
+		// dest file have newer version - rotate roots
+		if src.ModTime.Before(dst.ModTime) && !dest.OneWay {
+			pair = SyncPair{Src: dstPath, Dst: srcPath, Perm: dst.Perm}
+			changed, changedDir = sc.SrcLister, filepath.Dir(srcPath)
+		}
+	}
+
+	return emit(PlanEntry{Op: OpSyncFile, Pair: pair})
+}
+
+// lister return lister or fs lister if nil
+func (sc *Scanner) lister(l DirLister) DirLister {
+	if l == nil {
+		return osLister{}
+	}
+	return l
+}
+
+// invalidate changed directory in lister cache
+func (sc *Scanner) invalidate(l DirLister, dir string) {
+	if inv, ok := l.(invalidator); ok {
+		inv.Invalidate(dir)
+	}
+}
+
+// readEntries return directory entries sorted by name
+func readEntries(dir string) (entries []ScanEntry, err error) {
+	var files []os.DirEntry
+	var info os.FileInfo
+
+	if files, err = os.ReadDir(dir); err != nil {
+		return entries, err
+	}
+
+	entries = make([]ScanEntry, 0, len(files))
+	for _, file := range files {
+		if info, err = file.Info(); err != nil {
+			if os.IsNotExist(err) {
+				// removed while scan
+				continue
+			}
+			return entries, err
+		}
+
+		entries = append(
+			entries, ScanEntry{
+				Name:    file.Name(),
+				IsDir:   file.IsDir(),
+				ModTime: info.ModTime(),
+				Size:    info.Size(),
+				Perm:    info.Mode().Perm(),
+			},
+		)
+	}
+
+	return entries, err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// makeTree create files (with content) and directories (key ends with /)
+func makeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+
+	tm := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range tree {
+		path := filepath.Join(root, name)
+		if name[len(name)-1] == '/' {
+			require.NoError(t, os.MkdirAll(path, 0755))
+			continue
+		}
+
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, tm, tm))
+	}
+}
+
+func TestScanner_Plan(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	makeTree(
+		t, src, map[string]string{
+			"same.txt":     "same",
+			"new.txt":      "new",
+			"a/b/deep.txt": "deep",
+			"conflict/":    "",
+		},
+	)
+	makeTree(
+		t, dst, map[string]string{
+			"same.txt":     "same",
+			"stale.txt":    "stale",
+			"old/old.txt":  "old",
+			"conflict":     "file",
+			"a/b/deep.txt": "changed",
+		},
+	)
+
+	sc := &Scanner{SrcRoot: src, DstRoot: dst, Workers: 4}
+	out := make(chan PlanEntry, DefaultPlanBufferSize)
+	require.NoError(t, sc.Plan(context.Background(), out))
+
+	got := make([]string, 0)
+	for e := range out {
+		path := e.Path
+		if e.Op == OpSyncFile {
+			path = e.Pair.Dst
+		}
+		rel, err := filepath.Rel(dst, path)
+		require.NoError(t, err)
+		got = append(got, e.Op+":"+rel)
+	}
+	sort.Strings(got)
+
+	require.Equal(
+		t,
+		[]string{
+			"create_dir:conflict",
+			"delete_dir:conflict",
+			"delete_dir:old",
+			"delete_file:stale.txt",
+			"sync_file:a/b/deep.txt",
+			"sync_file:new.txt",
+		},
+		got,
+	)
+}
+
+func TestSynchronizer_SyncStream(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	makeTree(
+		t, src, map[string]string{
+			"a.txt":         "a",
+			"x/y/z/c.txt":   "c",
+			"x/y/d.txt":     "d",
+			"empty/nested/": "",
+		},
+	)
+	makeTree(t, dst, map[string]string{"b.txt": "b", "old/": ""})
+
+	s := Synchronizer{Concurrency: Concurrency{SyncFiles: 2}}
+	sc := &Scanner{SrcRoot: src, DstRoot: dst, Workers: 2}
+
+	res, err := s.SyncStream(context.Background(), sc, logrus.New())
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Failed)
+
+	// second run has nothing to do
+	res, err = s.SyncStream(context.Background(), sc, logrus.New())
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Succeeded)
+
+	content, err := os.ReadFile(filepath.Join(dst, "x/y/z/c.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "c", string(content))
+
+	require.DirExists(t, filepath.Join(dst, "empty/nested"))
+	require.NoFileExists(t, filepath.Join(dst, "b.txt"))
+	require.NoDirExists(t, filepath.Join(dst, "old"))
+}
+
+func TestScanner_PlanShallowRoot(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	makeTree(
+		t, src, map[string]string{
+			"a/f.txt":     "f",
+			"a/old/o.txt": "o",
+			"a/new/n.txt": "n",
+		},
+	)
+	makeTree(t, dst, map[string]string{"a/old/": ""})
+
+	sc := &Scanner{SrcRoot: src, DstRoot: dst, Root: "a", Shallow: true}
+	out := make(chan PlanEntry, DefaultPlanBufferSize)
+	require.NoError(t, sc.Plan(context.Background(), out))
+
+	got := make([]string, 0)
+	for e := range out {
+		path := e.Path
+		if e.Op == OpSyncFile {
+			path = e.Pair.Dst
+		}
+		rel, err := filepath.Rel(dst, path)
+		require.NoError(t, err)
+		got = append(got, e.Op+":"+rel)
+	}
+	sort.Strings(got)
+
+	// existing nested directory "old" is not scanned
+	require.Equal(
+		t,
+		[]string{
+			"create_dir:a/new",
+			"sync_file:a/f.txt",
+			"sync_file:a/new/n.txt",
+		},
+		got,
+	)
+}
+
+func TestSynchronizer_SyncStreamDeleteLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     map[string]string
+		dst     map[string]string
+		percent int
+		wantErr bool
+	}{
+		{
+			name:    "test empty source",
+			src:     map[string]string{},
+			dst:     map[string]string{"a.txt": "a", "b/c.txt": "c"},
+			wantErr: true,
+		},
+		{
+			name:    "test too many deletes",
+			src:     map[string]string{"a.txt": "a"},
+			dst:     map[string]string{"a.txt": "a", "b.txt": "b", "c/d.txt": "d"},
+			percent: 50,
+			wantErr: true,
+		},
+		{
+			name:    "test deletes under limit",
+			src:     map[string]string{"a.txt": "a", "b.txt": "b"},
+			dst:     map[string]string{"a.txt": "a", "b.txt": "b", "c/d.txt": "d"},
+			percent: 50,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				src, dst := t.TempDir(), t.TempDir()
+				makeTree(t, src, tt.src)
+				makeTree(t, dst, tt.dst)
+
+				s := Synchronizer{}
+				sc := &Scanner{SrcRoot: src, DstRoot: dst, MaxDeletePercent: tt.percent}
+
+				_, err := s.SyncStream(context.Background(), sc, logrus.New())
+				if tt.wantErr {
+					// nothing is deleted
+					require.ErrorIs(t, err, TooLargeDifferenceErr)
+					for name := range tt.dst {
+						require.FileExists(t, filepath.Join(dst, name))
+					}
+					return
+				}
+
+				require.NoError(t, err)
+				require.NoDirExists(t, filepath.Join(dst, "c"))
+			},
+		)
+	}
+}
+
+func TestSynchronizer_SyncStreamDeleteLimitReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent int
+		wantErr bool
+	}{
+		{name: "test replaces over limit", percent: 50, wantErr: true},
+		{name: "test replaces under limit", percent: 70},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				src, dst := t.TempDir(), t.TempDir()
+				makeTree(t, src, map[string]string{"a.txt": "a", "b/x.txt": "x", "c": "c"})
+				makeTree(t, dst, map[string]string{"a.txt": "a", "b": "b", "c/y.txt": "y"})
+
+				s := Synchronizer{}
+				sc := &Scanner{SrcRoot: src, DstRoot: dst, MaxDeletePercent: tt.percent}
+
+				_, err := s.SyncStream(context.Background(), sc, logrus.New())
+				if tt.wantErr {
+					// type mismatch deletes are counted too
+					require.ErrorIs(t, err, TooLargeDifferenceErr)
+					require.FileExists(t, filepath.Join(dst, "b"))
+					require.FileExists(t, filepath.Join(dst, "c", "y.txt"))
+					return
+				}
+
+				require.NoError(t, err)
+				require.FileExists(t, filepath.Join(dst, "b", "x.txt"))
+				require.FileExists(t, filepath.Join(dst, "c"))
+			},
+		)
+	}
+}
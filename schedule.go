@@ -0,0 +1,321 @@
+// contains scheduler for sync jobs configured with cron expressions
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// overlap policies - what to do if previous run not finished
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+)
+
+// job run statuses
+const (
+	JobStatusOk      = "ok"
+	JobStatusFailed  = "failed"
+	JobStatusSkipped = "skipped"
+	JobStatusQueued  = "queued"
+)
+
+// schedulerIdleDelay used if no jobs scheduled
+const schedulerIdleDelay = time.Hour
+
+var BadSyncJob = fmt.Errorf("bad sync job")
+
+// SyncJob is a named sync job scheduled with cron expression
+type SyncJob struct {
+	Name     string `yaml:"name" json:"name"`
+	Schedule string `yaml:"schedule" json:"schedule"`
+
+	SrcPath        string       `yaml:"src_path" json:"src_path"`
+	DstPath        string       `yaml:"dst_path" json:"dst_path"`
+	MaxDiffPercent int          `yaml:"max_diff_percent" json:"max_diff_percent"`
+	Mode           string       `yaml:"mode" json:"mode"`
+	Concurrency    *Concurrency `yaml:"concurrency" json:"concurrency"`
+
+	// Overlap skip (default) or queue run if previous not finished
+	Overlap string `yaml:"overlap" json:"overlap"`
+}
+
+// Request return sync request of job
+func (j SyncJob) Request() SyncDirectoriesRequest {
+	return SyncDirectoriesRequest{
+		SrcPath:        j.SrcPath,
+		DstPath:        j.DstPath,
+		MaxDiffPercent: j.MaxDiffPercent,
+		Mode:           j.Mode,
+		Concurrency:    j.Concurrency,
+	}
+}
+
+// JobSchedule contains job schedule state
+type JobSchedule struct {
+	Name       string     `json:"name"`
+	Schedule   string     `json:"schedule"`
+	Overlap    string     `json:"overlap"`
+	Running    bool       `json:"running"`
+	Queued     bool       `json:"queued"`
+	NextRun    *time.Time `json:"next_run"`
+	LastStart  *time.Time `json:"last_start"`
+	LastEnd    *time.Time `json:"last_end"`
+	LastStatus string     `json:"last_status,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Skipped    int        `json:"skipped"`
+}
+
+// scheduledJob job with parsed schedule and run state
+type scheduledJob struct {
+	job  SyncJob
+	cron *CronSchedule
+
+	next      time.Time
+	lastStart time.Time
+	lastEnd   time.Time
+	status    string
+	lastErr   string
+	running   bool
+	queued    bool
+	skipped   int
+}
+
+// Scheduler run sync jobs by cron schedules. Jobs can be
+// replaced at runtime, state of jobs with same names is kept
+type Scheduler struct {
+	lock *sync.Mutex
+	jobs map[string]*scheduledJob
+
+	// signal about changed jobs
+	wake chan struct{}
+
+	// run execute single job
+	run func(ctx context.Context, job SyncJob) error
+
+	wg  *sync.WaitGroup
+	log *logrus.Logger
+	now func() time.Time
+}
+
+// MakeScheduler factory function return new Scheduler
+func MakeScheduler(
+	jobs []SyncJob,
+	run func(ctx context.Context, job SyncJob) error,
+	log *logrus.Logger,
+) (s *Scheduler, err error) {
+	s = &Scheduler{
+		lock: new(sync.Mutex),
+		jobs: make(map[string]*scheduledJob, len(jobs)),
+		wake: make(chan struct{}, 1),
+		run:  run,
+		wg:   new(sync.WaitGroup),
+		log:  log,
+		now:  time.Now,
+	}
+
+	if err = s.Set(jobs); err != nil {
+		return nil, err
+	}
+
+	return s, err
+}
+
+// ValidateJobs check names, schedules and overlap policies of jobs
+func ValidateJobs(jobs []SyncJob) (err error) {
+	names := make(map[string]struct{}, len(jobs))
+
+	for _, job := range jobs {
+		if job.Name == "" {
+			return fmt.Errorf("%w: empty name", BadSyncJob)
+		}
+
+		if _, ok := names[job.Name]; ok {
+			return fmt.Errorf("%w: duplicated name %q", BadSyncJob, job.Name)
+		}
+		names[job.Name] = struct{}{}
+
+		if _, err = ParseCron(job.Schedule); err != nil {
+			return fmt.Errorf("%w: %q: %w", BadSyncJob, job.Name, err)
+		}
+
+		switch job.Overlap {
+		case "", OverlapSkip, OverlapQueue:
+		default:
+			return fmt.Errorf(
+				"%w: %q: unknown overlap policy %q",
+				BadSyncJob,
+				job.Name,
+				job.Overlap,
+			)
+		}
+	}
+
+	return err
+}
+
+// Set replace scheduled jobs
+func (s *Scheduler) Set(jobs []SyncJob) (err error) {
+	if err = ValidateJobs(jobs); err != nil {
+		return err
+	}
+
+	now := s.now()
+	next := make(map[string]*scheduledJob, len(jobs))
+
+	s.lock.Lock()
+	for _, job := range jobs {
+		// validated above
+		cron, _ := ParseCron(job.Schedule)
+
+		sj, ok := s.jobs[job.Name]
+		if !ok {
+			sj = new(scheduledJob)
+		}
+
+		sj.job, sj.cron = job, cron
+		sj.next = cron.Next(now)
+		next[job.Name] = sj
+	}
+	s.jobs = next
+	s.lock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return err
+}
+
+// Status return schedule state of all jobs sorted by name
+func (s *Scheduler) Status() []JobSchedule {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := make([]JobSchedule, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		overlap := sj.job.Overlap
+		if overlap == "" {
+			overlap = OverlapSkip
+		}
+
+		res = append(
+			res, JobSchedule{
+				Name:       sj.job.Name,
+				Schedule:   sj.cron.String(),
+				Overlap:    overlap,
+				Running:    sj.running,
+				Queued:     sj.queued,
+				NextRun:    timeRef(sj.next),
+				LastStart:  timeRef(sj.lastStart),
+				LastEnd:    timeRef(sj.lastEnd),
+				LastStatus: sj.status,
+				LastError:  sj.lastErr,
+				Skipped:    sj.skipped,
+			},
+		)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Run start jobs by schedule until ctx is done. Wait for running
+// jobs before return
+func (s *Scheduler) Run(ctx context.Context) (err error) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			return err
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		now := s.now()
+		var next time.Time
+
+		s.lock.Lock()
+		for _, sj := range s.jobs {
+			if !sj.next.IsZero() && !sj.next.After(now) {
+				s.start(ctx, sj)
+				sj.next = sj.cron.Next(now)
+			}
+
+			if !sj.next.IsZero() && (next.IsZero() || sj.next.Before(next)) {
+				next = sj.next
+			}
+		}
+		s.lock.Unlock()
+
+		delay := schedulerIdleDelay
+		if !next.IsZero() {
+			delay = next.Sub(now)
+		}
+		timer.Reset(delay)
+	}
+}
+
+// start run job or apply overlap policy. Must be called under lock
+func (s *Scheduler) start(ctx context.Context, sj *scheduledJob) {
+	if sj.running {
+		if sj.job.Overlap == OverlapQueue {
+			sj.queued = true
+			sj.status = JobStatusQueued
+			return
+		}
+
+		sj.skipped++
+		sj.status = JobStatusSkipped
+		s.log.WithFields(logrus.Fields{"job": sj.job.Name}).Warn(
+			"previous run not finished, run skipped",
+		)
+		return
+	}
+
+	sj.running = true
+	sj.lastStart = s.now()
+	job := sj.job
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.run(ctx, job)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		sj.running = false
+		sj.lastEnd = s.now()
+		sj.status, sj.lastErr = JobStatusOk, ""
+		if err != nil {
+			sj.status, sj.lastErr = JobStatusFailed, err.Error()
+			s.log.WithFields(
+				logrus.Fields{"job": job.Name, "error": err.Error()},
+			).Error("scheduled sync failed")
+		}
+
+		if sj.queued && ctx.Err() == nil {
+			sj.queued = false
+			s.start(ctx, sj)
+		}
+	}()
+}
+
+// timeRef return nil for zero time
+func timeRef(tm time.Time) *time.Time {
+	if tm.IsZero() {
+		return nil
+	}
+	return &tm
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestScheduler_start(t *testing.T) {
+	tests := []struct {
+		name        string
+		overlap     string
+		wantRuns    int
+		wantSkipped int
+	}{
+		{
+			name:        "test overlapped run skipped",
+			overlap:     OverlapSkip,
+			wantRuns:    1,
+			wantSkipped: 1,
+		},
+		{
+			name:     "test overlapped run queued",
+			overlap:  OverlapQueue,
+			wantRuns: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				release := make(chan struct{})
+				runs := make(chan string, 2)
+
+				job := SyncJob{Name: "job", Schedule: "@hourly", Overlap: tt.overlap}
+				s, err := MakeScheduler(
+					[]SyncJob{job},
+					func(ctx context.Context, job SyncJob) error {
+						runs <- job.Name
+						<-release
+						return nil
+					},
+					logrus.New(),
+				)
+				require.NoError(t, err)
+
+				s.lock.Lock()
+				s.start(context.Background(), s.jobs["job"])
+				s.start(context.Background(), s.jobs["job"])
+				s.lock.Unlock()
+
+				close(release)
+				s.wg.Wait()
+				s.wg.Wait()
+
+				require.Len(t, runs, tt.wantRuns)
+
+				status := s.Status()
+				require.Len(t, status, 1)
+				require.Equal(t, tt.wantSkipped, status[0].Skipped)
+				require.Equal(t, JobStatusOk, status[0].LastStatus)
+				require.NotNil(t, status[0].NextRun)
+			},
+		)
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	jobs := []SyncJob{
+		{Name: "a", Schedule: "@daily"},
+		{Name: "a", Schedule: "@hourly"},
+	}
+	require.ErrorIs(t, ValidateJobs(jobs), BadSyncJob)
+
+	jobs = []SyncJob{{Name: "a", Schedule: "@daily", Overlap: "wait"}}
+	require.ErrorIs(t, ValidateJobs(jobs), BadSyncJob)
+}
@@ -7,83 +7,137 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 var BrokenServer = fmt.Errorf("broken server")
 
-type Block struct {
-	lock   *sync.RWMutex
-	isFree bool
-}
+var ScanFailed = fmt.Errorf("scan failed")
 
-// Free return Block availability for Lock
-func (b *Block) Free() bool {
-	b.lock.RLock()
-	defer b.lock.RUnlock()
-	return b.isFree
-}
+var SyncBusy = fmt.Errorf("sync already running")
 
-// Lock try to lock. Return false if Block locked
-func (b *Block) Lock() bool {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+// Server used for handle API
+type Server struct {
+	g   *gin.Engine
+	log *logrus.Logger
+	cfg *ServerConfig
 
-	if !b.isFree {
-		return b.isFree
-	}
+	// locks of synced paths within process
+	locks *PathLocks
 
-	b.isFree = false
-	return true
-}
+	// config used on start by listeners, auth and watchers
+	boot *ServerConfig
 
-// Unlock try to unlock Block. Return true if Block unlocked
-func (b *Block) Unlock() bool {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	// shared rate limits for all sync jobs
+	throttle *Throttle
 
-	if b.isFree {
-		return b.isFree
-	}
+	// runs configured sync jobs by cron schedules
+	scheduler *Scheduler
 
-	b.isFree = true
-	return b.isFree
-}
+	// own rate limits of sync profiles
+	profileLock      *sync.RWMutex
+	profileThrottles map[string]*Throttle
 
-// Server used for handle API
-type Server struct {
-	g   *gin.Engine
-	b   *Block
-	log *logrus.Logger
-	cfg *ServerConfig
+	// restrict requested paths to allowed roots
+	guard *PathGuard
+
+	// configured authentication methods
+	auth []Authenticator
+
+	// CORS rules, updated with config
+	cors *CORS
+
+	// running and finished sync runs
+	runs    *RunRegistry
+	started time.Time
+
+	// context of sync runs started by API, runs are not stopped
+	// by client disconnect but canceled after shutdown
+	ctx  context.Context
+	stop context.CancelFunc
+
+	// systemd notifications, nil if not a notify service
+	notifier *Notifier
+
+	// metrics of sync runs and API requests
+	metrics *Metrics
 }
 
 // MakeServer factory function for create new server to handle API
-func MakeServer(cfg *ServerConfig, log *logrus.Logger, b *Block) (
+func MakeServer(cfg *ServerConfig, log *logrus.Logger) (
 	s *Server,
 	err error,
 ) {
-	if cfg == nil || log == nil || b == nil {
+	if cfg == nil || log == nil {
 		return s, fmt.Errorf(
-			"nil configuration attr: c=%p, l=%p, b=%p",
+			"nil configuration attr: c=%p, l=%p",
 			cfg,
 			log,
-			b,
 		)
 	}
 
-	return &Server{
-		b:   b,
-		log: log,
-		cfg: cfg,
-	}, err
+	throttle, err := MakeThrottle(cfg.ThrottleLimits(), cfg.ThrottleSchedule)
+	if err != nil {
+		return s, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	s = &Server{
+		ctx:              ctx,
+		stop:             stop,
+		log:              log,
+		locks:            MakePathLocks(),
+		cfg:              cfg,
+		boot:             cfg.Snapshot(),
+		throttle:         throttle,
+		runs:             MakeRunRegistry(DefaultRunHistory),
+		started:          time.Now(),
+		notifier:         MakeNotifier(os.Getenv(EnvNotifySocket)),
+		metrics:          MakeMetrics(),
+		profileLock:      new(sync.RWMutex),
+		profileThrottles: make(map[string]*Throttle, len(cfg.Profiles)),
+		guard:            MakePathGuard(cfg.Roots()),
+		cors: MakeCORS(
+			cfg.AllowedHosts,
+			cfg.AllowedMethods,
+			cfg.AllowedHeaders,
+			cfg.AllowCredentials,
+			cfg.CORSMaxAge,
+		),
+	}
+
+	s.runs.OnFinish(s.metrics.ObserveRun)
+
+	if s.auth, err = MakeAuthenticators(cfg.Auth); err != nil {
+		return nil, err
+	}
+
+	if err = s.setProfileThrottles(cfg.Profiles); err != nil {
+		return nil, err
+	}
+
+	if s.scheduler, err = MakeScheduler(cfg.Jobs, s.runJob, log); err != nil {
+		return nil, err
+	}
+
+	return s, err
 }
 
 func (srv *Server) HandleSyncCommand(c *gin.Context) {
 	var syncReq SyncDirectoriesRequest
+	var res *SyncResult
 	var err error
 
 	if srv == nil {
@@ -91,38 +145,779 @@ func (srv *Server) HandleSyncCommand(c *gin.Context) {
 			http.StatusInternalServerError,
 			BrokenServer,
 		)
+		return
 	}
 
 	// Validate request
-	if err = c.BindJSON(&syncReq); err != nil {
+	if err = c.ShouldBindJSON(&syncReq); err != nil {
 		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := srv.cfg.Snapshot()
+	if syncReq.MaxDiffPercent == 0 {
+		syncReq.MaxDiffPercent = cfg.MaxDiffPercent
 	}
 
-	if !srv.b.Lock() {
-		// sema is closed - return 409 (conflict)
-		c.AbortWithStatus(http.StatusConflict)
+	if err = ValidateRequest(&syncReq); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
+	paths, err := srv.guard.CheckPaths(syncReq.SrcPath, syncReq.DstPath)
+	if err != nil {
+		srv.abortPathError(c, err)
+		return
+	}
+	syncReq.SrcPath, syncReq.DstPath = paths[0], paths[1]
+
+	// paths used by other sync - return 409 (conflict)
+	unlock, err := srv.lockSync(syncReq.SrcPath, syncReq.DstPath)
+	if err != nil {
+		srv.abortLockError(c, err)
+		return
+	}
+	defer unlock()
+
 	// we take a lock let`s handle command
-	// ...
+	ctx, id := srv.runs.Start(
+		srv.ctx,
+		SyncRun{Kind: RunKindSync, SrcPath: syncReq.SrcPath, DstPaths: []string{syncReq.DstPath}},
+	)
+	res, err = srv.runSync(ctx, cfg, syncReq)
+	srv.runs.Finish(id, res, err)
+
+	srv.writeSyncResult(c, syncReq, res, err)
+}
+
+// HandleSyncPlan return operations which sync of directories
+// would run (dry run). Plan is built in same mode as sync
+func (srv *Server) HandleSyncPlan(c *gin.Context) {
+	var req SyncDirectoriesRequest
+	var plan SyncPlan
+	var err error
+
+	if err = c.ShouldBindJSON(&req); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := srv.cfg.Snapshot()
+	if req.MaxDiffPercent == 0 {
+		req.MaxDiffPercent = cfg.MaxDiffPercent
+	}
+
+	if err = ValidateRequest(&req); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	paths, err := srv.guard.CheckPaths(req.SrcPath, req.DstPath)
+	if err != nil {
+		srv.abortPathError(c, err)
+		return
+	}
+	req.SrcPath, req.DstPath = paths[0], paths[1]
+
+	if plan, err = srv.planSync(c.Request.Context(), cfg, req); err != nil {
+		srv.writeSyncResult(c, req, nil, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, plan)
+}
+
+// HandleFanOutSync sync one source into many destinations. Return
+// 207 (multi-status) if only part of destinations failed
+func (srv *Server) HandleFanOutSync(c *gin.Context) {
+	var req SyncFanOutRequest
+	var synchronizer Synchronizer
+	var res *FanOutResult
+	var err error
+
+	if err = c.ShouldBindJSON(&req); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := srv.cfg.Snapshot()
+	if req.MaxDiffPercent == 0 {
+		req.MaxDiffPercent = cfg.MaxDiffPercent
+	}
+
+	if err = ValidateRequest(&req); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	paths, err := srv.guard.CheckPaths(append([]string{req.SrcPath}, req.DstPaths...)...)
+	if err != nil {
+		srv.abortPathError(c, err)
+		return
+	}
+	req.SrcPath, req.DstPaths = paths[0], paths[1:]
+
+	synchronizer, err = srv.makeSynchronizer(
+		cfg,
+		SyncDirectoriesRequest{
+			SrcPath:        req.SrcPath,
+			MaxDiffPercent: req.MaxDiffPercent,
+			Concurrency:    req.Concurrency,
+		},
+	)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	fanOut := &FanOut{
+		SrcPath:      req.SrcPath,
+		DstPaths:     req.DstPaths,
+		Filter:       req.Filter,
+		NoDelete:     req.NoDelete,
+		Synchronizer: synchronizer,
+	}
+
+	if err = fanOut.Validate(); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	unlock, err := srv.lockSync(append([]string{req.SrcPath}, req.DstPaths...)...)
+	if err != nil {
+		srv.abortLockError(c, err)
+		return
+	}
+	defer unlock()
+
+	ctx, id := srv.runs.Start(
+		srv.ctx,
+		SyncRun{Kind: RunKindFanOut, SrcPath: req.SrcPath, DstPaths: req.DstPaths},
+	)
+	res, err = fanOut.Sync(ctx, srv.log)
+	if res != nil {
+		srv.runs.Finish(id, &SyncResult{Succeeded: res.Succeeded, Failed: res.Failed}, err)
+	} else {
+		srv.runs.Finish(id, nil, err)
+	}
+
+	switch {
+	case err == nil:
+		c.IndentedJSON(http.StatusOK, res)
+	case res == nil && errors.Is(err, ScanFailed):
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+	case res == nil:
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	case res.Succeeded > 0:
+		srv.log.WithFields(
+			logrus.Fields{"src": req.SrcPath, "failed": res.Failed},
+		).Warn("fan-out sync partially failed")
+		c.IndentedJSON(http.StatusMultiStatus, res)
+	default:
+		srv.log.WithFields(
+			logrus.Fields{"src": req.SrcPath, "error": err.Error()},
+		).Error("fan-out sync failed")
+		c.IndentedJSON(http.StatusInternalServerError, res)
+	}
+}
+
+// lockSync lock synced paths within process and by lock files of
+// roots. Return SyncBusy if paths are used by running sync and
+// DirLocked if used by other process
+func (srv *Server) lockSync(paths ...string) (unlock func(), err error) {
+	var unlockRoots func()
+
+	release, ok := srv.locks.TryLock(paths...)
+	if !ok {
+		return nil, SyncBusy
+	}
+
+	if unlockRoots, err = LockRoots(paths, srv.log); err != nil {
+		release()
+		return nil, err
+	}
+
+	return func() {
+		unlockRoots()
+		release()
+	}, err
+}
+
+// abortLockError abort request with 409 if synced paths are busy
+func (srv *Server) abortLockError(c *gin.Context, err error) {
+	if errors.Is(err, SyncBusy) || errors.Is(err, DirLocked) {
+		_ = c.AbortWithError(http.StatusConflict, err)
+		return
+	}
+	_ = c.AbortWithError(http.StatusInternalServerError, err)
+}
+
+// abortPathError abort request with 403 for paths outside of
+// allowed roots and 400 for others
+func (srv *Server) abortPathError(c *gin.Context, err error) {
+	if errors.Is(err, PathNotAllowed) {
+		srv.log.WithFields(
+			logrus.Fields{"client": c.ClientIP(), "error": err.Error()},
+		).Warn("requested path rejected")
+		_ = c.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	_ = c.AbortWithError(http.StatusBadRequest, err)
+}
+
+// runJob run scheduled sync job
+func (srv *Server) runJob(ctx context.Context, job SyncJob) (err error) {
+	var unlock func()
+
+	cfg := srv.cfg.Snapshot()
+	req := job.Request()
+	if req.MaxDiffPercent == 0 {
+		req.MaxDiffPercent = cfg.MaxDiffPercent
+	}
+
+	if unlock, err = srv.lockSync(req.SrcPath, req.DstPath); err != nil {
+		return err
+	}
+	defer unlock()
+
+	ctx, id := srv.runs.Start(
+		ctx,
+		SyncRun{Kind: RunKindSchedule, Name: job.Name, SrcPath: req.SrcPath, DstPaths: []string{req.DstPath}},
+	)
+	res, err := srv.runSync(ctx, cfg, req)
+	srv.runs.Finish(id, res, err)
+	return err
+}
+
+// runSync build sync plan and run synchronizer with config snapshot.
+// Caller have to take a lock
+func (srv *Server) runSync(
+	ctx context.Context,
+	cfg *ServerConfig,
+	req SyncDirectoriesRequest,
+) (res *SyncResult, err error) {
+	var synchronizer Synchronizer
+
+	if synchronizer, err = srv.makeSynchronizer(cfg, req); err != nil {
+		return res, err
+	}
+
+	return srv.execSync(ctx, cfg, req, synchronizer)
+}
+
+// execSync build sync plan and run prepared synchronizer.
+// Caller have to take a lock
+func (srv *Server) execSync(
+	ctx context.Context,
+	cfg *ServerConfig,
+	req SyncDirectoriesRequest,
+	synchronizer Synchronizer,
+) (res *SyncResult, err error) {
+	var srcMeta, dstMeta SyncMeta
+
+	switch mode := syncMode(cfg, req); mode {
+	case ModeStream:
+		scanner := streamScanner(req, synchronizer.poolSize(cfg.ScanWorkers))
+		return srv.runStream(ctx, cfg, synchronizer, scanner)
+	case ModeFull, "":
+		break
+	default:
+		return res, fmt.Errorf("%w: %s", UnknownSyncMode, mode)
+	}
+
+	srcMeta, dstMeta, err = srv.scanPaths(req.SrcPath, req.DstPath)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ScanFailed, err)
+	}
+
+	cmd := MakeSyncCommand(req.MaxDiffPercent)
+	if err = cmd.Prepare(srcMeta, dstMeta); err != nil {
+		return res, err
+	}
+	applyOptions(&cmd, req.SrcPath, req.DstPath, req.Filter, req.Destination)
+
+	return synchronizer.Sync(ctx, cmd, srv.log)
+}
+
+// syncMode return requested mode or configured if not set
+func syncMode(cfg *ServerConfig, req SyncDirectoriesRequest) string {
+	if req.Mode != "" {
+		return req.Mode
+	}
+	return cfg.SyncMode
+}
+
+// streamScanner return Scanner of request for stream mode
+func streamScanner(req SyncDirectoriesRequest, workers int) *Scanner {
+	return &Scanner{
+		SrcRoot:     req.SrcPath,
+		DstRoot:     req.DstPath,
+		Workers:     workers,
+		Filter:      req.Filter,
+		Destination: req.Destination,
+
+		MaxDeletePercent: req.MaxDiffPercent,
+	}
+}
+
+// planSync prepare sync plan without execution in mode of sync
+func (srv *Server) planSync(
+	ctx context.Context,
+	cfg *ServerConfig,
+	req SyncDirectoriesRequest,
+) (plan SyncPlan, err error) {
+	var srcMeta, dstMeta SyncMeta
+
+	switch mode := syncMode(cfg, req); mode {
+	case ModeStream:
+		return srv.planStream(ctx, cfg, req)
+	case ModeFull, "":
+		break
+	default:
+		return plan, fmt.Errorf("%w: %s", UnknownSyncMode, mode)
+	}
+
+	if srcMeta, dstMeta, err = srv.scanPaths(req.SrcPath, req.DstPath); err != nil {
+		return plan, fmt.Errorf("%w: %w", ScanFailed, err)
+	}
+
+	cmd := MakeSyncCommand(req.MaxDiffPercent)
+	if err = cmd.Prepare(srcMeta, dstMeta); err != nil {
+		return plan, err
+	}
+	applyOptions(&cmd, req.SrcPath, req.DstPath, req.Filter, req.Destination)
+
+	plan = cmd.Plan()
+	plan.SrcPath, plan.DstPath = req.SrcPath, req.DstPath
+	return plan, err
+}
+
+// planStream collect plan entries of Scanner. Metadata cache is not
+// used - scanner invalidate directories it expects to be changed
+func (srv *Server) planStream(
+	ctx context.Context,
+	cfg *ServerConfig,
+	req SyncDirectoriesRequest,
+) (plan SyncPlan, err error) {
+	var g errgroup.Group
+	var s Synchronizer
+
+	scanner := streamScanner(req, s.poolSize(cfg.ScanWorkers))
+	entries := make(chan PlanEntry, DefaultPlanBufferSize)
+	g.Go(
+		func() error {
+			return scanner.Plan(ctx, entries)
+		},
+	)
+
+	cmd := MakeSyncCommand(req.MaxDiffPercent)
+	for entry := range entries {
+		switch entry.Op {
+		case OpCreateDir:
+			cmd.DirsToCreate = append(cmd.DirsToCreate, NewDirectory{DirPath: entry.Path, DirMode: entry.Perm})
+		case OpDeleteDir:
+			cmd.DirsToDelete = append(cmd.DirsToDelete, entry.Path)
+		case OpDeleteFile:
+			dir := filepath.Dir(entry.Path)
+			cmd.FilesToDelete[dir] = append(cmd.FilesToDelete[dir], entry.Path)
+		case OpSyncFile:
+			cmd.SyncPairs = append(cmd.SyncPairs, entry.Pair)
+		}
+	}
+
+	if err = g.Wait(); err != nil {
+		return plan, err
+	}
+
+	plan = cmd.Plan()
+	plan.SrcPath, plan.DstPath = req.SrcPath, req.DstPath
+	return plan, err
+}
 
-	// unlock & panic if Block is not unlocked
-	if !srv.b.Unlock() {
-		panic("unsafe to continue - broken lock")
+// scanPaths scan both trees by HandlePaths and save scan metrics
+func (srv *Server) scanPaths(src string, dst string) (
+	srcMeta SyncMeta,
+	dstMeta SyncMeta,
+	err error,
+) {
+	start := time.Now()
+	if srcMeta, dstMeta, err = HandlePaths(src, dst); err != nil {
+		return srcMeta, dstMeta, err
+	}
+
+	srv.metrics.ObserveScan(time.Since(start), srcMeta.FilesCount(), dstMeta.FilesCount())
+	return srcMeta, dstMeta, err
+}
+
+// makeSynchronizer return Synchronizer with config snapshot settings
+func (srv *Server) makeSynchronizer(
+	cfg *ServerConfig,
+	req SyncDirectoriesRequest,
+) (s Synchronizer, err error) {
+	var policy RetryPolicy
+
+	if policy, err = cfg.RetryPolicy(); err != nil {
+		return s, err
+	}
+
+	concurrency := cfg.Concurrency
+	if req.Concurrency != nil {
+		concurrency = concurrency.Merge(*req.Concurrency)
+	}
+
+	return Synchronizer{
+		SrcDiffPercent: req.MaxDiffPercent,
+		SrcPath:        req.SrcPath,
+		DstPath:        req.DstPath,
+		Retry:          policy,
+		Throttle:       srv.throttle.ForJob(),
+		Concurrency:    concurrency,
+		Metrics:        srv.metrics,
+	}, err
+}
+
+// runStream run streaming sync with metadata cache (if cache enabled)
+func (srv *Server) runStream(
+	ctx context.Context,
+	cfg *ServerConfig,
+	synchronizer Synchronizer,
+	scanner *Scanner,
+) (res *SyncResult, err error) {
+	var srcCache, dstCache *MetaCache
+
+	if cfg.CacheDir == "" {
+		return synchronizer.SyncStream(ctx, scanner, srv.log)
+	}
+
+	if srcCache, err = OpenMetaCache(cfg.CacheDir, scanner.SrcRoot); err != nil {
+		return res, err
 	}
+
+	if dstCache, err = OpenMetaCache(cfg.CacheDir, scanner.DstRoot); err != nil {
+		return res, err
+	}
+
+	srcCache.TrustDirs, dstCache.TrustDirs = cfg.CacheTrustDirs, cfg.CacheTrustDirs
+	scanner.SrcLister, scanner.DstLister = srcCache, dstCache
+
+	res, err = synchronizer.SyncStream(ctx, scanner, srv.log)
+
+	for _, cache := range []*MetaCache{srcCache, dstCache} {
+		hits, misses := cache.Stats()
+		srv.log.WithFields(
+			logrus.Fields{
+				"root":   cache.root,
+				"hits":   hits,
+				"misses": misses,
+			},
+		).Debug("metadata cache")
+
+		// cache is an optimization - sync result is more important
+		if sErr := cache.Save(err == nil); sErr != nil {
+			srv.log.Error(sErr)
+		}
+	}
+
+	return res, err
 }
 
-// UpdateConfiguration command for update server sync configuration
+// writeSyncResult write sync result or error with matched status
+func (srv *Server) writeSyncResult(
+	c *gin.Context,
+	req SyncDirectoriesRequest,
+	res *SyncResult,
+	err error,
+) {
+	if err == nil {
+		c.IndentedJSON(http.StatusOK, res)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ScanFailed), errors.Is(err, UnknownSyncMode):
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	case errors.Is(err, TooLargeDifferenceErr):
+		_ = c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	case res == nil:
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	srv.log.WithFields(
+		logrus.Fields{
+			"src":    req.SrcPath,
+			"dst":    req.DstPath,
+			"failed": res.Failed,
+			"error":  err.Error(),
+		},
+	).Error("sync failed")
+	c.IndentedJSON(http.StatusInternalServerError, res)
+}
+
+// GetSyncLimits return configured and applied rate limits
+func (srv *Server) GetSyncLimits(c *gin.Context) {
+	base, schedule := srv.throttle.Base()
+	c.IndentedJSON(
+		http.StatusOK,
+		SyncLimitsResponse{
+			Base:     base,
+			Schedule: schedule,
+			Current:  srv.throttle.Limits(),
+		},
+	)
+}
+
+// UpdateSyncLimits update rate limits at runtime. Running
+// jobs will use new limits immediately
+func (srv *Server) UpdateSyncLimits(c *gin.Context) {
+	var req UpdateSyncLimitsRequest
+	var err error
+
+	if err = c.ShouldBindJSON(&req); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	base, schedule := srv.throttle.Base()
+	if req.BytesPerSec != nil {
+		base.BytesPerSec = *req.BytesPerSec
+	}
+
+	if req.FilesPerSec != nil {
+		base.FilesPerSec = *req.FilesPerSec
+	}
+
+	if req.WorkerBytesPerSec != nil {
+		base.WorkerBytesPerSec = *req.WorkerBytesPerSec
+	}
+
+	if req.Schedule != nil {
+		schedule = *req.Schedule
+	}
+
+	if err = srv.throttle.Set(base, schedule); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	srv.GetSyncLimits(c)
+}
+
+// GetSchedules return state of scheduled sync jobs with
+// next and last run times
+func (srv *Server) GetSchedules(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, srv.scheduler.Status())
+}
+
+// GetProfiles return configured sync profiles
+func (srv *Server) GetProfiles(c *gin.Context) {
+	profiles := srv.cfg.Snapshot().Profiles
+	if profiles == nil {
+		profiles = map[string]SyncProfile{}
+	}
+	c.IndentedJSON(http.StatusOK, profiles)
+}
+
+// HandleProfileSync run sync of named profile
+func (srv *Server) HandleProfileSync(c *gin.Context) {
+	var synchronizer Synchronizer
+	var res *SyncResult
+	var err error
+
+	cfg := srv.cfg.Snapshot()
+	name := c.Param("name")
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		_ = c.AbortWithError(
+			http.StatusNotFound,
+			fmt.Errorf("%w: %s", UnknownProfile, name),
+		)
+		return
+	}
+
+	req := profile.Request()
+	if req.MaxDiffPercent == 0 {
+		req.MaxDiffPercent = cfg.MaxDiffPercent
+	}
+
+	unlock, err := srv.lockSync(req.SrcPath, req.DstPath)
+	if err != nil {
+		srv.abortLockError(c, err)
+		return
+	}
+	defer unlock()
+
+	ctx, id := srv.runs.Start(
+		srv.ctx,
+		SyncRun{Kind: RunKindProfile, Name: name, SrcPath: req.SrcPath, DstPaths: []string{req.DstPath}},
+	)
+	if synchronizer, err = srv.makeSynchronizer(cfg, req); err == nil {
+		synchronizer.JobThrottle = srv.profileThrottle(name).ForJob()
+		res, err = srv.execSync(ctx, cfg, req, synchronizer)
+	}
+	srv.runs.Finish(id, res, err)
+	srv.writeSyncResult(c, req, res, err)
+}
+
+// GetStatus return state of daemon
+func (srv *Server) GetStatus(c *gin.Context) {
+	version, err := srv.cfg.Version()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	running := make([]SyncRun, 0, 1)
+	for _, run := range srv.runs.List() {
+		if run.State == RunStateRunning {
+			running = append(running, run)
+		}
+	}
+
+	c.IndentedJSON(
+		http.StatusOK,
+		StatusResponse{
+			Version:       Version,
+			StartedAt:     srv.started,
+			Uptime:        time.Since(srv.started).Round(time.Second).String(),
+			Busy:          srv.locks.Busy(),
+			Running:       running,
+			ConfigVersion: version,
+			ScheduledJobs: len(srv.scheduler.Status()),
+			WatchPairs:    len(srv.boot.Watch),
+		},
+	)
+}
+
+// GetMetrics return metrics in Prometheus exposition format
+func (srv *Server) GetMetrics(c *gin.Context) {
+	srv.metrics.SetRunning(srv.runs.Running())
+	srv.metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+// GetRuns return running and last finished sync runs
+func (srv *Server) GetRuns(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, srv.runs.List())
+}
+
+// GetRun return sync run by id
+func (srv *Server) GetRun(c *gin.Context) {
+	run, err := srv.runs.Get(c.Param("id"))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, run)
+}
+
+// CancelRun stop running sync run. Run state is changed when
+// sync is stopped
+func (srv *Server) CancelRun(c *gin.Context) {
+	id := c.Param("id")
+
+	err := srv.runs.Cancel(id)
+	switch {
+	case errors.Is(err, UnknownRun):
+		_ = c.AbortWithError(http.StatusNotFound, err)
+		return
+	case errors.Is(err, RunFinished):
+		_ = c.AbortWithError(http.StatusConflict, err)
+		return
+	}
+
+	srv.log.WithFields(logrus.Fields{"client": c.ClientIP(), "run": id}).Info("sync run canceled")
+	srv.GetRun(c)
+}
+
+// UpdateConfiguration apply partial config update (JSON merge patch
+// by yaml keys). Expected version is taken from If-Match header, with
+// persist=true query patched values are also saved to config file
+// (except env and flag overrides). Running jobs keep previous config
 func (srv *Server) UpdateConfiguration(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, 200)
+	var patch map[string]any
+	var changed []string
+	var persist bool
+	var err error
+
+	if err = c.ShouldBindJSON(&patch); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if persist, err = strconv.ParseBool(c.DefaultQuery("persist", "false")); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	version := strings.TrimPrefix(c.GetHeader("If-Match"), "W/")
+	if version, err = strconv.Unquote(version); err != nil {
+		// unquoted or empty header
+		version = c.GetHeader("If-Match")
+	}
+	if version == "*" {
+		version = ""
+	}
+
+	changed, err = srv.cfg.Update(patch, version, persist)
+	if err == nil {
+		err = srv.applyConfig(changed)
+	}
+
+	switch {
+	case errors.Is(err, ConfigVersionMismatch):
+		_ = c.AbortWithError(http.StatusPreconditionFailed, err)
+		return
+	case errors.Is(err, BadConfigUpdate):
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	case err != nil:
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	view, err := srv.cfg.View()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	srv.log.WithFields(
+		logrus.Fields{
+			"client":  c.ClientIP(),
+			"changed": changed,
+			"persist": persist,
+		},
+	).Info("server config updated")
+
+	c.Header("ETag", strconv.Quote(view.Version))
+	c.IndentedJSON(
+		http.StatusOK,
+		ConfigUpdateResponse{
+			ConfigResponse:  view,
+			Changed:         changed,
+			RestartRequired: RestartRequired(changed),
+		},
+	)
 }
 
+// GetCurrentConfig return effective server config with redacted
+// secrets. Config version is also returned in ETag header
 func (srv *Server) GetCurrentConfig(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, 200)
+	view, err := srv.cfg.View()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("ETag", strconv.Quote(view.Version))
+	c.IndentedJSON(http.StatusOK, view)
 }
 
 // Run server
 func (srv *Server) Run(ctx context.Context) (err error) {
+	var listeners serverListeners
+
 	if srv == nil {
 		return BrokenServer
 	}
@@ -130,39 +925,125 @@ func (srv *Server) Run(ctx context.Context) (err error) {
 	sCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// syncs still running after graceful shutdown are canceled
+	defer srv.stop()
+
 	// setup gin router
 	if err = srv.setup(); err != nil {
 		return err
 	}
 
-	addr := fmt.Sprintf("%s:%s", srv.cfg.Host, srv.cfg.Port)
+	// sockets passed by systemd replace configured ones
+	if listeners, err = srv.listen(); err != nil {
+		return err
+	}
+
+	// systemd status shows running sync jobs
+	srv.runs.OnChange(srv.notifyRuns)
+
 	server := &http.Server{
-		Addr:         addr,
 		Handler:      srv.g,
-		ReadTimeout:  srv.cfg.ConnReadTimeout,
-		WriteTimeout: srv.cfg.ConnWriteTimeout,
+		ReadTimeout:  srv.boot.ConnReadTimeout,
+		WriteTimeout: srv.boot.ConnWriteTimeout,
+	}
+
+	serve := server.Serve
+	if srv.boot.TLS.Enabled {
+		var reloader *CertReloader
+		if reloader, err = MakeCertReloader(srv.boot.TLS, srv.log); err != nil {
+			listeners.close()
+			return err
+		}
+
+		server.TLSConfig = reloader.TLSConfig()
+		serve = func(ln net.Listener) error { return server.ServeTLS(ln, "", "") }
+
+		// reload certificates on change or SIGHUP
+		go reloader.Watch(sCtx)
+		go srv.handleHangup(sCtx, reloader.Reload)
 	}
 
+	// serve Swagger UI on separate port with same TLS settings
+	var swagger *http.Server
+	if listeners.swagger != nil {
+		swagger = &http.Server{
+			Handler:      srv.swaggerHandler(),
+			ReadTimeout:  srv.boot.ConnReadTimeout,
+			WriteTimeout: srv.boot.ConnWriteTimeout,
+			TLSConfig:    server.TLSConfig,
+		}
+
+		serveSwagger := swagger.Serve
+		if srv.boot.TLS.Enabled {
+			serveSwagger = func(ln net.Listener) error { return swagger.ServeTLS(ln, "", "") }
+		}
+		go srv.serve(serveSwagger, listeners.swagger)
+	}
+
+	// reload config on SIGHUP or file change
+	go srv.handleHangup(sCtx, srv.reloadConfig)
+	if srv.boot.ConfigReloadInterval > 0 {
+		go srv.watchConfig(sCtx, srv.boot.ConfigReloadInterval)
+	}
+
+	// run scheduled jobs
 	go func() {
-		if err = server.ListenAndServe(); err != nil && !errors.Is(
-			err,
-			http.ErrServerClosed,
-		) {
-			srv.log.Error(err)
+		if sErr := srv.scheduler.Run(sCtx); sErr != nil {
+			srv.log.Error(sErr)
 		}
 	}()
 
+	// run watchers for configured pairs
+	go func() {
+		if wErr := srv.runWatchers(sCtx); wErr != nil {
+			srv.log.Error(wErr)
+		}
+	}()
+
+	// serve API on Unix socket, peer credentials are kept in
+	// context of requests
+	var unix *http.Server
+	if listeners.unix != nil {
+		unix = &http.Server{
+			Handler:      srv.g,
+			ReadTimeout:  srv.boot.ConnReadTimeout,
+			WriteTimeout: srv.boot.ConnWriteTimeout,
+			ConnContext:  peerConnContext,
+		}
+		go srv.serve(unix.Serve, listeners.unix)
+	}
+
+	if listeners.api != nil {
+		go srv.serve(serve, listeners.api)
+	}
+
+	srv.notify(NotifyReady, "STATUS="+runsStatus(srv.runs.List()))
+	go srv.notifier.Watchdog(sCtx, WatchdogInterval(os.Getenv, os.Getpid()), srv.log)
+
 	<-sCtx.Done()
 	stop()
 
 	srv.log.Debugf("shutting down gracefully, press Ctrl + C to force")
+	srv.notify(NotifyStopping, "STATUS=shutting down")
 
 	nc, cancel := context.WithTimeout(
 		context.Background(),
-		srv.cfg.GracefulShutdownTimeout,
+		srv.boot.GracefulShutdownTimeout,
 	)
 	defer cancel()
 
+	if swagger != nil {
+		if err = swagger.Shutdown(nc); err != nil {
+			return err
+		}
+	}
+
+	if unix != nil {
+		if err = unix.Shutdown(nc); err != nil {
+			return err
+		}
+	}
+
 	if err = server.Shutdown(nc); err != nil {
 		return err
 	}
@@ -171,20 +1052,297 @@ func (srv *Server) Run(ctx context.Context) (err error) {
 	return err
 }
 
+// serverListeners are listening sockets of API, Unix socket API and
+// Swagger UI, nil if not used
+type serverListeners struct {
+	api     net.Listener
+	unix    net.Listener
+	swagger net.Listener
+}
+
+func (l serverListeners) close() {
+	for _, ln := range []net.Listener{l.api, l.unix, l.swagger} {
+		if ln != nil {
+			_ = ln.Close()
+		}
+	}
+}
+
+// listen take sockets passed by systemd socket activation and open
+// configured ones which were not passed
+func (srv *Server) listen() (listeners serverListeners, err error) {
+	var activated []ActivatedListener
+
+	activated, err = ActivationListeners(os.Getenv, os.Getpid())
+
+	// sockets are not passed to child processes
+	for _, key := range []string{EnvListenPID, EnvListenFDs, EnvListenFDNames} {
+		_ = os.Unsetenv(key)
+	}
+
+	if err != nil {
+		return listeners, err
+	}
+
+	for _, a := range activated {
+		slot := &listeners.api
+		switch {
+		case a.Name == SocketNameSwagger:
+			slot = &listeners.swagger
+		case a.Listener.Addr().Network() == "unix":
+			slot = &listeners.unix
+		}
+
+		if *slot != nil {
+			listeners.close()
+			_ = a.Listener.Close()
+			return serverListeners{}, fmt.Errorf(
+				"%w: more than one socket for %s",
+				BadActivation,
+				a.Listener.Addr(),
+			)
+		}
+		*slot = a.Listener
+		srv.log.WithField("addr", a.Listener.Addr().String()).Info("socket passed by systemd")
+	}
+
+	if listeners.api == nil && !srv.boot.UnixSocket.Only {
+		addr := fmt.Sprintf("%s:%s", srv.boot.Host, srv.boot.Port)
+		if listeners.api, err = net.Listen("tcp", addr); err != nil {
+			listeners.close()
+			return serverListeners{}, err
+		}
+	}
+
+	if listeners.unix == nil && srv.boot.UnixSocket.Path != "" {
+		if listeners.unix, err = ListenUnix(srv.boot.UnixSocket); err != nil {
+			listeners.close()
+			return serverListeners{}, err
+		}
+	}
+
+	if listeners.swagger == nil && srv.boot.SwaggerEnabled {
+		addr := fmt.Sprintf("%s:%s", srv.boot.Host, srv.boot.SwaggerPort)
+		if listeners.swagger, err = net.Listen("tcp", addr); err != nil {
+			listeners.close()
+			return serverListeners{}, err
+		}
+	}
+
+	return listeners, err
+}
+
+// serve run server on listener and log unexpected errors
+func (srv *Server) serve(serve func(net.Listener) error, ln net.Listener) {
+	if err := serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		srv.log.Error(err)
+	}
+}
+
+// notify send states to systemd, errors are logged
+func (srv *Server) notify(states ...string) {
+	if err := srv.notifier.Notify(states...); err != nil {
+		srv.log.WithField("error", err.Error()).Warn("systemd notify failed")
+	}
+}
+
+// notifyRuns send running sync jobs as systemd status
+func (srv *Server) notifyRuns() {
+	if srv.notifier != nil {
+		srv.notify("STATUS=" + runsStatus(srv.runs.List()))
+	}
+}
+
+// reloadConfig load config file again and apply changed values.
+// Invalid config is rejected, running config stay untouched
+func (srv *Server) reloadConfig() (err error) {
+	var changed []string
+
+	if changed, err = srv.cfg.Reload(); err != nil {
+		return err
+	}
+
+	if err = srv.applyConfig(changed); err != nil {
+		return err
+	}
+
+	srv.log.WithField("changed", changed).Info("server config reloaded")
+	return err
+}
+
+// watchConfig reload config on file change until ctx is done
+func (srv *Server) watchConfig(ctx context.Context, interval time.Duration) {
+	var modTime time.Time
+
+	if info, err := os.Stat(srv.cfg.Path()); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(srv.cfg.Path())
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			if err = srv.reloadConfig(); err != nil {
+				srv.log.WithField("error", err.Error()).Error("config reload failed")
+			}
+		}
+	}
+}
+
+// applyConfig apply changed keys of current config to logger,
+// limits, profiles, schedules and CORS. Other keys require restart
+func (srv *Server) applyConfig(changed []string) (err error) {
+	cfg := srv.cfg.Snapshot()
+	has := func(keys ...string) bool {
+		for _, key := range keys {
+			if slices.Contains(changed, key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has("log_level", "time_format") {
+		var level logrus.Level
+		if level, err = convertLogLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+
+		srv.log.SetLevel(level)
+		srv.log.SetFormatter(&logrus.JSONFormatter{TimestampFormat: cfg.TimeFormat})
+	}
+
+	if has("bytes_per_sec", "files_per_sec", "worker_bytes_per_sec", "throttle_schedule") {
+		if err = srv.throttle.Set(cfg.ThrottleLimits(), cfg.ThrottleSchedule); err != nil {
+			return err
+		}
+	}
+
+	if has("profiles") {
+		if err = srv.setProfileThrottles(cfg.Profiles); err != nil {
+			return err
+		}
+	}
+
+	if has("jobs") {
+		if err = srv.scheduler.Set(cfg.Jobs); err != nil {
+			return err
+		}
+	}
+
+	if has("allowed_hosts", "allowed_methods", "allowed_headers", "allow_credentials", "cors_max_age") {
+		srv.cors.Set(
+			cfg.AllowedHosts,
+			cfg.AllowedMethods,
+			cfg.AllowedHeaders,
+			cfg.AllowCredentials,
+			cfg.CORSMaxAge,
+		)
+	}
+
+	if restart := RestartRequired(changed); len(restart) > 0 {
+		srv.log.WithField("keys", restart).Warn("config changes require restart")
+	}
+
+	return err
+}
+
+// setProfileThrottles update rate limits of profiles. Limits of
+// existing profiles are changed in place, so running jobs use
+// them immediately
+func (srv *Server) setProfileThrottles(profiles map[string]SyncProfile) (err error) {
+	throttles := make(map[string]*Throttle, len(profiles))
+
+	srv.profileLock.Lock()
+	defer srv.profileLock.Unlock()
+
+	for name, profile := range profiles {
+		if profile.Limits == nil {
+			continue
+		}
+
+		if t, ok := srv.profileThrottles[name]; ok {
+			if err = t.Set(*profile.Limits, nil); err != nil {
+				return err
+			}
+			throttles[name] = t
+			continue
+		}
+
+		if throttles[name], err = MakeThrottle(*profile.Limits, nil); err != nil {
+			return err
+		}
+	}
+
+	srv.profileThrottles = throttles
+	return err
+}
+
+// profileThrottle return own rate limits of profile (nil if not set)
+func (srv *Server) profileThrottle(name string) *Throttle {
+	srv.profileLock.RLock()
+	defer srv.profileLock.RUnlock()
+	return srv.profileThrottles[name]
+}
+
+// handleHangup call reload on each SIGHUP until ctx is done
+func (srv *Server) handleHangup(ctx context.Context, reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reload(); err != nil {
+				srv.log.WithField("error", err.Error()).Error("reload failed")
+				continue
+			}
+			srv.log.Info("reloaded on SIGHUP")
+		}
+	}
+}
+
 func (srv *Server) setup() (err error) {
 	// set loglevel for gin
 	gin.SetMode(gin.DebugMode)
 
 	srv.g = gin.Default()
 
-	// register sync handler
-	srv.g.PATCH("/api/v1/sync/directories", srv.HandleSyncCommand)
+	// all requests are counted, rejected ones too
+	srv.g.Use(srv.metrics.Handle)
+
+	// CORS go before auth - preflight requests have no credentials
+	srv.g.Use(srv.cors.Handle)
 
-	// register handler for update server config
-	srv.g.PATCH("/api/v1/server/config/update", srv.UpdateConfiguration)
+	// every API call must be authenticated, routes are
+	// documented in OpenAPI document
+	api := srv.g.Group(apiPrefix, srv.authenticate)
+	for _, r := range srv.routes() {
+		api.Handle(r.Method, r.Path, srv.require(r.Role), r.Handler)
+	}
 
-	// register handler for return actual server config
-	srv.g.GET("/api/v1/server/config", srv.GetCurrentConfig)
+	// metrics are served on root for scrapers, public metrics
+	// do not require credentials
+	if srv.boot.MetricsEnabled {
+		handlers := []gin.HandlerFunc{srv.GetMetrics}
+		if !srv.boot.MetricsPublic {
+			handlers = []gin.HandlerFunc{srv.authenticate, srv.require(RoleRead), srv.GetMetrics}
+		}
+		srv.g.GET(metricsPath, handlers...)
+	}
 
 	return err
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer_reloadConfig(t *testing.T) {
+	cfg := loadConfig(t, testConfig)
+
+	srv, err := MakeServer(cfg, logrus.New())
+	require.NoError(t, err)
+
+	update := strings.NewReplacer(
+		"port: 6767", "port: 7000",
+		"log_level: info", "log_level: debug",
+		"a.example.com", "b.example.com",
+	).Replace(testConfig) + `
+bytes_per_sec: 1024
+jobs:
+  - name: nightly
+    schedule: "0 3 * * *"
+    src_path: /srv/a
+    dst_path: /srv/b
+`
+	require.NoError(t, os.WriteFile(cfg.Path(), []byte(update), 0600))
+	require.NoError(t, srv.reloadConfig())
+
+	require.Equal(t, logrus.DebugLevel, srv.log.GetLevel())
+	require.Equal(t, int64(1024), srv.throttle.Limits().BytesPerSec)
+	require.Len(t, srv.scheduler.Status(), 1)
+
+	r := httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "https://b.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	require.NoError(t, srv.setup())
+	srv.g.ServeHTTP(w, r)
+	require.Equal(t, "https://b.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// listeners keep start config until restart
+	require.Equal(t, "6767", srv.boot.Port)
+	require.Equal(t, "7000", srv.cfg.Snapshot().Port)
+
+	// broken config is rejected
+	require.NoError(t, os.WriteFile(cfg.Path(), []byte("bytes_per_sec: -1\n"), 0600))
+	require.Error(t, srv.reloadConfig())
+	require.Equal(t, int64(1024), srv.cfg.Snapshot().BytesPerSec)
+}
+
+func TestServer_HandleSyncCommand_clientGone(t *testing.T) {
+	_, srv := testDaemon(t)
+	src, dst := srv.boot.SrcPath, srv.boot.DstPath
+	writeSyncFiles(t, src, dst)
+
+	body, err := json.Marshal(SyncDirectoriesRequest{SrcPath: src, DstPath: dst, MaxDiffPercent: 100})
+	require.NoError(t, err)
+
+	// client disconnected before sync was started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := httptest.NewRequestWithContext(ctx, http.MethodPatch, apiPrefix+"/sync/directories", bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.g.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.FileExists(t, filepath.Join(dst, "a.txt"))
+	require.Equal(t, JobStatusOk, srv.runs.List()[0].State)
+}
+
+func TestServer_HandleSyncPlan(t *testing.T) {
+	tests := []struct {
+		mode     string
+		wantSync []string
+	}{
+		{mode: ModeFull, wantSync: []string{"a.txt", "b.txt"}},
+		// unchanged files are skipped by scanner
+		{mode: ModeStream, wantSync: []string{"a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			"test "+tt.mode+" mode", func(t *testing.T) {
+				_, srv := testDaemon(t)
+				src, dst := srv.boot.SrcPath, srv.boot.DstPath
+				makeTree(t, src, map[string]string{"a.txt": "a", "b.txt": "b"})
+				makeTree(t, dst, map[string]string{"b.txt": "b", "old.txt": "old"})
+
+				body, err := json.Marshal(
+					SyncDirectoriesRequest{SrcPath: src, DstPath: dst, MaxDiffPercent: 100, Mode: tt.mode},
+				)
+				require.NoError(t, err)
+
+				r := httptest.NewRequest(http.MethodPost, apiPrefix+"/sync/plan", bytes.NewReader(body))
+				r.Header.Set("Authorization", "Bearer secret-token")
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				srv.g.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+
+				var plan SyncPlan
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &plan))
+
+				want := make([]string, 0, len(tt.wantSync))
+				for _, name := range tt.wantSync {
+					want = append(want, filepath.Join(dst, name))
+				}
+				require.Equal(t, want, plan.SyncFiles)
+				require.Equal(t, []string{filepath.Join(dst, "old.txt")}, plan.DeleteFiles)
+
+				// nothing is changed by dry run
+				require.NoFileExists(t, filepath.Join(dst, "a.txt"))
+			},
+		)
+	}
+}
+
+func TestServer_HandleSyncCommand_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		body func(src string, dst string) map[string]any
+		want int
+	}{
+		{
+			name: "test max diff from config",
+			body: func(src string, dst string) map[string]any {
+				return map[string]any{"src_path": src, "dst_path": dst}
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "test max diff out of range",
+			body: func(src string, dst string) map[string]any {
+				return map[string]any{"src_path": src, "dst_path": dst, "max_diff_percent": 150}
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "test no dst path",
+			body: func(src string, dst string) map[string]any {
+				return map[string]any{"src_path": src}
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "test negative concurrency",
+			body: func(src string, dst string) map[string]any {
+				return map[string]any{"src_path": src, "dst_path": dst, "concurrency": map[string]any{"sync_files": -1}}
+			},
+			want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, srv := testDaemon(t)
+				src, dst := srv.boot.SrcPath, srv.boot.DstPath
+				makeTree(t, src, map[string]string{"a.txt": "a"})
+				makeTree(t, dst, map[string]string{"a.txt": "a"})
+
+				body, err := json.Marshal(tt.body(src, dst))
+				require.NoError(t, err)
+
+				r := httptest.NewRequest(http.MethodPatch, apiPrefix+"/sync/directories", bytes.NewReader(body))
+				r.Header.Set("Authorization", "Bearer secret-token")
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				srv.g.ServeHTTP(w, r)
+				require.Equal(t, tt.want, w.Code)
+			},
+		)
+	}
+}
+
+func TestServer_HandleFanOutSync_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		dstPaths func(dst string) []string
+		want     int
+	}{
+		{name: "test max diff from config", dstPaths: func(dst string) []string { return []string{dst} }, want: http.StatusOK},
+		{name: "test no dst paths", dstPaths: func(string) []string { return []string{} }, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, srv := testDaemon(t)
+				src, dst := srv.boot.SrcPath, srv.boot.DstPath
+				makeTree(t, src, map[string]string{"a.txt": "a"})
+				makeTree(t, dst, map[string]string{"a.txt": "a"})
+
+				body, err := json.Marshal(map[string]any{"src_path": src, "dst_paths": tt.dstPaths(dst)})
+				require.NoError(t, err)
+
+				r := httptest.NewRequest(http.MethodPatch, apiPrefix+"/sync/fanout", bytes.NewReader(body))
+				r.Header.Set("Authorization", "Bearer secret-token")
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				srv.g.ServeHTTP(w, r)
+				require.Equal(t, tt.want, w.Code, w.Body.String())
+			},
+		)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+)
+
+// listenSocket create Unix socket (no umask on this platform)
+func listenSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
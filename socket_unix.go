@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketUmask leave socket file accessible by owner only until
+// configured mode is applied
+const socketUmask = 0o177
+
+// listenSocket create Unix socket under restrictive umask, so other
+// users can`t connect before mode and owner are changed. Umask is
+// process wide - listeners are created on start before any sync
+func listenSocket(path string) (net.Listener, error) {
+	old := syscall.Umask(socketUmask)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
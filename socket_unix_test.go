@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenSocket(t *testing.T) {
+	// permissive umask of process is not applied to socket
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(t.TempDir(), "fsyncd.sock")
+	ln, err := listenSocket(path)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// umask of process is restored
+	require.Equal(t, 0, syscall.Umask(0))
+}
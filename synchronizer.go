@@ -3,16 +3,84 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"io"
 	"io/fs"
 	"os"
 	"runtime"
+	"sync"
+	"time"
+)
+
+// operations names for items results
+const (
+	OpDeleteDir  = "delete_dir"
+	OpDeleteFile = "delete_file"
+	OpCreateDir  = "create_dir"
+	OpSyncFile   = "sync_file"
 )
 
 type ItemHandler func(string) error
 
+// ItemResult contains outcome of single item operation
+type ItemResult struct {
+	Op       string `json:"op"`
+	Path     string `json:"path"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SyncResult collect results of sync job. Items contain only
+// items which were retried or failed
+type SyncResult struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Retried   int          `json:"retried"`
+	Items     []ItemResult `json:"items"`
+
+	lock *sync.Mutex
+}
+
+// MakeSyncResult factory function return new SyncResult
+func MakeSyncResult() *SyncResult {
+	return &SyncResult{
+		Items: make([]ItemResult, 0, DefaultSyncObjectsSize),
+		lock:  new(sync.Mutex),
+	}
+}
+
+// Add save item operation outcome (concurrent safe)
+func (r *SyncResult) Add(op string, path string, attempts int, err error) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err == nil {
+		r.Succeeded++
+	} else {
+		r.Failed++
+	}
+
+	if attempts > 1 {
+		r.Retried++
+	}
+
+	if err == nil && attempts < 2 {
+		return
+	}
+
+	item := ItemResult{Op: op, Path: path, Attempts: attempts}
+	if err != nil {
+		item.Error = err.Error()
+	}
+	r.Items = append(r.Items, item)
+}
+
 // Synchronizer for sync command parameters
 type Synchronizer struct {
 	// wished difference percent between src and dest root directories
@@ -23,48 +91,131 @@ type Synchronizer struct {
 
 	// root path to dest directory
 	DstPath string
+
+	// Retry policy for transient errors on each item
+	Retry RetryPolicy
+
+	// Throttle rate limits of job (nil - unlimited)
+	Throttle *Throttle
+
+	// JobThrottle limits of profile applied in addition
+	// to Throttle (nil - unlimited)
+	JobThrottle *Throttle
+
+	// Concurrency workers count for each phase
+	Concurrency Concurrency
+
+	// Metrics of items and worker pools (nil - not collected)
+	Metrics *Metrics
+
+	result *SyncResult
 }
 
-// Sync start sync operation
+// Sync start sync operation. Return per item results
 func (s *Synchronizer) Sync(
 	ctx context.Context,
 	syncCmd SyncCommand,
 	log *logrus.Logger,
-) (err error) {
-	gp := s.CalculatePoolSize()
+) (res *SyncResult, err error) {
+	conc := s.Concurrency
+
+	s.result = MakeSyncResult()
+	res = s.result
 
 	// delete directories
-	if err = s.DeleteDirectories(ctx, syncCmd, gp); err != nil {
-		return err
+	err = s.DeleteDirectories(ctx, syncCmd, s.poolSize(conc.DeleteDirs))
+	if err != nil {
+		return res, err
 	}
 
 	// delete files
-	if err = s.DeleteFiles(ctx, syncCmd, gp); err != nil {
-		return err
+	err = s.DeleteFiles(ctx, syncCmd, s.poolSize(conc.DeleteFiles))
+	if err != nil {
+		return res, err
 	}
 
 	// create directories
-	if err = s.CreateDirectories(ctx, syncCmd, gp); err != nil {
-		return err
+	err = s.CreateDirectories(ctx, syncCmd, s.poolSize(conc.CreateDirs))
+	if err != nil {
+		return res, err
 	}
 
 	// sync files
-	if err = s.SyncFiles(ctx, log, syncCmd, gp); err != nil {
-		return err
+	err = s.SyncFiles(ctx, log, syncCmd, s.poolSize(conc.SyncFiles))
+	if err != nil {
+		return res, err
 	}
 
+	return res, err
+}
+
+// SyncStream start sync operation while plan is built by scanner.
+// Return per item results
+func (s *Synchronizer) SyncStream(
+	ctx context.Context,
+	sc *Scanner,
+	log *logrus.Logger,
+) (res *SyncResult, err error) {
+	var g errgroup.Group
+
+	s.result = MakeSyncResult()
+	res = s.result
+
+	pCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	entries := make(chan PlanEntry, DefaultPlanBufferSize)
+	g.Go(
+		func() error {
+			return sc.Plan(pCtx, entries)
+		},
+	)
+
+	err = s.handlePlanEntries(ctx, log, entries)
+
+	// stop scanner if entries handling was interrupted
+	cancel()
+	if pErr := g.Wait(); err == nil {
+		err = pErr
+	}
+
+	return res, err
+}
+
+// retry call op with retry policy and save item result
+func (s *Synchronizer) retry(
+	ctx context.Context,
+	op string,
+	path string,
+	call func() error,
+) (err error) {
+	var attempts int
+
+	attempts, err = s.Retry.Do(ctx, call)
+	s.addResult(op, path, attempts, err)
 	return err
 }
 
+// addResult save item outcome into result and metrics
+func (s *Synchronizer) addResult(op string, path string, attempts int, err error) {
+	s.result.Add(op, path, attempts, err)
+	s.Metrics.ObserveItem(op, err)
+}
+
 // DeleteDirectories delete all wished directories from dest concurrently
 func (s *Synchronizer) DeleteDirectories(
 	ctx context.Context,
 	syncCmd SyncCommand,
 	concurrencyLim int,
 ) (err error) {
-	deleteDir := func(str string) error { return s.deleteDir(str) }
+	deleteDir := func(str string) error {
+		return s.retry(
+			ctx, OpDeleteDir, str, func() error { return s.deleteDir(str) },
+		)
+	}
 	return s.handleItems(
 		ctx,
+		OpDeleteDir,
 		syncCmd.DirsToDelete,
 		concurrencyLim,
 		deleteDir,
@@ -77,16 +228,20 @@ func (s *Synchronizer) DeleteFiles(
 	syncCmd SyncCommand,
 	concurrencyLim int,
 ) (err error) {
-	for _, files := range syncCmd.FilesToDelete {
-		funcCall := func(str string) error { return s.deleteFile(str) }
-		err = s.handleItems(
-			ctx, files, concurrencyLim, funcCall,
-		)
-		if err != nil {
+	files := make([]string, 0, len(syncCmd.FilesToDelete))
+	for _, paths := range syncCmd.FilesToDelete {
+		files = append(files, paths...)
+	}
+
+	funcCall := func(str string) error {
+		if err := s.waitFile(ctx); err != nil {
 			return err
 		}
+		return s.retry(
+			ctx, OpDeleteFile, str, func() error { return s.deleteFile(str) },
+		)
 	}
-	return err
+	return s.handleItems(ctx, OpDeleteFile, files, concurrencyLim, funcCall)
 }
 
 // CreateDirectories create all needed directories in dest concurrently
@@ -102,43 +257,130 @@ func (s *Synchronizer) CreateDirectories(
 	)
 }
 
-// syncPair sync files pair
+// syncPair sync files pair under rate limiter of worker. Return
+// written bytes count
 func (s *Synchronizer) syncPair(
 	ctx context.Context,
 	log *logrus.Logger,
 	pair SyncPair,
-) (err error) {
-	var srcFile, dstFile io.ReadWriteCloser
+	worker *RateLimiter,
+) (written int64, err error) {
+	var srcFile, dstFile *os.File
+	var info os.FileInfo
+
+	start := time.Now()
+	defer func() {
+		s.Metrics.ObserveOperation(OpSyncFile, time.Since(start))
+		s.Metrics.AddBytes(OpSyncFile, written)
+	}()
 
 	// open src (take permissions from sync pair)
 	srcFile, err = os.OpenFile(pair.Src, os.O_RDONLY, pair.Perm)
 	if err != nil {
-		return err
+		return written, err
 	}
 
 	defer s.fclose(log, srcFile)
 
+	if info, err = srcFile.Stat(); err != nil {
+		return written, err
+	}
+
 	// open dst (create file if not exists)
-	dstFile, err = os.OpenFile(pair.Src, os.O_CREATE|os.O_RDWR, pair.Perm)
+	dstFile, err = os.OpenFile(
+		pair.Dst,
+		os.O_CREATE|os.O_RDWR|os.O_TRUNC,
+		pair.Perm,
+	)
 	if err != nil {
-		return err
+		return written, err
 	}
 
+	// partially copied file of canceled copy is removed (after
+	// close) to be synced again by next run
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			_ = os.Remove(pair.Dst)
+		}
+	}()
 	defer s.fclose(log, dstFile)
 
 	// handle ctx or signal (graceful shutdown)
-	// later we can`t stop operation - it may break file...
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return written, ctx.Err()
 	default:
 		break
 	}
 
 	// alloc buffer if files opened
-	buf := make([]byte, DefaultBufferSize)
-	_, err = io.CopyBuffer(dstFile, srcFile, buf)
-	return err
+	if s.Throttle == nil && s.JobThrottle == nil {
+		written, err = io.CopyBuffer(dstFile, srcFile, make([]byte, DefaultBufferSize))
+	} else {
+		written, err = s.copyThrottled(ctx, dstFile, srcFile, make([]byte, MaxThrottleSlice), worker)
+	}
+
+	if err != nil {
+		return written, err
+	}
+
+	// dest inherit modification time to skip unchanged files later
+	return written, os.Chtimes(pair.Dst, time.Time{}, info.ModTime())
+}
+
+// copyThrottled copy src into dst under throttle limits. Bytes are
+// read and reserved by slices sized by current limits, worker limiter
+// rate follows them too. Waits are interrupted by ctx
+func (s *Synchronizer) copyThrottled(
+	ctx context.Context,
+	dst io.Writer,
+	src io.Reader,
+	buf []byte,
+	worker *RateLimiter,
+) (written int64, err error) {
+	var n, slice int
+	var rate int64
+
+	for {
+		slice, rate = throttleSlice(s.Throttle, s.JobThrottle)
+		n, err = src.Read(buf[:min(slice, len(buf))])
+		if n > 0 {
+			if wErr := s.Throttle.WaitBytes(ctx, int64(n)); wErr != nil {
+				return written, wErr
+			}
+
+			if wErr := s.JobThrottle.WaitBytes(ctx, int64(n)); wErr != nil {
+				return written, wErr
+			}
+
+			worker.SetRate(rate)
+			if wErr := worker.WaitN(ctx, int64(n)); wErr != nil {
+				return written, wErr
+			}
+
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				return written, wErr
+			}
+			written += int64(n)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// waitFile block until next file operation allowed by shared
+// and job limits
+func (s *Synchronizer) waitFile(ctx context.Context) (err error) {
+	if err = s.Throttle.WaitFile(ctx); err != nil {
+		return err
+	}
+	return s.JobThrottle.WaitFile(ctx)
 }
 
 // SyncFiles sync all pairs between source and dest
@@ -160,8 +402,16 @@ func (s *Synchronizer) SyncFiles(
 // Returns:
 //   - err: if any error returns
 func (s *Synchronizer) deleteFile(file string) (err error) {
-	if _, err = os.Stat(file); err == nil {
-		return os.Remove(file)
+	var info os.FileInfo
+
+	start := time.Now()
+	defer func() { s.Metrics.ObserveOperation(OpDeleteFile, time.Since(start)) }()
+
+	if info, err = os.Stat(file); err == nil {
+		if err = os.Remove(file); err == nil {
+			s.Metrics.AddBytes(OpDeleteFile, info.Size())
+		}
+		return err
 	}
 	if err != nil && os.IsNotExist(err) {
 		// if file not exists - no error
@@ -184,6 +434,14 @@ func (s *Synchronizer) createDirs(
 	return os.MkdirAll(root, perm)
 }
 
+// poolSize return configured workers count or default pool size
+func (s *Synchronizer) poolSize(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return s.CalculatePoolSize()
+}
+
 // CalculatePoolSize for disk io bound tasks
 func (s *Synchronizer) CalculatePoolSize() int {
 	cc := runtime.NumCPU()
@@ -224,30 +482,32 @@ func HandlePaths(src string, dst string) (
 // handleItems is a concurrent runner that start goroutines pool inside
 func (s *Synchronizer) handleItems(
 	ctx context.Context,
+	phase string,
 	items []string,
 	concurrencyLim int,
 	handler ItemHandler,
 ) (err error) {
-	var g *errgroup.Group
-
-	tokens := make(chan struct{}, concurrencyLim)
+	g := new(errgroup.Group)
+	pool, tuner := s.makePool(concurrencyLim)
+	defer s.Metrics.TrackPool(phase, pool)()
 
 	for _, item := range items {
-		select {
-		case <-ctx.Done():
-			goto out
-		case <-tokens:
-			g.Go(
-				func() error {
-					err = handler(item)
-					tokens <- struct{}{}
-					return err
-				},
-			)
+		if pool.Acquire(ctx) != nil {
+			break
 		}
+
+		g.Go(
+			func() error {
+				defer pool.Release()
+
+				start := time.Now()
+				err := handler(item)
+				tuner.Observe(0, time.Since(start))
+				return err
+			},
+		)
 	}
 
-out:
 	// wail for all running tasks
 	if err = g.Wait(); err != nil {
 		return err
@@ -262,25 +522,43 @@ func (s *Synchronizer) handleFilePairs(
 	pairs []SyncPair,
 	concurrencyLim int,
 ) (err error) {
-	var g *errgroup.Group
-
-	tokens := make(chan struct{}, concurrencyLim)
+	g := new(errgroup.Group)
+	pool, tuner := s.makePool(concurrencyLim)
+	defer s.Metrics.TrackPool(OpSyncFile, pool)()
 
 	for _, pair := range pairs {
-		select {
-		case <-ctx.Done():
-			goto out
-		case <-tokens:
-			g.Go(
-				func() error {
-					err = s.syncPair(ctx, log, pair)
-					tokens <- struct{}{}
-					return err
-				},
-			)
+		if pool.Acquire(ctx) != nil {
+			break
 		}
+
+		g.Go(
+			func() error {
+				var written int64
+
+				defer pool.Release()
+				worker := pool.TakeLimiter()
+				defer pool.PutLimiter(worker)
+
+				if err := s.waitFile(ctx); err != nil {
+					return err
+				}
+
+				start := time.Now()
+				err := s.retry(
+					ctx,
+					OpSyncFile,
+					pair.Dst,
+					func() (err error) {
+						written, err = s.syncPair(ctx, log, pair, worker)
+						return err
+					},
+				)
+				tuner.Observe(written, time.Since(start))
+				return err
+			},
+		)
 	}
-out:
+
 	if err = g.Wait(); err != nil {
 		return err
 	}
@@ -293,25 +571,32 @@ func (s *Synchronizer) handleNewDirectories(
 	newDirs []NewDirectory,
 	concurrencyLim int,
 ) (err error) {
-	var g *errgroup.Group
-
-	tokens := make(chan struct{}, concurrencyLim)
+	g := new(errgroup.Group)
+	pool, tuner := s.makePool(concurrencyLim)
+	defer s.Metrics.TrackPool(OpCreateDir, pool)()
 
 	for _, nd := range newDirs {
-		select {
-		case <-ctx.Done():
-			goto out
-		case <-tokens:
-			g.Go(
-				func() error {
-					err = s.createDirs(nd.DirPath, nd.DirMode)
-					tokens <- struct{}{}
-					return err
-				},
-			)
+		if pool.Acquire(ctx) != nil {
+			break
 		}
+
+		g.Go(
+			func() error {
+				defer pool.Release()
+
+				start := time.Now()
+				err := s.retry(
+					ctx,
+					OpCreateDir,
+					nd.DirPath,
+					func() error { return s.createDirs(nd.DirPath, nd.DirMode) },
+				)
+				tuner.Observe(0, time.Since(start))
+				return err
+			},
+		)
 	}
-out:
+
 	if err = g.Wait(); err != nil {
 		return err
 	}
@@ -319,6 +604,99 @@ out:
 	return err
 }
 
+// handlePlanEntries execute plan entries. Directories are created and
+// deleted in order of entries, files are handled concurrently
+func (s *Synchronizer) handlePlanEntries(
+	ctx context.Context,
+	log *logrus.Logger,
+	entries <-chan PlanEntry,
+) (err error) {
+	g := new(errgroup.Group)
+	pool, tuner := s.makePool(s.poolSize(s.Concurrency.SyncFiles))
+	defer s.Metrics.TrackPool(OpSyncFile, pool)()
+
+	for entry := range entries {
+		switch entry.Op {
+		case OpCreateDir:
+			e := s.retry(
+				ctx,
+				OpCreateDir,
+				entry.Path,
+				func() error { return s.createDirs(entry.Path, entry.Perm) },
+			)
+			if err == nil {
+				err = e
+			}
+			continue
+		case OpDeleteDir:
+			e := s.retry(
+				ctx,
+				OpDeleteDir,
+				entry.Path,
+				func() error { return s.deleteDir(entry.Path) },
+			)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+
+		if pool.Acquire(ctx) != nil {
+			break
+		}
+
+		g.Go(
+			func() error {
+				var written int64
+
+				defer pool.Release()
+				worker := pool.TakeLimiter()
+				defer pool.PutLimiter(worker)
+
+				if err := s.waitFile(ctx); err != nil {
+					return err
+				}
+
+				start := time.Now()
+				call := func() (err error) {
+					written, err = s.syncPair(ctx, log, entry.Pair, worker)
+					return err
+				}
+
+				path := entry.Pair.Dst
+				if entry.Op == OpDeleteFile {
+					path = entry.Path
+					call = func() error { return s.deleteFile(entry.Path) }
+				}
+
+				err := s.retry(ctx, entry.Op, path, call)
+				tuner.Observe(written, time.Since(start))
+				return err
+			},
+		)
+	}
+
+	if gErr := g.Wait(); err == nil {
+		err = gErr
+	}
+
+	return err
+}
+
+// makePool return worker pool for phase. Tuner is nil if
+// adaptive mode is off
+func (s *Synchronizer) makePool(concurrencyLim int) (
+	*WorkerPool,
+	*AdaptiveTuner,
+) {
+	pool := MakeWorkerPool(concurrencyLim)
+	if !s.Concurrency.Adaptive {
+		return pool, nil
+	}
+
+	return pool, MakeAdaptiveTuner(pool, s.Concurrency.MaxWorkers)
+}
+
 // fclose internal function for deferred error handling from closed files.
 // Can close readers and writers
 func (s *Synchronizer) fclose(log *logrus.Logger, file io.ReadWriteCloser) {
@@ -0,0 +1,193 @@
+// contains systemd integration: readiness and status notifications,
+// watchdog pings and socket activation
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// environment of systemd service
+const (
+	EnvNotifySocket  = "NOTIFY_SOCKET"
+	EnvWatchdogUsec  = "WATCHDOG_USEC"
+	EnvWatchdogPID   = "WATCHDOG_PID"
+	EnvListenPID     = "LISTEN_PID"
+	EnvListenFDs     = "LISTEN_FDS"
+	EnvListenFDNames = "LISTEN_FDNAMES"
+)
+
+// states of sd_notify protocol
+const (
+	NotifyReady    = "READY=1"
+	NotifyStopping = "STOPPING=1"
+	NotifyWatchdog = "WATCHDOG=1"
+)
+
+// SocketNameSwagger FileDescriptorName= of activated Swagger UI
+// socket, other TCP socket is API, Unix socket is API with peer
+// credentials
+const SocketNameSwagger = "swagger"
+
+// listenFDsStart first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+var BadActivation = fmt.Errorf("bad socket activation")
+
+// Notifier send service state to systemd (sd_notify protocol).
+// Nil Notifier ignores notifications
+type Notifier struct {
+	addr *net.UnixAddr
+}
+
+// MakeNotifier factory function return Notifier of socket (path or
+// abstract @name), nil if socket is empty (not a notify service)
+func MakeNotifier(socket string) *Notifier {
+	if socket == "" {
+		return nil
+	}
+	return &Notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// Notify send states in single datagram
+func (n *Notifier) Notify(states ...string) (err error) {
+	var conn *net.UnixConn
+
+	if n == nil {
+		return err
+	}
+
+	if conn, err = net.DialUnix("unixgram", nil, n.addr); err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// Status send free-form status text shown by systemctl status
+func (n *Notifier) Status(text string) error {
+	return n.Notify("STATUS=" + text)
+}
+
+// Watchdog send WATCHDOG=1 each interval until ctx is done
+func (n *Notifier) Watchdog(ctx context.Context, interval time.Duration, log *logrus.Logger) {
+	if n == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.Notify(NotifyWatchdog); err != nil {
+				log.WithField("error", err.Error()).Warn("watchdog ping failed")
+			}
+		}
+	}
+}
+
+// WatchdogInterval return interval of watchdog pings, half of
+// WATCHDOG_USEC. Return 0 if watchdog is disabled or set for other
+// process
+func WatchdogInterval(getenv func(string) string, pid int) time.Duration {
+	usec, err := strconv.ParseInt(getenv(EnvWatchdogUsec), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if p := getenv(EnvWatchdogPID); p != "" && p != strconv.Itoa(pid) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// ActivatedListener is a listening socket passed by systemd
+type ActivatedListener struct {
+	Name     string
+	Listener net.Listener
+}
+
+// ActivationListeners return listening sockets passed by systemd
+// socket activation (LISTEN_FDS). Return nil if sockets are passed
+// to other process
+func ActivationListeners(getenv func(string) string, pid int) (
+	listeners []ActivatedListener,
+	err error,
+) {
+	if getenv(EnvListenPID) != strconv.Itoa(pid) {
+		return listeners, err
+	}
+
+	count, err := strconv.Atoi(getenv(EnvListenFDs))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%w: %s=%q", BadActivation, EnvListenFDs, getenv(EnvListenFDs))
+	}
+
+	var names []string
+	if value := getenv(EnvListenFDNames); value != "" {
+		names = strings.Split(value, ":")
+	}
+	return fileListeners(listenFDsStart, count, names)
+}
+
+// fileListeners return listeners of count descriptors from first
+func fileListeners(first int, count int, names []string) (
+	listeners []ActivatedListener,
+	err error,
+) {
+	for i := range count {
+		var ln net.Listener
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(first+i), name)
+		ln, err = net.FileListener(f)
+		_ = f.Close()
+
+		if err != nil {
+			for _, a := range listeners {
+				_ = a.Listener.Close()
+			}
+			return nil, fmt.Errorf("%w: fd %d: %w", BadActivation, first+i, err)
+		}
+		listeners = append(listeners, ActivatedListener{Name: name, Listener: ln})
+	}
+	return listeners, err
+}
+
+// runsStatus return status text of running sync runs
+func runsStatus(runs []SyncRun) string {
+	running := make([]string, 0, len(runs))
+	for _, run := range runs {
+		if run.State != RunStateRunning {
+			continue
+		}
+
+		target := run.Name
+		if target == "" {
+			target = run.SrcPath + " -> " + strings.Join(run.DstPaths, ", ")
+		}
+		running = append(running, fmt.Sprintf("%s #%s %s", run.Kind, run.ID, target))
+	}
+
+	if len(running) == 0 {
+		return "idle"
+	}
+	return "syncing: " + strings.Join(running, "; ")
+}
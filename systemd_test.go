@@ -0,0 +1,148 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// notifySocket return fake NOTIFY_SOCKET listener
+func notifySocket(t *testing.T) (string, *net.UnixConn) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return path, conn
+}
+
+// readNotify return next datagram of fake NOTIFY_SOCKET
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	buf := make([]byte, 4096)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestNotifier(t *testing.T) {
+	path, conn := notifySocket(t)
+
+	n := MakeNotifier(path)
+	require.NoError(t, n.Notify(NotifyReady, "STATUS=idle"))
+	require.Equal(t, "READY=1\nSTATUS=idle", readNotify(t, conn))
+
+	require.NoError(t, n.Status("syncing"))
+	require.Equal(t, "STATUS=syncing", readNotify(t, conn))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Watchdog(ctx, 10*time.Millisecond, logrus.New())
+	require.Equal(t, NotifyWatchdog, readNotify(t, conn))
+
+	// not a notify service
+	require.Nil(t, MakeNotifier(""))
+	require.NoError(t, MakeNotifier("").Notify(NotifyReady))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want time.Duration
+	}{
+		{name: "test disabled", env: map[string]string{}},
+		{name: "test interval", env: map[string]string{EnvWatchdogUsec: "30000000"}, want: 15 * time.Second},
+		{name: "test own pid", env: map[string]string{EnvWatchdogUsec: "2000000", EnvWatchdogPID: "42"}, want: time.Second},
+		{name: "test other pid", env: map[string]string{EnvWatchdogUsec: "2000000", EnvWatchdogPID: "43"}},
+		{name: "test bad value", env: map[string]string{EnvWatchdogUsec: "soon"}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				getenv := func(key string) string { return tt.env[key] }
+				require.Equal(t, tt.want, WatchdogInterval(getenv, 42))
+			},
+		)
+	}
+}
+
+func TestActivationListeners(t *testing.T) {
+	env := map[string]string{EnvListenPID: "43", EnvListenFDs: "1"}
+	getenv := func(key string) string { return env[key] }
+
+	// sockets of other process
+	listeners, err := ActivationListeners(getenv, 42)
+	require.NoError(t, err)
+	require.Nil(t, listeners)
+
+	env[EnvListenPID], env[EnvListenFDs] = "42", "many"
+	_, err = ActivationListeners(getenv, 42)
+	require.ErrorIs(t, err, BadActivation)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	listeners, err = fileListeners(fd, 1, []string{SocketNameSwagger})
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	require.Equal(t, SocketNameSwagger, listeners[0].Name)
+	require.Equal(t, ln.Addr().String(), listeners[0].Listener.Addr().String())
+	require.NoError(t, listeners[0].Listener.Close())
+}
+
+func TestServer_RunNotify(t *testing.T) {
+	path, conn := notifySocket(t)
+	t.Setenv(EnvNotifySocket, path)
+
+	cfg := loadConfig(t, strings.Replace(testConfig, "port: 6767", "port: 0", 1))
+	srv, err := MakeServer(cfg, logrus.New())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	require.Equal(t, "READY=1\nSTATUS=idle", readNotify(t, conn))
+
+	_, id := srv.runs.Start(context.Background(), SyncRun{Kind: RunKindProfile, Name: "docs"})
+	require.Equal(t, "STATUS=syncing: profile #"+id+" docs", readNotify(t, conn))
+
+	srv.runs.Finish(id, nil, nil)
+	require.Equal(t, "STATUS=idle", readNotify(t, conn))
+
+	cancel()
+	require.Equal(t, "STOPPING=1\nSTATUS=shutting down", readNotify(t, conn))
+	require.NoError(t, <-done)
+	require.Empty(t, os.Getenv(EnvListenFDs))
+}
+
+func TestRunsStatus(t *testing.T) {
+	runs := []SyncRun{
+		{ID: "3", Kind: RunKindSync, SrcPath: "/a", DstPaths: []string{"/b"}, State: RunStateRunning},
+		{ID: "2", Kind: RunKindSchedule, Name: "nightly", State: JobStatusOk},
+		{ID: "1", Kind: RunKindFanOut, SrcPath: "/a", DstPaths: []string{"/c", "/d"}, State: RunStateRunning},
+	}
+	require.Equal(t, "syncing: sync #3 /a -> /b; fanout #1 /a -> /c, /d", runsStatus(runs))
+	require.Equal(t, "idle", runsStatus(runs[1:2]))
+	require.Equal(t, "idle", runsStatus(nil))
+}
@@ -0,0 +1,323 @@
+// contains rate limits for sync jobs (bandwidth and IOPS)
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultWindowLayout time of day layout for throttle schedule
+const DefaultWindowLayout = "15:04"
+
+// throttleRefreshInterval how often schedule windows are checked
+const throttleRefreshInterval = time.Second
+
+// MaxThrottleSlice max bytes reserved from limiters at once by copy
+const MaxThrottleSlice = 64 * 1024
+
+var BadThrottleWindow = fmt.Errorf("bad throttle window")
+
+// RateLimiter is a token bucket limiter with one second burst.
+// Zero rate means unlimited
+type RateLimiter struct {
+	lock   *sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// MakeRateLimiter factory function return new RateLimiter
+func MakeRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		lock:   new(sync.Mutex),
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// SetRate update limiter rate
+func (l *RateLimiter) SetRate(rate int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if float64(rate) == l.rate {
+		return
+	}
+
+	l.rate = float64(rate)
+	l.tokens = min(l.tokens, l.rate)
+	l.last = time.Now()
+}
+
+// Rate return current limiter rate
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(l.rate)
+}
+
+// WaitN block until n tokens available or ctx is done. Tokens are
+// reserved at once (limiter can go into debt for large n)
+func (l *RateLimiter) WaitN(ctx context.Context, n int64) (err error) {
+	if l == nil || n <= 0 {
+		return err
+	}
+
+	l.lock.Lock()
+	if l.rate <= 0 {
+		l.lock.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+
+	if delay == 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return err
+	}
+}
+
+// ThrottleLimits contains sync rate limits. Zero value means unlimited
+type ThrottleLimits struct {
+	// BytesPerSec limit for each sync job (all workers of job)
+	BytesPerSec int64 `yaml:"bytes_per_sec" json:"bytes_per_sec"`
+
+	// FilesPerSec limit for file operations (sync and delete) of
+	// each sync job
+	FilesPerSec int64 `yaml:"files_per_sec" json:"files_per_sec"`
+
+	// WorkerBytesPerSec limit for each worker
+	WorkerBytesPerSec int64 `yaml:"worker_bytes_per_sec" json:"worker_bytes_per_sec"`
+}
+
+// ThrottleWindow set limits for time of day window. Window
+// can cross midnight (from 22:00 to 06:00)
+type ThrottleWindow struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+
+	ThrottleLimits `yaml:",inline"`
+}
+
+// contains check that time of day is inside window
+func (w ThrottleWindow) contains(tm time.Time) (ok bool, err error) {
+	var from, to time.Time
+
+	if from, err = time.Parse(DefaultWindowLayout, w.From); err != nil {
+		return ok, fmt.Errorf("%w: from=%q", BadThrottleWindow, w.From)
+	}
+
+	if to, err = time.Parse(DefaultWindowLayout, w.To); err != nil {
+		return ok, fmt.Errorf("%w: to=%q", BadThrottleWindow, w.To)
+	}
+
+	start := from.Hour()*60 + from.Minute()
+	end := to.Hour()*60 + to.Minute()
+	curr := tm.Hour()*60 + tm.Minute()
+
+	if start <= end {
+		return curr >= start && curr < end, err
+	}
+
+	// window cross midnight
+	return curr >= start || curr < end, err
+}
+
+// Throttle contains rate limits which can be updated at runtime.
+// Limits from first matched schedule window override base limits.
+// Each sync job waits on own buckets made by ForJob
+type Throttle struct {
+	lock     *sync.RWMutex
+	base     ThrottleLimits
+	schedule []ThrottleWindow
+	current  ThrottleLimits
+	checked  time.Time
+
+	// source of limits for throttle of job (nil - own limits)
+	source *Throttle
+
+	bytes *RateLimiter
+	files *RateLimiter
+
+	now func() time.Time
+}
+
+// MakeThrottle factory function return new Throttle. Return error
+// if schedule contains bad windows
+func MakeThrottle(base ThrottleLimits, schedule []ThrottleWindow) (
+	t *Throttle,
+	err error,
+) {
+	t = &Throttle{
+		lock:  new(sync.RWMutex),
+		bytes: MakeRateLimiter(0),
+		files: MakeRateLimiter(0),
+		now:   time.Now,
+	}
+
+	if err = t.Set(base, schedule); err != nil {
+		return nil, err
+	}
+
+	return t, err
+}
+
+// Set update base limits and schedule
+func (t *Throttle) Set(base ThrottleLimits, schedule []ThrottleWindow) (
+	err error,
+) {
+	if base.BytesPerSec < 0 || base.FilesPerSec < 0 ||
+		base.WorkerBytesPerSec < 0 {
+		return fmt.Errorf("negative throttle limit: %+v", base)
+	}
+
+	// check all windows before apply
+	for _, w := range schedule {
+		if _, err = w.contains(time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	t.lock.Lock()
+	t.base = base
+	t.schedule = append([]ThrottleWindow(nil), schedule...)
+	t.checked = time.Time{}
+	t.lock.Unlock()
+
+	t.refresh()
+	return err
+}
+
+// ForJob return throttle with own buckets for single sync job.
+// Limits follow t, so runtime updates apply to running jobs
+func (t *Throttle) ForJob() *Throttle {
+	if t == nil {
+		return nil
+	}
+
+	return &Throttle{
+		lock:   new(sync.RWMutex),
+		bytes:  MakeRateLimiter(0),
+		files:  MakeRateLimiter(0),
+		now:    t.now,
+		source: t,
+	}
+}
+
+// Base return base limits and schedule
+func (t *Throttle) Base() (ThrottleLimits, []ThrottleWindow) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.base, append([]ThrottleWindow(nil), t.schedule...)
+}
+
+// Limits return limits applied at the moment
+func (t *Throttle) Limits() ThrottleLimits {
+	t.refresh()
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.current
+}
+
+// WaitFile block until next file operation allowed
+func (t *Throttle) WaitFile(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.refresh()
+	return t.files.WaitN(ctx, 1)
+}
+
+// WaitBytes block until n bytes can be transferred
+func (t *Throttle) WaitBytes(ctx context.Context, n int64) error {
+	if t == nil {
+		return nil
+	}
+
+	t.refresh()
+	return t.bytes.WaitN(ctx, n)
+}
+
+// throttleSlice return count of bytes to reserve at once from
+// limiters of throttles and lowest worker rate. Slice takes about
+// 1/10 second of lowest rate in range DefaultBufferSize..MaxThrottleSlice
+func throttleSlice(throttles ...*Throttle) (slice int, worker int64) {
+	var rate int64
+
+	for _, t := range throttles {
+		if t == nil {
+			continue
+		}
+
+		limits := t.Limits()
+		if r := limits.WorkerBytesPerSec; r > 0 && (worker == 0 || r < worker) {
+			worker = r
+		}
+
+		if r := limits.BytesPerSec; r > 0 && (rate == 0 || r < rate) {
+			rate = r
+		}
+	}
+
+	if worker > 0 && (rate == 0 || worker < rate) {
+		rate = worker
+	}
+
+	if rate == 0 {
+		return MaxThrottleSlice, worker
+	}
+
+	return int(min(max(rate/10, DefaultBufferSize), MaxThrottleSlice)), worker
+}
+
+// refresh select limits by schedule (not often than once per second)
+func (t *Throttle) refresh() {
+	now := t.now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.checked.IsZero() && now.Sub(t.checked) < throttleRefreshInterval {
+		return
+	}
+	t.checked = now
+
+	if t.source != nil {
+		t.current = t.source.Limits()
+	} else {
+		t.current = t.base
+		for _, w := range t.schedule {
+			// windows were checked in Set
+			if ok, _ := w.contains(now); ok {
+				t.current = w.ThrottleLimits
+				break
+			}
+		}
+	}
+
+	t.bytes.SetRate(t.current.BytesPerSec)
+	t.files.SetRate(t.current.FilesPerSec)
+}
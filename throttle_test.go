@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThrottleWindow_contains(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  ThrottleWindow
+		tm      string
+		wantOk  bool
+		wantErr error
+	}{
+		{
+			name:   "test time inside business hours",
+			window: ThrottleWindow{From: "09:00", To: "18:00"},
+			tm:     "12:30",
+			wantOk: true,
+		},
+		{
+			name:   "test window end is excluded",
+			window: ThrottleWindow{From: "09:00", To: "18:00"},
+			tm:     "18:00",
+			wantOk: false,
+		},
+		{
+			name:   "test window cross midnight",
+			window: ThrottleWindow{From: "22:00", To: "06:00"},
+			tm:     "01:15",
+			wantOk: true,
+		},
+		{
+			name:    "test bad window",
+			window:  ThrottleWindow{From: "9am", To: "18:00"},
+			tm:      "12:00",
+			wantErr: BadThrottleWindow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tm, err := time.Parse(DefaultWindowLayout, tt.tm)
+				require.NoError(t, err)
+
+				ok, err := tt.window.contains(tm)
+
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Equal(t, tt.wantOk, ok)
+			},
+		)
+	}
+}
+
+func TestThrottle_Limits(t *testing.T) {
+	base := ThrottleLimits{BytesPerSec: 100}
+	window := ThrottleWindow{
+		From:           "09:00",
+		To:             "18:00",
+		ThrottleLimits: ThrottleLimits{BytesPerSec: 10, FilesPerSec: 1},
+	}
+
+	th, err := MakeThrottle(base, []ThrottleWindow{window})
+	require.NoError(t, err)
+
+	th.now = func() time.Time {
+		return time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	}
+	th.checked = time.Time{}
+	require.Equal(t, base, th.Limits())
+
+	th.now = func() time.Time {
+		return time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	}
+	th.checked = time.Time{}
+	require.Equal(t, window.ThrottleLimits, th.Limits())
+	require.Equal(t, int64(1), th.files.Rate())
+}
+
+func TestRateLimiter_WaitN(t *testing.T) {
+	l := MakeRateLimiter(1000)
+
+	// burst is consumed immediately, next 100 tokens take ~100ms
+	start := time.Now()
+	require.NoError(t, l.WaitN(context.Background(), 1000))
+	require.NoError(t, l.WaitN(context.Background(), 100))
+	require.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+
+	// cancelled context interrupt wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, l.WaitN(ctx, 1000), context.Canceled)
+}
+
+func TestThrottle_ForJob(t *testing.T) {
+	shared, err := MakeThrottle(ThrottleLimits{BytesPerSec: 1000}, nil)
+	require.NoError(t, err)
+
+	first, second := shared.ForJob(), shared.ForJob()
+	require.Equal(t, shared.Limits(), first.Limits())
+	require.Equal(t, shared.Limits(), second.Limits())
+
+	// each job fill own bucket
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, first.WaitBytes(context.Background(), 150))
+	require.NoError(t, second.WaitBytes(context.Background(), 150))
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// runtime update is followed by jobs
+	require.NoError(t, shared.Set(ThrottleLimits{BytesPerSec: 10}, nil))
+	first.checked = time.Time{}
+	require.Equal(t, int64(10), first.Limits().BytesPerSec)
+
+	require.Nil(t, (*Throttle)(nil).ForJob())
+}
+
+func TestThrottleSlice(t *testing.T) {
+	limited := func(limits ThrottleLimits) *Throttle {
+		th, err := MakeThrottle(limits, nil)
+		require.NoError(t, err)
+		return th
+	}
+
+	tests := []struct {
+		name       string
+		throttles  []*Throttle
+		wantSlice  int
+		wantWorker int64
+	}{
+		{
+			name:      "test no throttles",
+			throttles: []*Throttle{nil, nil},
+			wantSlice: MaxThrottleSlice,
+		},
+		{
+			name:       "test shared only",
+			throttles:  []*Throttle{limited(ThrottleLimits{WorkerBytesPerSec: 100000}), nil},
+			wantSlice:  10000,
+			wantWorker: 100000,
+		},
+		{
+			name: "test lowest wins",
+			throttles: []*Throttle{
+				limited(ThrottleLimits{BytesPerSec: 200000, WorkerBytesPerSec: 300000}),
+				limited(ThrottleLimits{WorkerBytesPerSec: 250000}),
+			},
+			wantSlice:  20000,
+			wantWorker: 250000,
+		},
+		{
+			name:      "test low rate",
+			throttles: []*Throttle{limited(ThrottleLimits{BytesPerSec: 1000}), nil},
+			wantSlice: DefaultBufferSize,
+		},
+		{
+			name:      "test high rate",
+			throttles: []*Throttle{limited(ThrottleLimits{BytesPerSec: 1 << 30}), nil},
+			wantSlice: MaxThrottleSlice,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				slice, worker := throttleSlice(tt.throttles...)
+				require.Equal(t, tt.wantSlice, slice)
+				require.Equal(t, tt.wantWorker, worker)
+			},
+		)
+	}
+}
+
+func TestSynchronizer_syncPair_canceled(t *testing.T) {
+	th, err := MakeThrottle(ThrottleLimits{WorkerBytesPerSec: 1024}, nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	pair := SyncPair{
+		Src:  filepath.Join(dir, "src.bin"),
+		Dst:  filepath.Join(dir, "dst.bin"),
+		Perm: 0644,
+	}
+	require.NoError(t, os.WriteFile(pair.Src, make([]byte, 64*1024), 0644))
+
+	// throttled copy takes about a minute - cancel interrupt it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	s := &Synchronizer{Throttle: th}
+	start := time.Now()
+	_, err = s.syncPair(ctx, logrus.New(), pair, MakeRateLimiter(0))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// partial copy is removed
+	require.NoFileExists(t, pair.Dst)
+}
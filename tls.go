@@ -0,0 +1,287 @@
+// contains TLS settings of API listener with certificates hot reload
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTLSReloadInterval how often certificate files are checked
+const DefaultTLSReloadInterval = 30 * time.Second
+
+// client certificate policies
+const (
+	// ClientAuthRequest verify client certificate if given
+	ClientAuthRequest = "request"
+
+	// ClientAuthRequire reject clients without valid certificate
+	ClientAuthRequire = "require"
+)
+
+var BadTLSConfig = fmt.Errorf("bad tls config")
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig contains TLS settings of API listener
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string `yaml:"key_file" json:"key_file"`
+
+	// MinVersion 1.2 (default) or 1.3
+	MinVersion string `yaml:"min_version" json:"min_version"`
+
+	// CipherSuites names of TLS 1.2 suites (empty - Go defaults),
+	// TLS 1.3 suites are not configurable
+	CipherSuites []string `yaml:"cipher_suites" json:"cipher_suites"`
+
+	// ClientCAFile enable client certificates verification
+	ClientCAFile string `yaml:"client_ca_file" json:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" json:"client_auth"`
+
+	// ReloadInterval how often files are checked for changes
+	ReloadInterval time.Duration `yaml:"reload_interval" json:"reload_interval" validate:"gte=0"`
+}
+
+// Validate check version, cipher suites and client auth policy
+func (c TLSConfig) Validate() (err error) {
+	if !c.Enabled {
+		return err
+	}
+
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("%w: cert_file and key_file required", BadTLSConfig)
+	}
+
+	if _, err = c.minVersion(); err != nil {
+		return err
+	}
+
+	if _, err = c.cipherSuites(); err != nil {
+		return err
+	}
+
+	switch c.ClientAuth {
+	case "", ClientAuthRequest, ClientAuthRequire:
+	default:
+		return fmt.Errorf("%w: unknown client_auth %q", BadTLSConfig, c.ClientAuth)
+	}
+
+	return err
+}
+
+func (c TLSConfig) minVersion() (uint16, error) {
+	if c.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	if v, ok := tlsVersions[c.MinVersion]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported min_version %q", BadTLSConfig, c.MinVersion)
+}
+
+func (c TLSConfig) cipherSuites() (ids []uint16, err error) {
+	if len(c.CipherSuites) == 0 {
+		return ids, err
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	for _, name := range c.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			if slices.ContainsFunc(tls.InsecureCipherSuites(), func(s *tls.CipherSuite) bool {
+				return s.Name == name
+			}) {
+				return nil, fmt.Errorf("%w: insecure cipher suite %q", BadTLSConfig, name)
+			}
+			return nil, fmt.Errorf("%w: unknown cipher suite %q", BadTLSConfig, name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, err
+}
+
+func (c TLSConfig) clientAuth() tls.ClientAuthType {
+	if c.ClientCAFile == "" {
+		return tls.NoClientCert
+	}
+
+	if c.ClientAuth == ClientAuthRequest {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
+// CertReloader keep current certificate and client CA pool. Files
+// are reloaded on Reload call (SIGHUP) or if changed on disk. Reload
+// affects new connections only, active connections are not dropped
+type CertReloader struct {
+	cfg TLSConfig
+
+	lock      *sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+
+	log *logrus.Logger
+}
+
+// MakeCertReloader factory function return CertReloader with
+// loaded certificates
+func MakeCertReloader(cfg TLSConfig, log *logrus.Logger) (
+	r *CertReloader,
+	err error,
+) {
+	if err = cfg.Validate(); err != nil {
+		return r, err
+	}
+
+	r = &CertReloader{
+		cfg:      cfg,
+		lock:     new(sync.RWMutex),
+		modTimes: make(map[string]time.Time, 3),
+		log:      log,
+	}
+
+	if err = r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, err
+}
+
+// Reload read certificate, key and client CA files. Previous
+// certificates are kept if files are broken
+func (r *CertReloader) Reload() (err error) {
+	var cert tls.Certificate
+	var pool *x509.CertPool
+
+	modTimes := make(map[string]time.Time, 3)
+	for _, path := range r.files() {
+		info, sErr := os.Stat(path)
+		if sErr != nil {
+			return sErr
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	if cert, err = tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile); err != nil {
+		return err
+	}
+
+	if r.cfg.ClientCAFile != "" {
+		var pem []byte
+		if pem, err = os.ReadFile(r.cfg.ClientCAFile); err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: no certificates in %s", BadTLSConfig, r.cfg.ClientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cert, r.clientCAs, r.modTimes = &cert, pool, modTimes
+	return err
+}
+
+// files return watched files
+func (r *CertReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// changed return true if any file modification time changed
+func (r *CertReloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reload certificates on files change until ctx is done
+func (r *CertReloader) Watch(ctx context.Context) {
+	interval := r.cfg.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.Reload(); err != nil {
+				r.log.WithField("error", err.Error()).Error("tls reload failed")
+				continue
+			}
+			r.log.Info("tls certificates reloaded")
+		}
+	}
+}
+
+// TLSConfig return listener config which use current certificates
+func (r *CertReloader) TLSConfig() *tls.Config {
+	// validated on create
+	minVersion, _ := r.cfg.minVersion()
+	suites, _ := r.cfg.cipherSuites()
+
+	base := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+		ClientAuth:   r.cfg.clientAuth(),
+	}
+
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.clientCAs
+		return c, nil
+	}
+
+	return cfg
+}
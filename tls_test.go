@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert write self-signed certificate and key with common name
+func writeCert(t *testing.T, certFile string, keyFile string, cn string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(
+		t,
+		os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600),
+	)
+	require.NoError(
+		t,
+		os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600),
+	)
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		wantErr bool
+	}{
+		{name: "test disabled", cfg: TLSConfig{MinVersion: "bad"}},
+		{name: "test valid", cfg: TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k", MinVersion: "1.3"}},
+		{name: "test no cert", cfg: TLSConfig{Enabled: true}, wantErr: true},
+		{
+			name:    "test bad version",
+			cfg:     TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k", MinVersion: "1.0"},
+			wantErr: true,
+		},
+		{
+			name:    "test unknown suite",
+			cfg:     TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k", CipherSuites: []string{"NOPE"}},
+			wantErr: true,
+		},
+		{
+			name: "test insecure suite",
+			cfg: TLSConfig{
+				Enabled: true, CertFile: "c", KeyFile: "k",
+				CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test secure suite",
+			cfg: TLSConfig{
+				Enabled: true, CertFile: "c", KeyFile: "k",
+				CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			},
+		},
+		{
+			name:    "test unknown client auth",
+			cfg:     TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k", ClientAuth: "maybe"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := tt.cfg.Validate()
+				if tt.wantErr {
+					require.ErrorIs(t, err, BadTLSConfig)
+					return
+				}
+				require.NoError(t, err)
+			},
+		)
+	}
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+
+	r, err := MakeCertReloader(
+		TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile},
+		logrus.New(),
+	)
+	require.NoError(t, err)
+
+	cfg := r.TLSConfig()
+	commonName := func() string {
+		c, cErr := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+		require.NoError(t, cErr)
+
+		leaf, cErr := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+		require.NoError(t, cErr)
+		return leaf.Subject.CommonName
+	}
+	require.Equal(t, "first", commonName())
+
+	// new certificate is used by new connections
+	writeCert(t, certFile, keyFile, "second")
+	require.NoError(t, os.Chtimes(certFile, time.Now(), time.Now().Add(time.Second)))
+	require.True(t, r.changed())
+	require.NoError(t, r.Reload())
+	require.Equal(t, "second", commonName())
+
+	// broken files keep previous certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	require.Error(t, r.Reload())
+	require.Equal(t, "second", commonName())
+}
@@ -0,0 +1,289 @@
+// contains Unix domain socket listener of API and peer credentials auth
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultUnixSocketMode permissions of socket file
+const DefaultUnixSocketMode = "0660"
+
+var BadUnixSocketConfig = fmt.Errorf("bad unix socket config")
+
+var SocketInUse = fmt.Errorf("unix socket in use")
+
+var NoPeerCredentials = fmt.Errorf("no peer credentials")
+
+// UnixSocketConfig contains settings of Unix socket listener
+type UnixSocketConfig struct {
+	// Path of socket file, empty - listener disabled
+	Path string `yaml:"path" json:"path"`
+
+	// Mode octal permissions of socket file (default 0660)
+	Mode string `yaml:"mode" json:"mode"`
+
+	// Owner and Group of socket file, name or numeric id (optional)
+	Owner string `yaml:"owner" json:"owner"`
+	Group string `yaml:"group" json:"group"`
+
+	// Only disable TCP listener
+	Only bool `yaml:"only" json:"only"`
+}
+
+// Validate check mode, owner and group
+func (c UnixSocketConfig) Validate() (err error) {
+	if c.Path == "" {
+		if c.Only {
+			return fmt.Errorf("%w: only requires path", BadUnixSocketConfig)
+		}
+		return err
+	}
+
+	if _, err = c.mode(); err != nil {
+		return err
+	}
+
+	_, _, err = c.owner()
+	return err
+}
+
+// mode return parsed permissions of socket file
+func (c UnixSocketConfig) mode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%w: mode must be octal permissions, got %q", BadUnixSocketConfig, c.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// owner return uid and gid of socket file, -1 if not set
+func (c UnixSocketConfig) owner() (uid int, gid int, err error) {
+	uid, gid = -1, -1
+
+	if c.Owner != "" {
+		var id uint32
+		if id, err = lookupUser(c.Owner); err != nil {
+			return uid, gid, fmt.Errorf("%w: owner: %w", BadUnixSocketConfig, err)
+		}
+		uid = int(id)
+	}
+
+	if c.Group != "" {
+		var id uint32
+		if id, err = lookupGroup(c.Group); err != nil {
+			return uid, gid, fmt.Errorf("%w: group: %w", BadUnixSocketConfig, err)
+		}
+		gid = int(id)
+	}
+	return uid, gid, err
+}
+
+// ListenUnix create socket file with configured mode and owner. Socket
+// is created accessible by owner only, configured mode is applied
+// after. Stale socket of previous run is removed, socket used by
+// running process is not
+func ListenUnix(cfg UnixSocketConfig) (ln net.Listener, err error) {
+	var info os.FileInfo
+	var mode os.FileMode
+	var uid, gid int
+
+	if mode, err = cfg.mode(); err != nil {
+		return ln, err
+	}
+
+	if uid, gid, err = cfg.owner(); err != nil {
+		return ln, err
+	}
+
+	if info, err = os.Lstat(cfg.Path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return ln, fmt.Errorf("%w: %s is not a socket", BadUnixSocketConfig, cfg.Path)
+		}
+
+		if conn, dErr := net.DialTimeout("unix", cfg.Path, time.Second); dErr == nil {
+			_ = conn.Close()
+			return ln, fmt.Errorf("%w: %s", SocketInUse, cfg.Path)
+		}
+
+		if err = os.Remove(cfg.Path); err != nil {
+			return ln, err
+		}
+	}
+
+	if ln, err = listenSocket(cfg.Path); err != nil {
+		return ln, err
+	}
+
+	if err = os.Chmod(cfg.Path, mode); err == nil && (uid >= 0 || gid >= 0) {
+		err = os.Chown(cfg.Path, uid, gid)
+	}
+
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, err
+}
+
+// PeerCred is an identity of process on other side of Unix socket
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// peerCredKey context key of connection peer credentials
+type peerCredKey struct{}
+
+// peerConnContext save peer credentials of Unix socket connection
+// in context of its requests
+func peerConnContext(ctx context.Context, c net.Conn) context.Context {
+	cred, err := peerCredentials(c)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// PeerCredIdentity roles of local user or group connected with Unix
+// socket, user and group are names or numeric ids
+type PeerCredIdentity struct {
+	User  string   `yaml:"user" json:"user"`
+	Group string   `yaml:"group" json:"group"`
+	Roles []string `yaml:"roles" json:"roles"`
+}
+
+// PeerAuth map peer credentials of Unix socket to identity. User
+// entries are checked first, then groups in config order
+type PeerAuth struct {
+	users  map[uint32]Identity
+	groups []peerGroup
+
+	// groupIDs return all group ids of user
+	groupIDs func(uid uint32) []uint32
+}
+
+type peerGroup struct {
+	gid uint32
+	id  Identity
+}
+
+// MakePeerAuth factory function return PeerAuth with entries
+func MakePeerAuth(entries []PeerCredIdentity) (a *PeerAuth, err error) {
+	a = &PeerAuth{users: make(map[uint32]Identity), groupIDs: userGroupIDs}
+
+	for _, e := range entries {
+		var id uint32
+
+		switch {
+		case e.User != "" && e.Group != "":
+			return nil, fmt.Errorf("peer credentials: user and group are exclusive: %q, %q", e.User, e.Group)
+		case e.User != "":
+			if err = validateRoles(e.User, e.Roles); err != nil {
+				return nil, err
+			}
+
+			if id, err = lookupUser(e.User); err != nil {
+				return nil, fmt.Errorf("peer credentials: %w", err)
+			}
+			a.users[id] = Identity{Name: "user:" + e.User, Roles: e.Roles}
+		case e.Group != "":
+			if err = validateRoles(e.Group, e.Roles); err != nil {
+				return nil, err
+			}
+
+			if id, err = lookupGroup(e.Group); err != nil {
+				return nil, fmt.Errorf("peer credentials: %w", err)
+			}
+			a.groups = append(a.groups, peerGroup{gid: id, id: Identity{Name: "group:" + e.Group, Roles: e.Roles}})
+		default:
+			return nil, fmt.Errorf("peer credentials: user or group required")
+		}
+	}
+
+	return a, err
+}
+
+// Authenticate request by peer credentials of Unix socket connection.
+// Unknown peers are left to other authenticators
+func (a *PeerAuth) Authenticate(r *http.Request) (*Identity, error) {
+	cred, ok := r.Context().Value(peerCredKey{}).(*PeerCred)
+	if !ok {
+		return nil, nil
+	}
+
+	if id, found := a.users[cred.UID]; found {
+		return &id, nil
+	}
+
+	if len(a.groups) == 0 {
+		return nil, nil
+	}
+
+	gids := append(a.groupIDs(cred.UID), cred.GID)
+	for _, g := range a.groups {
+		if slices.Contains(gids, g.gid) {
+			return &g.id, nil
+		}
+	}
+	return nil, nil
+}
+
+// lookupUser return uid of user name or numeric id
+func lookupUser(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+// lookupGroup return gid of group name or numeric id
+func lookupGroup(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
+
+// userGroupIDs return supplementary group ids of user, empty if user
+// is unknown
+func userGroupIDs(uid uint32) (gids []uint32) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return gids
+	}
+
+	groups, err := u.GroupIds()
+	if err != nil {
+		return gids
+	}
+
+	for _, group := range groups {
+		if id, pErr := strconv.ParseUint(group, 10, 32); pErr == nil {
+			gids = append(gids, uint32(id))
+		}
+	}
+	return gids
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocketConfig_Validate(t *testing.T) {
+	uid := strconv.Itoa(os.Getuid())
+	tests := []struct {
+		name    string
+		cfg     UnixSocketConfig
+		wantErr bool
+	}{
+		{name: "test disabled", cfg: UnixSocketConfig{}},
+		{name: "test only without path", cfg: UnixSocketConfig{Only: true}, wantErr: true},
+		{name: "test valid", cfg: UnixSocketConfig{Path: "/run/fsyncd.sock", Mode: "0600", Owner: uid}},
+		{name: "test bad mode", cfg: UnixSocketConfig{Path: "/run/fsyncd.sock", Mode: "rw"}, wantErr: true},
+		{name: "test mode out of range", cfg: UnixSocketConfig{Path: "/run/fsyncd.sock", Mode: "1777"}, wantErr: true},
+		{
+			name:    "test unknown owner",
+			cfg:     UnixSocketConfig{Path: "/run/fsyncd.sock", Mode: "0600", Owner: "no-such-user-fsyncd"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := tt.cfg.Validate()
+				if tt.wantErr {
+					require.ErrorIs(t, err, BadUnixSocketConfig)
+					return
+				}
+				require.NoError(t, err)
+			},
+		)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	cfg := UnixSocketConfig{Path: filepath.Join(t.TempDir(), "fsyncd.sock"), Mode: "0600"}
+
+	ln, err := ListenUnix(cfg)
+	require.NoError(t, err)
+
+	info, err := os.Stat(cfg.Path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// running listener is not replaced
+	_, err = ListenUnix(cfg)
+	require.ErrorIs(t, err, SocketInUse)
+
+	// stale socket is removed
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, ln.Close())
+	require.FileExists(t, cfg.Path)
+
+	ln, err = ListenUnix(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+
+	// regular file is kept
+	require.NoError(t, os.WriteFile(cfg.Path, []byte("data"), 0600))
+	_, err = ListenUnix(cfg)
+	require.ErrorIs(t, err, BadUnixSocketConfig)
+}
+
+func TestPeerAuth_Authenticate(t *testing.T) {
+	a, err := MakePeerAuth(
+		[]PeerCredIdentity{
+			{User: "1000", Roles: []string{RoleAdmin}},
+			{Group: "50", Roles: []string{RoleRead}},
+		},
+	)
+	require.NoError(t, err)
+	a.groupIDs = func(uid uint32) []uint32 {
+		if uid == 1001 {
+			return []uint32{50}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		cred     *PeerCred
+		wantName string
+	}{
+		{name: "test no credentials"},
+		{name: "test user", cred: &PeerCred{UID: 1000, GID: 1000}, wantName: "user:1000"},
+		{name: "test primary group", cred: &PeerCred{UID: 1002, GID: 50}, wantName: "group:50"},
+		{name: "test supplementary group", cred: &PeerCred{UID: 1001, GID: 1001}, wantName: "group:50"},
+		{name: "test unknown peer", cred: &PeerCred{UID: 1003, GID: 1003}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r, _ := http.NewRequest(http.MethodGet, "/", nil)
+				if tt.cred != nil {
+					r = r.WithContext(context.WithValue(r.Context(), peerCredKey{}, tt.cred))
+				}
+
+				id, err := a.Authenticate(r)
+				require.NoError(t, err)
+				if tt.wantName == "" {
+					require.Nil(t, id)
+					return
+				}
+				require.Equal(t, tt.wantName, id.Name)
+			},
+		)
+	}
+
+	_, err = MakePeerAuth([]PeerCredIdentity{{User: "0", Group: "0", Roles: []string{RoleRead}}})
+	require.Error(t, err)
+}
+
+func TestServer_unixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported on linux only")
+	}
+
+	text := strings.Replace(
+		testConfig,
+		"auth:\n",
+		"auth:\n  peer_credentials:\n    - user: \""+strconv.Itoa(os.Getuid())+"\"\n      roles: [read]\n",
+		1,
+	)
+	cfg := loadConfig(t, text)
+	srv, err := MakeServer(cfg, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, srv.setup())
+
+	socket := UnixSocketConfig{Path: filepath.Join(t.TempDir(), "fsyncd.sock"), Mode: "0600"}
+	ln, err := ListenUnix(socket)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: srv.g, ConnContext: peerConnContext}
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	cl, err := MakeClient("unix://"+socket.Path, "", "", false)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = cl.Status(ctx)
+	require.NoError(t, err)
+
+	// peer has read role only
+	var apiErr *APIError
+	_, err = cl.UpdateConfig(ctx, map[string]any{"max_diff_percent": 20}, "", false)
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.Status)
+
+	// known peer is authenticated before bearer token is checked
+	cl, err = MakeClient("unix://"+socket.Path, "bad-token", "", false)
+	require.NoError(t, err)
+	_, err = cl.Status(ctx)
+	require.NoError(t, err)
+}
@@ -0,0 +1,352 @@
+// contains watch mode - continuous sync of configured pairs
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultWatchDebounce delay after last event before sync
+const DefaultWatchDebounce = 2 * time.Second
+
+// watchMaxDelayFactor limit sync delay for endless events burst
+// (debounce * factor)
+const watchMaxDelayFactor = 10
+
+// watchMaxRetryDelay upper bound for retries of failed syncs
+const watchMaxRetryDelay = time.Minute
+
+var WatchNotSupported = fmt.Errorf("watch mode not supported on this platform")
+
+// WatchPair source and destination synced continuously
+type WatchPair struct {
+	SrcPath        string `yaml:"src_path" json:"src_path"`
+	DstPath        string `yaml:"dst_path" json:"dst_path"`
+	MaxDiffPercent int    `yaml:"max_diff_percent" json:"max_diff_percent"`
+}
+
+// watchEvent is a change of directory in watched tree. Overflow
+// means that events were lost and full rescan required
+type watchEvent struct {
+	Dir       string
+	Recursive bool
+	Overflow  bool
+}
+
+// WatchDir is a changed directory relative to source root
+type WatchDir struct {
+	Rel       string
+	Recursive bool
+}
+
+// Watcher collect changes of source tree, debounce events burst
+// and run targeted sync of changed directories. If events were
+// lost full sync is used
+type Watcher struct {
+	Pair     WatchPair
+	Debounce time.Duration
+
+	// Full run sync of whole tree
+	Full func(ctx context.Context) error
+
+	// Targeted run sync of changed directories only
+	Targeted func(ctx context.Context, dirs []WatchDir) error
+
+	log *logrus.Logger
+}
+
+// Run watch source tree until ctx is done. First sync is full
+func (w *Watcher) Run(ctx context.Context) (err error) {
+	var g errgroup.Group
+	var first time.Time
+
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan watchEvent, DefaultPlanBufferSize)
+	g.Go(
+		func() error {
+			defer cancel()
+			return watchTree(wCtx, w.Pair.SrcPath, events)
+		},
+	)
+
+	pending := make(map[string]bool, DefaultDirAllocSize)
+	full := true
+	retry := debounce
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-wCtx.Done():
+			return g.Wait()
+		case ev := <-events:
+			if first.IsZero() {
+				first = time.Now()
+			}
+			w.collect(ev, pending, &full)
+
+			// delay sync until burst end but not too long
+			if time.Since(first) < debounce*watchMaxDelayFactor {
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			if !full && len(pending) == 0 {
+				continue
+			}
+
+			if err = w.flush(wCtx, pending, full); err != nil {
+				if !errors.Is(err, SyncBusy) && !errors.Is(err, DirLocked) {
+					w.log.WithFields(
+						logrus.Fields{
+							"src":   w.Pair.SrcPath,
+							"dst":   w.Pair.DstPath,
+							"error": err.Error(),
+						},
+					).Error("watch sync failed")
+				}
+
+				// keep changes and try again later
+				timer.Reset(retry)
+				retry = min(retry*2, watchMaxRetryDelay)
+				continue
+			}
+
+			clear(pending)
+			full, first, retry = false, time.Time{}, debounce
+		}
+	}
+}
+
+// collect save event into pending changes
+func (w *Watcher) collect(ev watchEvent, pending map[string]bool, full *bool) {
+	if ev.Overflow {
+		*full = true
+		return
+	}
+
+	rel, err := filepath.Rel(w.Pair.SrcPath, ev.Dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+
+	if rel == "." {
+		rel = ""
+	}
+	pending[rel] = pending[rel] || ev.Recursive
+}
+
+// flush run sync for pending changes
+func (w *Watcher) flush(
+	ctx context.Context,
+	pending map[string]bool,
+	full bool,
+) error {
+	if full {
+		w.log.WithFields(logrus.Fields{"src": w.Pair.SrcPath}).Debug("watch full sync")
+		return w.Full(ctx)
+	}
+
+	dirs := reduceWatchDirs(pending)
+	w.log.WithFields(
+		logrus.Fields{"src": w.Pair.SrcPath, "dirs": len(dirs)},
+	).Debug("watch targeted sync")
+	return w.Targeted(ctx, dirs)
+}
+
+// reduceWatchDirs drop directories covered by recursive parent and
+// sort them by depth (parents are synced before children)
+func reduceWatchDirs(pending map[string]bool) []WatchDir {
+	dirs := make([]WatchDir, 0, len(pending))
+
+	for rel, recursive := range pending {
+		covered := false
+		for parent := rel; parent != "" && parent != "."; {
+			parent = filepath.Dir(parent)
+			if parent == "." {
+				parent = ""
+			}
+
+			if pending[parent] {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			dirs = append(dirs, WatchDir{Rel: rel, Recursive: recursive})
+		}
+	}
+
+	depth := func(rel string) int {
+		if rel == "" {
+			return 0
+		}
+		return strings.Count(rel, "/") + 1
+	}
+
+	sort.Slice(
+		dirs, func(i, j int) bool {
+			di, dj := depth(dirs[i].Rel), depth(dirs[j].Rel)
+			if di != dj {
+				return di < dj
+			}
+			return dirs[i].Rel < dirs[j].Rel
+		},
+	)
+
+	return dirs
+}
+
+// runWatchers start watcher for each configured pair
+func (srv *Server) runWatchers(ctx context.Context) error {
+	var g errgroup.Group
+
+	for _, pair := range srv.boot.Watch {
+		w := &Watcher{
+			Pair:     pair,
+			Debounce: srv.boot.WatchDebounce,
+			Full: func(ctx context.Context) error {
+				return srv.watchFull(ctx, pair)
+			},
+			Targeted: func(ctx context.Context, dirs []WatchDir) error {
+				return srv.watchTargeted(ctx, pair, dirs)
+			},
+			log: srv.log,
+		}
+
+		g.Go(
+			func() error {
+				return w.Run(ctx)
+			},
+		)
+	}
+
+	return g.Wait()
+}
+
+// watchFull rescan both trees by HandlePaths and sync
+func (srv *Server) watchFull(ctx context.Context, pair WatchPair) (err error) {
+	var res *SyncResult
+	var unlock func()
+
+	if unlock, err = srv.lockSync(pair.SrcPath, pair.DstPath); err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg := srv.cfg.Snapshot()
+	ctx, id := srv.runs.Start(
+		ctx,
+		SyncRun{Kind: RunKindWatch, SrcPath: pair.SrcPath, DstPaths: []string{pair.DstPath}},
+	)
+	res, err = srv.runSync(
+		ctx,
+		cfg,
+		SyncDirectoriesRequest{
+			SrcPath:        pair.SrcPath,
+			DstPath:        pair.DstPath,
+			MaxDiffPercent: watchDiffPercent(cfg, pair),
+			Mode:           ModeFull,
+		},
+	)
+	srv.runs.Finish(id, res, err)
+	return err
+}
+
+// watchTargeted sync changed directories one by one
+func (srv *Server) watchTargeted(
+	ctx context.Context,
+	pair WatchPair,
+	dirs []WatchDir,
+) (err error) {
+	var synchronizer Synchronizer
+	var unlock func()
+
+	if unlock, err = srv.lockSync(pair.SrcPath, pair.DstPath); err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg := srv.cfg.Snapshot()
+	synchronizer, err = srv.makeSynchronizer(
+		cfg,
+		SyncDirectoriesRequest{
+			SrcPath:        pair.SrcPath,
+			DstPath:        pair.DstPath,
+			MaxDiffPercent: watchDiffPercent(cfg, pair),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// counts of all directories
+	total := new(SyncResult)
+	count := func(res *SyncResult) {
+		if res != nil {
+			total.Succeeded += res.Succeeded
+			total.Failed += res.Failed
+		}
+	}
+	ctx, id := srv.runs.Start(
+		ctx,
+		SyncRun{Kind: RunKindWatch, SrcPath: pair.SrcPath, DstPaths: []string{pair.DstPath}},
+	)
+	defer func() { srv.runs.Finish(id, total, err) }()
+
+	for _, dir := range dirs {
+		var res *SyncResult
+
+		scanner := &Scanner{
+			SrcRoot:          pair.SrcPath,
+			DstRoot:          pair.DstPath,
+			Workers:          synchronizer.poolSize(cfg.ScanWorkers),
+			Root:             dir.Rel,
+			Shallow:          !dir.Recursive,
+			MaxDeletePercent: synchronizer.SrcDiffPercent,
+		}
+
+		res, err = synchronizer.SyncStream(ctx, scanner, srv.log)
+		count(res)
+
+		// changed directory may lose most of its entries, deletes
+		// are limited by whole tree then (remaining directories
+		// are synced by full rescan too)
+		if errors.Is(err, TooLargeDifferenceErr) && dir.Rel != "" {
+			srv.log.WithField("dir", dir.Rel).Infof("watch: %s, full rescan", err)
+
+			scanner.Root, scanner.Shallow = "", false
+			res, err = synchronizer.SyncStream(ctx, scanner, srv.log)
+			count(res)
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func watchDiffPercent(cfg *ServerConfig, pair WatchPair) int {
+	if pair.MaxDiffPercent > 0 {
+		return pair.MaxDiffPercent
+	}
+	return cfg.MaxDiffPercent
+}
@@ -0,0 +1,194 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyBufferSize enough for many events with long names
+const inotifyBufferSize = 64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)
+
+// inotifyMask events which change directory content
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotifyTree watch directory tree recursively
+type inotifyTree struct {
+	root  string
+	fd    int
+	file  *os.File
+	paths map[int]string
+}
+
+// watchTree register inotify watches on root and all nested directories
+// and send changes into events until ctx is done. New directories are
+// watched as they appear
+func watchTree(
+	ctx context.Context,
+	root string,
+	events chan<- watchEvent,
+) (err error) {
+	var fd int
+
+	if fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK); err != nil {
+		return err
+	}
+
+	// non-blocking fd is handled by runtime poller, so Read
+	// can be interrupted by Close
+	tree := &inotifyTree{
+		root:  root,
+		fd:    fd,
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		paths: make(map[int]string, DefaultDirAllocSize),
+	}
+	defer tree.file.Close()
+
+	if err = tree.add(root); err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = tree.file.Close()
+	}()
+
+	return tree.read(ctx, events)
+}
+
+// add watches for dir and all nested directories
+func (t *inotifyTree) add(dir string) error {
+	return filepath.WalkDir(
+		dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// removed while walk
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+
+			if !d.IsDir() {
+				return nil
+			}
+
+			wd, err := syscall.InotifyAddWatch(t.fd, path, inotifyMask)
+			if err != nil {
+				if errors.Is(err, syscall.ENOENT) {
+					return nil
+				}
+				return err
+			}
+
+			t.paths[wd] = path
+			return nil
+		},
+	)
+}
+
+// rewatch drop all watches and register them again
+func (t *inotifyTree) rewatch() error {
+	for wd := range t.paths {
+		_, _ = syscall.InotifyRmWatch(t.fd, uint32(wd))
+	}
+	clear(t.paths)
+	return t.add(t.root)
+}
+
+// read events until inotify file is closed
+func (t *inotifyTree) read(
+	ctx context.Context,
+	events chan<- watchEvent,
+) (err error) {
+	var n int
+
+	buf := make([]byte, inotifyBufferSize)
+
+	for {
+		if n, err = t.file.Read(buf); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBuf := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if ev, ok := t.convert(raw, nameBuf); ok {
+				select {
+				case <-ctx.Done():
+					return nil
+				case events <- ev:
+				}
+			}
+		}
+	}
+}
+
+// convert inotify event into watch event
+func (t *inotifyTree) convert(
+	raw *syscall.InotifyEvent,
+	nameBuf []byte,
+) (ev watchEvent, ok bool) {
+	mask := raw.Mask
+
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return watchEvent{Overflow: true}, true
+	}
+
+	dir, known := t.paths[int(raw.Wd)]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(t.paths, int(raw.Wd))
+		return ev, ok
+	}
+
+	if !known {
+		return ev, ok
+	}
+
+	// moved directories keep watches with old paths - register again
+	if mask&syscall.IN_MOVE_SELF != 0 ||
+		(mask&syscall.IN_MOVED_FROM != 0 && mask&syscall.IN_ISDIR != 0) {
+		// rewatch errors are covered by full rescan as well
+		_ = t.rewatch()
+		return watchEvent{Overflow: true}, true
+	}
+
+	if mask&syscall.IN_DELETE_SELF != 0 {
+		return ev, ok
+	}
+
+	name := string(nameBuf)
+	for len(name) > 0 && name[len(name)-1] == 0 {
+		name = name[:len(name)-1]
+	}
+
+	// lock file is changed by sync itself
+	if name == DirLockName && dir == t.root {
+		return ev, ok
+	}
+
+	// new directory - watch it and sync whole subtree
+	if mask&syscall.IN_ISDIR != 0 &&
+		mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		path := filepath.Join(dir, name)
+		if err := t.add(path); err != nil {
+			return watchEvent{Overflow: true}, true
+		}
+		return watchEvent{Dir: path, Recursive: true}, true
+	}
+
+	return watchEvent{Dir: dir}, true
+}
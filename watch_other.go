@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+)
+
+// watchTree is not supported without inotify
+func watchTree(
+	ctx context.Context,
+	root string,
+	events chan<- watchEvent,
+) (err error) {
+	return WatchNotSupported
+}
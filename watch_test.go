@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_reduceWatchDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending map[string]bool
+		want    []WatchDir
+	}{
+		{
+			name:    "test children of recursive directory dropped",
+			pending: map[string]bool{"a": true, "a/b": false, "a/b/c": true},
+			want:    []WatchDir{{Rel: "a", Recursive: true}},
+		},
+		{
+			name:    "test parents go first",
+			pending: map[string]bool{"x/y": false, "": false, "x": false},
+			want: []WatchDir{
+				{Rel: ""},
+				{Rel: "x"},
+				{Rel: "x/y"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, reduceWatchDirs(tt.pending))
+			},
+		)
+	}
+}
+
+func TestWatcher_Run(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify required")
+	}
+
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "a"), 0755))
+
+	var lock sync.Mutex
+	fulls := 0
+	changed := make(chan []WatchDir, 1)
+
+	w := &Watcher{
+		Pair:     WatchPair{SrcPath: src},
+		Debounce: 50 * time.Millisecond,
+		Full: func(ctx context.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+			fulls++
+			return nil
+		},
+		Targeted: func(ctx context.Context, dirs []WatchDir) error {
+			changed <- dirs
+			return nil
+		},
+		log: logrus.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	// wait for first full sync (watches are registered before)
+	require.Eventually(
+		t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return fulls == 1
+		}, time.Second, 10*time.Millisecond,
+	)
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a/f.txt"), nil, 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "n/m"), 0755))
+
+	select {
+	case dirs := <-changed:
+		require.Equal(
+			t,
+			[]WatchDir{
+				{Rel: "a", Recursive: false},
+				{Rel: "n", Recursive: true},
+			},
+			dirs,
+		)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no targeted sync")
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestServer_watchTargeted_deleteLimit(t *testing.T) {
+	root := map[string]string{"1.txt": "1", "2.txt": "2", "3.txt": "3", "4.txt": "4", "5.txt": "5"}
+
+	tests := []struct {
+		name    string
+		src     map[string]string
+		dst     map[string]string
+		wantErr bool
+	}{
+		{
+			name: "test directory over limit, tree under limit",
+			src:  map[string]string{"d/x.txt": "x"},
+			dst:  map[string]string{"d/x.txt": "x", "d/y.txt": "y", "d/z.txt": "z"},
+		},
+		{
+			name: "test tree over limit",
+			src:  map[string]string{"d/": ""},
+			dst: map[string]string{
+				"d/s.txt": "s", "d/t.txt": "t", "d/u.txt": "u", "d/v.txt": "v",
+				"d/w.txt": "w", "d/x.txt": "x", "d/y.txt": "y", "d/z.txt": "z",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg := loadConfig(t, testConfig)
+				srv, err := MakeServer(cfg, logrus.New())
+				require.NoError(t, err)
+
+				pair := WatchPair{SrcPath: cfg.Snapshot().SrcPath, DstPath: cfg.Snapshot().DstPath, MaxDiffPercent: 50}
+				makeTree(t, pair.SrcPath, root)
+				makeTree(t, pair.SrcPath, tt.src)
+				makeTree(t, pair.DstPath, root)
+				makeTree(t, pair.DstPath, tt.dst)
+
+				err = srv.watchTargeted(context.Background(), pair, []WatchDir{{Rel: "d"}})
+				if tt.wantErr {
+					require.ErrorIs(t, err, TooLargeDifferenceErr)
+					require.FileExists(t, filepath.Join(pair.DstPath, "d", "y.txt"))
+					return
+				}
+
+				require.NoError(t, err)
+				require.NoFileExists(t, filepath.Join(pair.DstPath, "d", "y.txt"))
+				require.FileExists(t, filepath.Join(pair.DstPath, "d", "x.txt"))
+			},
+		)
+	}
+}